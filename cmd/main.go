@@ -1,24 +1,201 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/saykoooo/calc_go/internal/agent"
 	"github.com/saykoooo/calc_go/internal/application"
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/internal/tracing"
+	"golang.org/x/term"
 )
 
 func main() {
 	argLength := len(os.Args[1:])
+	logger := logging.FromEnv()
+	// Для сообщений, которые пишутся до создания оркестратора и агента.
+	slog.SetDefault(logger)
 
-	if os.Args[1] == "--agent" {
-		agent.RunAgent()
+	if argLength > 0 && os.Args[1] == "--migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
 	}
-	if os.Args[1] == "--all" {
-		go agent.RunAgent()
+	if argLength > 0 && os.Args[1] == "--create-admin" {
+		os.Exit(runCreateAdmin(os.Args[2:]))
+	}
+
+	// SIGINT/SIGTERM останавливает все части процесса, в том числе в режиме --all.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if argLength > 0 && os.Args[1] == "--agent" {
+		traces := traceExporter("calc-agent")
+		agent.RunAgent(ctx, logger, traces)
+		closeTraces(traces)
+		return
+	}
+	var wg sync.WaitGroup
+	if argLength > 0 && os.Args[1] == "--all" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			traces := traceExporter("calc-agent")
+			agent.RunAgent(ctx, logger, traces)
+			closeTraces(traces)
+		}()
 	}
 	if argLength == 0 || os.Args[1] == "--all" {
-		app := application.New()
-		go app.RunGRPCServer()
-		app.RunServer()
+		traces := traceExporter("calc-orchestrator")
+		app := application.New(application.WithLogger(logger),
+			application.WithTraceExporter(traces))
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := app.RunGRPCServer(ctx); err != nil {
+				logger.Error("gRPC server stopped", "error", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := app.RunDebugServer(ctx); err != nil {
+				logger.Error("Debug server stopped", "error", err)
+			}
+		}()
+		if err := app.RunServer(ctx); err != nil {
+			logger.Error("Web server stopped", "error", err)
+		}
+		// HTTP сервер мог остановиться и сам, тогда останавливаем остальных.
+		stop()
+		wg.Wait()
+		app.Close()
+		closeTraces(traces)
+	}
+}
+
+// traceExporter открывает экспорт спанов по TRACE_EXPORT; nil - экспорт выключен.
+func traceExporter(service string) *tracing.Exporter {
+	traces, err := tracing.ExporterFromEnv(service)
+	if err != nil {
+		slog.Error("Failed to open trace export", "error", err)
+		os.Exit(1)
+	}
+	return traces
+}
+
+// closeTraces закрывает экспорт спанов после остановки серверов, которые
+// в него пишут.
+func closeTraces(traces *tracing.Exporter) {
+	if err := traces.Close(); err != nil {
+		slog.Error("Failed to close trace export", "error", err)
+	}
+}
+
+// runMigrate обрабатывает "--migrate [up|status|check]".
+// check завершается с кодом 1, если есть неприменённые миграции.
+func runMigrate(args []string) int {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	store, err := db.ConnectSQLite(db.DefaultFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	switch cmd {
+	case "up":
+		applied, err := store.Migrate()
+		for _, m := range applied {
+			fmt.Printf("applied %s\n", m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return 0
+	case "status", "check":
+		states, err := store.MigrationStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read migrations: %v\n", err)
+			return 1
+		}
+		pending := 0
+		for _, st := range states {
+			if st.Applied {
+				fmt.Printf("applied  %s (%s)\n", st.Name, st.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				pending++
+				fmt.Printf("pending  %s\n", st.Name)
+			}
+		}
+		if cmd == "check" && pending > 0 {
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate command: %s (expected up, status or check)\n", cmd)
+		return 2
+	}
+}
+
+// runCreateAdmin обрабатывает "--create-admin <login>". Пароль берётся из
+// ADMIN_PASSWORD или со стандартного ввода (с терминала - без эха).
+// Существующий пользователь назначается администратором без смены пароля.
+func runCreateAdmin(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: --create-admin <login>")
+		return 2
+	}
+	login := args[0]
+
+	store, err := db.OpenSQLite(db.DefaultFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if _, err := store.SelectUser(login); err != nil && password == "" {
+		password = readPassword()
+	}
+
+	created, err := application.EnsureAdmin(store, application.PolicyFromEnv(), login, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create admin: %v\n", err)
+		return 1
+	}
+	if created {
+		fmt.Printf("admin %s created\n", login)
+	} else {
+		fmt.Printf("user %s is now an admin\n", login)
+	}
+	return 0
+}
+
+// readPassword читает пароль со стандартного ввода: с терминала - без
+// эха, иначе - первую строку, например из конвейера.
+func readPassword() string {
+	fmt.Fprint(os.Stderr, "Password: ")
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, _ := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password)
 	}
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
 }
@@ -7,6 +7,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/stretchr/testify v1.10.0
 	golang.org/x/crypto v0.37.0
+	golang.org/x/term v0.31.0
 	google.golang.org/grpc v1.72.0
 	google.golang.org/protobuf v1.36.6
 )
@@ -1,8 +1,10 @@
 package agent
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"sync"
@@ -10,119 +12,334 @@ import (
 
 	"context"
 
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/internal/tracing"
 	"github.com/saykoooo/calc_go/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// idMetadataKey - ключ метаданных gRPC, в котором оркестратору передаётся ID агента.
+const idMetadataKey = "agent-id"
+
 type Task struct {
 	ID            string  `json:"id"`
 	Arg1          float64 `json:"arg1"`
 	Arg2          float64 `json:"arg2"`
 	Operation     string  `json:"operation"`
 	OperationTime int32   `json:"operation_time"`
+	// Trace - контекст трассировки из заголовка ответа GetTask; пустой,
+	// если выражение не трассируется.
+	Trace tracing.SpanContext `json:"-"`
 }
 
-var (
-	shutdownCh = make(chan struct{})
-	serverPort string
-	wg         sync.WaitGroup
-)
+// Config задаёт параметры агента. Если Client не указан, агент сам
+// подключается к оркестратору по адресу Addr.
+type Config struct {
+	Addr string
+	// ID - идентификатор агента в логах; по-умолчанию имя хоста и случайный суффикс.
+	ID             string
+	ComputingPower int
+	RetryInterval  time.Duration
+	RequestTimeout time.Duration
+	Client         proto.OrchestratorClient
+	Logger         *slog.Logger
+	// Traces - экспортёр спанов вычисления; nil - не писать.
+	Traces *tracing.Exporter
+	// HTTPAddr - адрес /healthz, /readyz и /metrics агента; пустой - не слушать.
+	HTTPAddr       string
+	OnTaskStarted  func(task Task)
+	OnTaskFinished func(task Task, result float64, err error)
+}
 
-var client proto.OrchestratorClient
+type Agent struct {
+	config  *Config
+	client  proto.OrchestratorClient
+	logger  *slog.Logger
+	metrics *agentMetrics
+}
 
-func initGRPCClient() {
-	conn, err := grpc.Dial("localhost:"+serverPort, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalf("Failed to connect to gRPC server: %v", err)
+func ConfigFromEnv() *Config {
+	config := new(Config)
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = "5000"
+	}
+	config.Addr = "localhost:" + port
+	config.ComputingPower, _ = strconv.Atoi(os.Getenv("COMPUTING_POWER"))
+	config.ID = os.Getenv("AGENT_ID")
+	if port := os.Getenv("AGENT_HTTP_PORT"); port != "" {
+		config.HTTPAddr = ":" + port
 	}
-	client = proto.NewOrchestratorClient(conn)
+	return config
 }
 
-func RunAgent() {
-	serverPort = os.Getenv("GRPC_PORT")
-	if serverPort == "" {
-		serverPort = "5000"
+// New создаёт агента. Значения по-умолчанию подставляются в копию config,
+// так что один Config можно использовать для нескольких агентов.
+func New(config *Config) *Agent {
+	c := *config
+	config = &c
+	if config.ComputingPower <= 0 {
+		config.ComputingPower = 1
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = 5 * time.Second
+	}
+	if config.ID == "" {
+		config.ID = defaultID()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Agent{
+		config:  config,
+		client:  config.Client,
+		logger:  logger.With(logging.AgentID, config.ID),
+		metrics: newAgentMetrics(),
+	}
+}
+
+// Run запускает рабочие горутины и блокируется до отмены ctx.
+func (a *Agent) Run(ctx context.Context) error {
+	if a.client == nil {
+		conn, err := grpc.NewClient(a.config.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return fmt.Errorf("failed to connect to gRPC server: %w", err)
+		}
+		defer conn.Close()
+		a.client = proto.NewOrchestratorClient(conn)
 	}
 
-	initGRPCClient()
-	computingPower, _ := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
-	if computingPower <= 0 {
-		computingPower = 1
+	if a.config.HTTPAddr != "" {
+		if err := a.serveHTTP(ctx); err != nil {
+			return fmt.Errorf("failed to start HTTP server: %w", err)
+		}
 	}
 
-	log.Printf("Agent: Starting %d worker threads", computingPower)
+	a.logger.Info("Agent: Starting worker threads", "workers", a.config.ComputingPower)
+	a.metrics.workers.Add(float64(a.config.ComputingPower), "idle")
+	a.metrics.workers.Add(0, "busy")
 
-	wg.Add(computingPower)
-	for i := 0; i < computingPower; i++ {
-		go worker()
+	var wg sync.WaitGroup
+	wg.Add(a.config.ComputingPower)
+	for i := 0; i < a.config.ComputingPower; i++ {
+		go func() {
+			defer wg.Done()
+			a.worker(ctx)
+		}()
 	}
 
-	<-shutdownCh
-	log.Println("Agent: Shutting down agent...")
+	<-ctx.Done()
+	a.logger.Info("Agent: Shutting down agent")
 	wg.Wait()
+	return nil
+}
+
+// RunAgent запускает агента с настройками из переменных окружения
+// и останавливает его при отмене ctx.
+func RunAgent(ctx context.Context, logger *slog.Logger, traces *tracing.Exporter) {
+	config := ConfigFromEnv()
+	config.Logger = logger
+	config.Traces = traces
+	if err := New(config).Run(ctx); err != nil {
+		logger.Error("Agent: Failed to run", "error", err)
+		os.Exit(1)
+	}
 }
 
-func worker() {
-	defer wg.Done()
+func defaultID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+func (a *Agent) worker(ctx context.Context) {
 	for {
 		select {
-		case <-shutdownCh:
+		case <-ctx.Done():
 			return
 		default:
-			task, err := getTask()
+			task, err := a.getTask(ctx)
 			if err != nil {
-				log.Printf("Agent: Failed to get task: %v. Retrying in 1 second...", err)
-				time.Sleep(1 * time.Second)
+				if ctx.Err() != nil {
+					return
+				}
+				if status.Code(err) == codes.NotFound {
+					a.logger.Debug("Agent: No task available", "retry_in", a.config.RetryInterval)
+				} else {
+					a.logger.Warn("Agent: Failed to get task", "error", err, "retry_in", a.config.RetryInterval)
+				}
+				a.sleep(ctx, a.config.RetryInterval)
 				continue
 			}
 
-			log.Printf("Agent: Received task: ID=%s, Operation=%s, Arg1=%.2f, Arg2=%.2f",
-				task.ID, task.Operation, task.Arg1, task.Arg2)
-
-			result, err := compute(task.Arg1, task.Arg2, task.Operation)
-			if err != nil {
-				log.Printf("Agent: Error during computation: %v", err)
-				continue
+			if !a.process(ctx, *task) {
+				return
 			}
+		}
+	}
+}
 
-			log.Printf("Agent: Computation result for task %s: %.2f", task.ID, result)
+// process вычисляет задачу и отправляет результат. Возвращает false,
+// если ctx отменён.
+func (a *Agent) process(ctx context.Context, task Task) bool {
+	a.metrics.busy(1)
+	defer a.metrics.busy(-1)
 
-			time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+	logger := a.logger.With(logging.NodeID, task.ID)
+	if task.Trace.IsValid() {
+		logger = logger.With(logging.TraceID, task.Trace.TraceID)
+	}
+	span := a.startSpan(task)
+	logger.Debug("Agent: Received task", "operation", task.Operation, "arg1", task.Arg1, "arg2", task.Arg2)
+	if a.config.OnTaskStarted != nil {
+		a.config.OnTaskStarted(task)
+	}
 
-			if err := sendResult(task.ID, result); err != nil {
-				log.Printf("Agent: Failed to send result for task %s: %v", task.ID, err)
-			}
+	result, err := compute(task.Arg1, task.Arg2, task.Operation)
+	if err != nil {
+		// Ошибку сообщаем оркестратору сразу: выражение завершится со
+		// статусом "failed", ждать время операции незачем.
+		logger.Warn("Agent: Error during computation", "error", err)
+		a.metrics.computeErrors.Inc(task.Operation)
+		if serr := a.sendResult(a.traced(ctx, span), task.ID, 0, err.Error()); serr != nil {
+			logger.Warn("Agent: Failed to report computation error", "error", serr)
 		}
+		a.endSpan(logger, span, err)
+		a.finished(task, 0, err)
+		return true
+	}
+
+	logger.Debug("Agent: Computation result", "result", result)
+
+	start := time.Now()
+	ok := a.sleep(ctx, time.Duration(task.OperationTime)*time.Millisecond)
+	a.metrics.sleep.Add(time.Since(start).Seconds(), task.Operation)
+	if !ok {
+		return false
+	}
+
+	err = a.sendResult(a.traced(ctx, span), task.ID, result, "")
+	if err != nil {
+		logger.Warn("Agent: Failed to send result", "error", err)
+	} else {
+		a.metrics.completed.Inc(task.Operation)
+	}
+	a.endSpan(logger, span, err)
+	a.finished(task, result, err)
+	return true
+}
+
+// traced передаёт оркестратору спан вычисления, если он есть.
+func (a *Agent) traced(ctx context.Context, span *tracing.Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, tracing.TraceparentKey, span.Traceparent())
+}
+
+// startSpan начинает спан вычисления задачи, если её выражение
+// трассируется и экспорт включён.
+func (a *Agent) startSpan(task Task) *tracing.Span {
+	if !a.config.Traces.Enabled() || !task.Trace.IsValid() {
+		return nil
+	}
+	return &tracing.Span{
+		SpanContext: tracing.SpanContext{TraceID: task.Trace.TraceID, SpanID: tracing.NewSpanID()},
+		Parent:      task.Trace.SpanID,
+		Name:        "compute " + task.Operation,
+		Kind:        tracing.KindClient,
+		Start:       time.Now(),
+		Attrs: map[string]any{
+			logging.NodeID:  task.ID,
+			logging.AgentID: a.config.ID,
+			"operation":     task.Operation,
+		},
+	}
+}
+
+func (a *Agent) endSpan(logger *slog.Logger, span *tracing.Span, err error) {
+	if span == nil {
+		return
+	}
+	span.End = time.Now()
+	if err != nil {
+		span.Err = err.Error()
+	}
+	if err := a.config.Traces.Export(*span); err != nil {
+		logger.Warn("Agent: Failed to export span", "error", err)
+	}
+}
+
+func (a *Agent) finished(task Task, result float64, err error) {
+	if a.config.OnTaskFinished != nil {
+		a.config.OnTaskFinished(task, result, err)
 	}
 }
 
-func getTask() (*Task, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// sleep ждёт d или отмены ctx. Возвращает false, если ctx отменён.
+func (a *Agent) sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (a *Agent) getTask(ctx context.Context) (*Task, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.config.RequestTimeout)
 	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, idMetadataKey, a.config.ID)
 
-	resp, err := client.GetTask(ctx, &proto.GetTaskRequest{})
+	var header metadata.MD
+	resp, err := a.client.GetTask(ctx, &proto.GetTaskRequest{}, grpc.Header(&header))
+	a.metrics.rpcDone("GetTask", err)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Task{
+	task := &Task{
 		ID:            resp.Id,
 		Arg1:          resp.Arg1,
 		Arg2:          resp.Arg2,
 		Operation:     resp.Operation,
 		OperationTime: int32(resp.OperationTime),
-	}, nil
+	}
+	if vals := header.Get(tracing.TraceparentKey); len(vals) > 0 {
+		if sc, ok := tracing.ParseTraceparent(vals[0]); ok {
+			task.Trace = sc
+		}
+	}
+	return task, nil
 }
 
-func sendResult(id string, result float64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// sendResult отправляет результат узла id или, если errMsg не пуст,
+// ошибку его вычисления.
+func (a *Agent) sendResult(ctx context.Context, id string, result float64, errMsg string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.config.RequestTimeout)
 	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, idMetadataKey, a.config.ID)
 
-	_, err := client.SubmitResult(ctx, &proto.ResultRequest{
+	_, err := a.client.SubmitResult(ctx, &proto.ResultRequest{
 		Id:     id,
 		Result: result,
+		Error:  errMsg,
 	})
+	a.metrics.rpcDone("SubmitResult", err)
 	return err
 }
 
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/saykoooo/calc_go/proto"
 	"github.com/stretchr/testify/assert"
@@ -55,9 +56,21 @@ func (m *MockOrchestratorClient) SubmitResult(ctx context.Context, in *proto.Res
 	return args.Get(0).(*proto.SubmitResultResponse), args.Error(1)
 }
 
+func TestNewKeepsCallerConfig(t *testing.T) {
+	config := &Config{Client: new(MockOrchestratorClient)}
+	first, second := New(config), New(config)
+
+	assert.Equal(t, Config{Client: config.Client}, *config, "New must not fill defaults into the caller's config")
+	assert.Equal(t, 1, first.config.ComputingPower)
+	assert.Equal(t, time.Second, first.config.RetryInterval)
+	// Каждый агент получает свой ID, а не ID первого.
+	assert.NotEmpty(t, first.config.ID)
+	assert.NotEqual(t, first.config.ID, second.config.ID)
+}
+
 func TestGetTask(t *testing.T) {
 	mockClient := new(MockOrchestratorClient)
-	client = mockClient
+	a := New(&Config{Client: mockClient})
 
 	expectedTask := &proto.TaskResponse{
 		Id:            "task1",
@@ -69,7 +82,7 @@ func TestGetTask(t *testing.T) {
 
 	mockClient.On("GetTask", mock.Anything, &proto.GetTaskRequest{}).Return(expectedTask, nil)
 
-	task, err := getTask()
+	task, err := a.getTask(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, expectedTask.Id, task.ID)
 	assert.Equal(t, expectedTask.Arg1, task.Arg1)
@@ -82,14 +95,34 @@ func TestGetTask(t *testing.T) {
 
 func TestSendResult(t *testing.T) {
 	mockClient := new(MockOrchestratorClient)
-	client = mockClient
+	a := New(&Config{Client: mockClient})
 
 	mockClient.On("SubmitResult", mock.Anything, &proto.ResultRequest{
 		Id:     "task1",
 		Result: 5,
 	}).Return(&proto.SubmitResultResponse{}, nil)
 
-	err := sendResult("task1", 5)
+	err := a.sendResult(context.Background(), "task1", 5, "")
 	assert.NoError(t, err)
 	mockClient.AssertExpectations(t)
 }
+
+func TestProcessReportsComputeError(t *testing.T) {
+	mockClient := new(MockOrchestratorClient)
+	var finishedErr error
+	a := New(&Config{
+		Client:         mockClient,
+		OnTaskFinished: func(_ Task, _ float64, err error) { finishedErr = err },
+	})
+
+	mockClient.On("SubmitResult", mock.Anything, &proto.ResultRequest{
+		Id:    "task1",
+		Error: "division by zero",
+	}).Return(&proto.SubmitResultResponse{}, nil)
+
+	// Время операции не ждётся: ошибка отправляется сразу.
+	ok := a.process(context.Background(), Task{ID: "task1", Arg1: 1, Operation: "/", OperationTime: 60_000})
+	assert.True(t, ok)
+	assert.EqualError(t, finishedErr, "division by zero")
+	mockClient.AssertExpectations(t)
+}
@@ -1,23 +1,26 @@
 package agent
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/tracing"
 	"github.com/saykoooo/calc_go/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/test/bufconn"
 )
 
 const bufSize = 1024 * 1024
 
-var lis *bufconn.Listener
-
 type testServer struct {
 	proto.UnimplementedOrchestratorServer
 }
@@ -38,42 +41,83 @@ func (s *testServer) SubmitResult(ctx context.Context, req *proto.ResultRequest)
 	return &proto.SubmitResultResponse{}, nil
 }
 
-func initTestGRPCServer() {
-	lis = bufconn.Listen(bufSize)
-	s := grpc.NewServer()
-	proto.RegisterOrchestratorServer(s, &testServer{})
-	go func() {
-		if err := s.Serve(lis); err != nil {
-			panic(err)
-		}
-	}()
+// countingServer раздаёт limit задач и считает присланные результаты.
+type countingServer struct {
+	proto.UnimplementedOrchestratorServer
+	limit   int64
+	issued  atomic.Int64
+	results atomic.Int64
 }
 
-func bufDialer(context.Context, string) (net.Conn, error) {
-	return lis.Dial()
+func (s *countingServer) GetTask(ctx context.Context, req *proto.GetTaskRequest) (*proto.TaskResponse, error) {
+	n := s.issued.Add(1)
+	if n > s.limit {
+		return nil, fmt.Errorf("no task available")
+	}
+	return &proto.TaskResponse{
+		Id:        fmt.Sprintf("task-%d", n),
+		Arg1:      float64(n),
+		Arg2:      1,
+		Operation: "*",
+	}, nil
 }
 
-func TestAgent_Integration(t *testing.T) {
-	db.Init(":memory:")
-	defer db.Stop()
+func (s *countingServer) SubmitResult(ctx context.Context, req *proto.ResultRequest) (*proto.SubmitResultResponse, error) {
+	s.results.Add(1)
+	return &proto.SubmitResultResponse{}, nil
+}
+
+// tracingServer выдаёт задачу с контекстом трассировки в заголовке ответа
+// и запоминает traceparent, с которым пришёл результат.
+type tracingServer struct {
+	testServer
+	span        tracing.SpanContext
+	traceparent chan string
+}
 
-	initTestGRPCServer()
+func (s *tracingServer) GetTask(ctx context.Context, req *proto.GetTaskRequest) (*proto.TaskResponse, error) {
+	grpc.SetHeader(ctx, metadata.Pairs(tracing.TraceparentKey, s.span.Traceparent()))
+	return s.testServer.GetTask(ctx, req)
+}
+
+func (s *tracingServer) SubmitResult(ctx context.Context, req *proto.ResultRequest) (*proto.SubmitResultResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.traceparent <- strings.Join(md.Get(tracing.TraceparentKey), ",")
+	return s.testServer.SubmitResult(ctx, req)
+}
 
-	serverPort = "50051"
-	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+func initTestGRPCServer(t *testing.T, srv proto.OrchestratorServer) proto.OrchestratorClient {
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	proto.RegisterOrchestratorServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		t.Fatalf("Failed to dial buffer net: %v", err)
 	}
-	client = proto.NewOrchestratorClient(conn)
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewOrchestratorClient(conn)
+}
+
+func TestAgent_Integration(t *testing.T) {
+	a := New(&Config{Client: initTestGRPCServer(t, &testServer{})})
+	ctx := context.Background()
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 
 	go func() {
 		defer wg.Done()
-		task, err := getTask()
+		task, err := a.getTask(ctx)
 		if err != nil {
 			t.Errorf("Failed to get task: %v", err)
+			return
 		}
 		if task.Operation != "+" || task.Arg1 != 2 || task.Arg2 != 3 {
 			t.Errorf("Unexpected task: %+v", task)
@@ -87,7 +131,7 @@ func TestAgent_Integration(t *testing.T) {
 			t.Errorf("Expected 5, got %.2f", result)
 		}
 
-		err = sendResult(task.ID, result)
+		err = a.sendResult(ctx, task.ID, result, "")
 		if err != nil {
 			t.Errorf("Failed to send result: %v", err)
 		}
@@ -95,3 +139,97 @@ func TestAgent_Integration(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestAgent_RunMultiple(t *testing.T) {
+	const tasks = 20
+	srv := &countingServer{limit: tasks}
+	client := initTestGRPCServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var started, finished atomic.Int64
+	done := make(chan struct{})
+	newAgent := func() *Agent {
+		return New(&Config{
+			ComputingPower: 2,
+			RetryInterval:  10 * time.Millisecond,
+			Client:         client,
+			OnTaskStarted:  func(Task) { started.Add(1) },
+			OnTaskFinished: func(task Task, result float64, err error) {
+				if err != nil {
+					t.Errorf("task %s failed: %v", task.ID, err)
+				}
+				if result != task.Arg1 {
+					t.Errorf("task %s: expected %v, got %v", task.ID, task.Arg1, result)
+				}
+				if finished.Add(1) == tasks {
+					close(done)
+				}
+			},
+		})
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- newAgent().Run(ctx) }()
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatalf("timed out: %d of %d tasks finished", finished.Load(), tasks)
+	}
+	cancel()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Run returned error: %v", err)
+		}
+	}
+	if started.Load() != tasks || srv.results.Load() != tasks {
+		t.Errorf("expected %d tasks, started %d, submitted %d", tasks, started.Load(), srv.results.Load())
+	}
+}
+
+func TestAgent_Tracing(t *testing.T) {
+	srv := &tracingServer{
+		span:        tracing.SpanContext{TraceID: tracing.NewTraceID(), SpanID: tracing.NewSpanID()},
+		traceparent: make(chan string, 1),
+	}
+	var spans bytes.Buffer
+	a := New(&Config{
+		ID:     "agent-7",
+		Client: initTestGRPCServer(t, srv),
+		Traces: tracing.NewExporter("calc-agent", &spans),
+	})
+	ctx := context.Background()
+
+	task, err := a.getTask(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	if task.Trace != srv.span {
+		t.Fatalf("Expected trace from the response header, got %+v", task.Trace)
+	}
+	if !a.process(ctx, *task) {
+		t.Fatal("process was cancelled")
+	}
+
+	sent, ok := tracing.ParseTraceparent(<-srv.traceparent)
+	if !ok || sent.TraceID != srv.span.TraceID || sent.SpanID == srv.span.SpanID {
+		t.Errorf("Expected the result to carry the agent span, got %+v", sent)
+	}
+	out := spans.String()
+	for _, want := range []string{
+		`"traceId":"` + sent.TraceID + `"`,
+		`"spanId":"` + sent.SpanID + `"`,
+		`"parentSpanId":"` + srv.span.SpanID + `"`,
+		`"name":"compute +"`,
+		`{"key":"agent_id","value":{"stringValue":"agent-7"}}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in exported span:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// agentMetrics - метрики агента, которые отдаются на /metrics.
+type agentMetrics struct {
+	registry      *metrics.Registry
+	completed     *metrics.Counter
+	computeErrors *metrics.Counter
+	workers       *metrics.Gauge
+	rpcErrors     *metrics.Counter
+	emptyPolls    *metrics.Counter
+	sleep         *metrics.Counter
+
+	// reachable - последний запрос к оркестратору дошёл до него.
+	reachable atomic.Bool
+}
+
+func newAgentMetrics() *agentMetrics {
+	r := metrics.NewRegistry()
+	return &agentMetrics{
+		registry: r,
+		completed: r.NewCounter("agent_tasks_completed_total",
+			"Tasks computed and submitted to the orchestrator.", "operation"),
+		computeErrors: r.NewCounter("agent_compute_errors_total",
+			"Tasks that could not be computed, e.g. division by zero.", "operation"),
+		workers: r.NewGauge("agent_workers",
+			"Worker goroutines busy with a task or idle waiting for one.", "state"),
+		rpcErrors: r.NewCounter("agent_rpc_errors_total",
+			"Failed calls to the orchestrator by method and gRPC code.", "method", "code"),
+		emptyPolls: r.NewCounter("agent_get_task_empty_total",
+			"GetTask calls answered with an empty queue."),
+		sleep: r.NewCounter("agent_operation_sleep_seconds_total",
+			"Time spent in the simulated operation time.", "operation"),
+	}
+}
+
+// rpcDone учитывает результат вызова оркестратора. Пустая очередь -
+// не ошибка. Ответ с ошибкой самого оркестратора (NotFound, Unknown)
+// означает, что он доступен, в отличие от Unavailable или таймаута.
+func (m *agentMetrics) rpcDone(method string, err error) {
+	code := status.Code(err)
+	switch {
+	case err == nil:
+	case method == "GetTask" && code == codes.NotFound:
+		m.emptyPolls.Inc()
+	case code == codes.Canceled:
+		return
+	default:
+		m.rpcErrors.Inc(method, code.String())
+	}
+	m.reachable.Store(err == nil || code == codes.NotFound || code == codes.Unknown)
+}
+
+// busy переводит одну рабочую горутину из простоя в работу или обратно.
+func (m *agentMetrics) busy(delta float64) {
+	m.workers.Add(delta, "busy")
+	m.workers.Add(-delta, "idle")
+}
+
+// Handler возвращает HTTP API агента:
+//   - /healthz - процесс жив;
+//   - /readyz - последний запрос к оркестратору дошёл до него;
+//   - /metrics - метрики в текстовом формате Prometheus.
+func (a *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !a.metrics.reachable.Load() {
+			http.Error(w, "orchestrator is not reachable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("GET /metrics", a.metrics.registry.Handler())
+	return mux
+}
+
+// serveHTTP обслуживает Handler на config.HTTPAddr до отмены ctx.
+func (a *Agent) serveHTTP(ctx context.Context) error {
+	lis, err := net.Listen("tcp", a.config.HTTPAddr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: a.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	a.logger.Info("Agent: Serving health and metrics", "addr", lis.Addr().String())
+	go func() {
+		if err := server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Error("Agent: HTTP server stopped", "error", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/proto"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scriptedServer раздаёт задачи tasks по очереди, затем отвечает пустой очередью.
+type scriptedServer struct {
+	proto.UnimplementedOrchestratorServer
+	tasks []*proto.TaskResponse
+	next  atomic.Int64
+	empty chan struct{}
+}
+
+func (s *scriptedServer) GetTask(ctx context.Context, req *proto.GetTaskRequest) (*proto.TaskResponse, error) {
+	n := s.next.Add(1)
+	if int(n) > len(s.tasks) {
+		if int(n) == len(s.tasks)+1 {
+			close(s.empty)
+		}
+		return nil, status.Error(codes.NotFound, "no task available")
+	}
+	return s.tasks[n-1], nil
+}
+
+func (s *scriptedServer) SubmitResult(ctx context.Context, req *proto.ResultRequest) (*proto.SubmitResultResponse, error) {
+	return &proto.SubmitResultResponse{}, nil
+}
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestAgent_Metrics(t *testing.T) {
+	srv := &scriptedServer{
+		tasks: []*proto.TaskResponse{
+			{Id: "t1", Arg1: 1, Arg2: 2, Operation: "+", OperationTime: 20},
+			{Id: "t2", Arg1: 1, Arg2: 0, Operation: "/"},
+		},
+		empty: make(chan struct{}),
+	}
+	a := New(&Config{Client: initTestGRPCServer(t, srv), RetryInterval: 10 * time.Millisecond})
+	h := a.Handler()
+
+	if w := get(t, h, "/healthz"); w.Code != http.StatusOK {
+		t.Errorf("Expected healthz 200, got %d", w.Code)
+	}
+	if w := get(t, h, "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readyz 503 before contacting the orchestrator, got %d", w.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { errs <- a.Run(ctx) }()
+	select {
+	case <-srv.empty:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the agent to drain the queue")
+	}
+
+	if w := get(t, h, "/readyz"); w.Code != http.StatusOK {
+		t.Errorf("Expected readyz 200 after an empty queue answer, got %d", w.Code)
+	}
+	body := get(t, h, "/metrics").Body.String()
+	for _, want := range []string{
+		`agent_tasks_completed_total{operation="+"} 1`,
+		`agent_compute_errors_total{operation="/"} 1`,
+		`agent_workers{state="busy"} 0`,
+		`agent_workers{state="idle"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("Expected %q in metrics:\n%s", want, body)
+		}
+	}
+	if !strings.Contains(body, "agent_get_task_empty_total ") || strings.Contains(body, "agent_rpc_errors_total{") {
+		t.Errorf("Expected empty polls without RPC errors:\n%s", body)
+	}
+	if v := a.metrics.sleep.Value("+"); v < 0.02 {
+		t.Errorf("Expected at least 20ms of simulated work, got %v", v)
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+}
+
+func TestAgent_ReadyzUnreachable(t *testing.T) {
+	mockClient := new(MockOrchestratorClient)
+	a := New(&Config{Client: mockClient})
+	mockClient.On("GetTask", mock.Anything, mock.Anything).
+		Return((*proto.TaskResponse)(nil), status.Error(codes.Unavailable, "connection refused"))
+
+	a.getTask(context.Background())
+
+	if w := get(t, a.Handler(), "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readyz 503, got %d", w.Code)
+	}
+	if v := a.metrics.rpcErrors.Value("GetTask", "Unavailable"); v != 1 {
+		t.Errorf("Expected one Unavailable error, got %v", v)
+	}
+}
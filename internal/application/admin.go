@@ -0,0 +1,236 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+)
+
+// requireAdmin пропускает только запросы администраторов.
+// Роль проставляет AuthMiddleware.
+func (a *Application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("role") != db.RoleAdmin {
+			a.requestLogger(r).Warn("Admin endpoint denied")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly - цепочка для административных обработчиков:
+// только JWT сессия администратора, API ключи не принимаются.
+func (a *Application) adminOnly(h http.HandlerFunc) http.Handler {
+	return a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(a.requireAdmin(h))))
+}
+
+type UserInfo struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+func (a *Application) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := a.store.SelectUsers()
+	if err != nil {
+		a.requestLogger(r).Error("Error while getting users", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	infos := make([]UserInfo, 0, len(users))
+	for _, u := range users {
+		infos = append(infos, UserInfo{ID: u.ID, Name: u.Name, Role: u.Role, Disabled: u.Disabled})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]UserInfo{"users": infos})
+}
+
+// UpdateUserHandler меняет роль и блокировку пользователя.
+// Администратор не может заблокировать или понизить сам себя.
+func (a *Application) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Role != nil && *req.Role != db.RoleUser && *req.Role != db.RoleAdmin {
+		http.Error(w, "unknown role: "+*req.Role, http.StatusBadRequest)
+		return
+	}
+	name := r.PathValue("name")
+	if name == r.Header.Get("username") {
+		http.Error(w, "cannot change own account", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if req.Role != nil {
+		err = a.store.SetUserRole(name, *req.Role)
+	}
+	if err == nil && req.Disabled != nil {
+		err = a.store.SetUserDisabled(name, *req.Disabled)
+	}
+	if !a.writeUserError(w, r, name, err) {
+		return
+	}
+	a.requestLogger(r).Info("User updated", "target", name)
+
+	user, err := a.store.SelectUser(name)
+	if !a.writeUserError(w, r, name, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UserInfo{ID: user.ID, Name: user.Name, Role: user.Role, Disabled: user.Disabled})
+}
+
+// DeleteUserHandler удаляет пользователя вместе с его выражениями и ключами.
+func (a *Application) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == r.Header.Get("username") {
+		http.Error(w, "cannot delete own account", http.StatusBadRequest)
+		return
+	}
+	if _, err := a.store.SelectUser(name); !a.writeUserError(w, r, name, err) {
+		return
+	}
+
+	if !a.writeUserError(w, r, name, a.deleteUser(name)) {
+		return
+	}
+	a.requestLogger(r).Info("User deleted", "target", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUser удаляет пользователя и убирает узлы его невычисленных
+// выражений из очереди, чтобы агенты не получали задачи удалённых выражений.
+func (a *Application) deleteUser(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	exprs, err := a.store.SelectExpressionsByUser(name)
+	if err != nil {
+		return err
+	}
+	if err := a.store.DeleteUser(name); err != nil {
+		return err
+	}
+	for _, expr := range exprs {
+		a.deps.remove(expr.ExprID)
+	}
+	return nil
+}
+
+// writeUserError отвечает ошибкой, если err != nil, и возвращает false.
+func (a *Application) writeUserError(w http.ResponseWriter, r *http.Request, name string, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		a.requestLogger(r).Error("Error while updating user", "target", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// GetAnyExpressionHandler возвращает выражение любого пользователя.
+func (a *Application) GetAnyExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	a.mu.Lock()
+	a.expireOverdue()
+	expr, err := a.store.SelectExpression(id)
+	a.mu.Unlock()
+	if err != nil {
+		a.requestLogger(r).Info("Error while getting expression", logging.ExprID, id, "error", err)
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Expression struct {
+			ExpressionStatus
+			Username string `json:"username"`
+		} `json:"expression"`
+	}{}
+	response.Expression.ExpressionStatus = newExpressionStatus(expr)
+	response.Expression.Username = expr.Username
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PurgeHandler удаляет завершённые выражения одного пользователя или всех.
+func (a *Application) PurgeHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	num, err := a.store.PurgeExpressions(req.User)
+	a.mu.Unlock()
+	if err != nil {
+		a.requestLogger(r).Error("Error while purging expressions", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	a.requestLogger(r).Info("Expressions purged", "count", num, "target", req.User)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"deleted": num})
+}
+
+// EnsureAdmin создаёт администратора login с паролем password или, если
+// пользователь уже есть, назначает его администратором и разблокирует
+// (пароль при этом не меняется). Логин и пароль нового пользователя
+// проверяются правилами регистрации из config; nil - правила по-умолчанию.
+// Возвращает true, если пользователь создан.
+func EnsureAdmin(store db.Store, config *Config, login, password string) (bool, error) {
+	if login == "" {
+		return false, errors.New("empty login")
+	}
+	if _, err := store.SelectUser(login); err == nil {
+		if err := store.SetUserRole(login, db.RoleAdmin); err != nil {
+			return false, err
+		}
+		return false, store.SetUserDisabled(login, false)
+	} else if !errors.Is(err, db.ErrNotFound) {
+		return false, err
+	}
+
+	if password == "" {
+		return false, errors.New("empty password")
+	}
+	var policy Config
+	if config != nil {
+		policy = *config
+	}
+	policy.applyPolicyDefaults()
+	if err := policy.validateLogin(login); err != nil {
+		return false, err
+	}
+	if err := policy.validatePassword(login, password); err != nil {
+		return false, err
+	}
+	hash, err := db.GenerateHash(password)
+	if err != nil {
+		return false, err
+	}
+	if _, err := store.InsertUser(&db.User{Name: login, Password: hash, Role: db.RoleAdmin}); err != nil {
+		return false, fmt.Errorf("failed to create user %s: %w", login, err)
+	}
+	return true, nil
+}
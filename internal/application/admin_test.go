@@ -0,0 +1,194 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+func loginAdmin(t *testing.T, app *Application, login string) string {
+	t.Helper()
+	if _, err := EnsureAdmin(app.store, app.config, login, "Admin-pass-1"); err != nil {
+		t.Fatalf("EnsureAdmin: %v", err)
+	}
+	return loginTestUser(t, app, login, "Admin-pass-1")["token"]
+}
+
+func TestAdmin_Forbidden(t *testing.T) {
+	app := newTestApp(t)
+	token := loginTestUser(t, app, "plain", "pass")["token"]
+
+	for _, tc := range []struct{ method, path string }{
+		{"GET", "/api/v1/admin/users"},
+		{"PATCH", "/api/v1/admin/users/plain"},
+		{"DELETE", "/api/v1/admin/users/plain"},
+		{"GET", "/api/v1/admin/expressions/any"},
+		{"POST", "/api/v1/admin/purge"},
+	} {
+		if w := doRequest(app, tc.method, tc.path, token, `{}`); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected 403 for regular user, got %d", tc.method, tc.path, w.Code)
+		}
+	}
+	if w := doRequest(app, "GET", "/api/v1/admin/users", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", w.Code)
+	}
+}
+
+func TestAdmin_ManageUsers(t *testing.T) {
+	app := newTestApp(t)
+	admin := loginAdmin(t, app, "boss")
+	userTokens := loginTestUser(t, app, "worker", "pass")
+
+	parsed, _, _ := jwt.NewParser().ParseUnverified(admin, jwt.MapClaims{})
+	if role := parsed.Claims.(jwt.MapClaims)["role"]; role != db.RoleAdmin {
+		t.Errorf("Expected role claim admin, got %v", role)
+	}
+
+	w := doRequest(app, "GET", "/api/v1/admin/users", admin, "")
+	var list struct {
+		Users []UserInfo `json:"users"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != http.StatusOK || len(list.Users) != 2 || list.Users[1].Role != db.RoleUser {
+		t.Fatalf("Unexpected user list: %d %+v", w.Code, list.Users)
+	}
+
+	// Блокировка действует сразу, в том числе на уже выданные токены.
+	if w := doRequest(app, "PATCH", "/api/v1/admin/users/worker", admin, `{"disabled": true}`); w.Code != http.StatusOK {
+		t.Fatalf("disable: expected 200, got %d", w.Code)
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", userTokens["token"], ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for disabled user, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/login", "", `{"login": "worker", "password": "pass"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected login of disabled user to fail with 403, got %d", w.Code)
+	}
+	if w, _ := refresh(app, userTokens["refresh_token"]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected refresh of disabled user to fail, got %d", w.Code)
+	}
+
+	w = doRequest(app, "PATCH", "/api/v1/admin/users/worker", admin, `{"disabled": false, "role": "admin"}`)
+	var info UserInfo
+	json.NewDecoder(w.Body).Decode(&info)
+	if w.Code != http.StatusOK || info.Disabled || info.Role != db.RoleAdmin {
+		t.Errorf("Expected enabled admin, got %d %+v", w.Code, info)
+	}
+	if w := doRequest(app, "GET", "/api/v1/admin/users", userTokens["token"], ""); w.Code != http.StatusOK {
+		t.Errorf("Expected promoted user to reach admin API with old token, got %d", w.Code)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"PATCH", "/api/v1/admin/users/worker", `{"role": "root"}`, http.StatusBadRequest},
+		{"PATCH", "/api/v1/admin/users/boss", `{"disabled": true}`, http.StatusBadRequest},
+		{"DELETE", "/api/v1/admin/users/boss", ``, http.StatusBadRequest},
+		{"PATCH", "/api/v1/admin/users/ghost", `{"disabled": true}`, http.StatusNotFound},
+		{"DELETE", "/api/v1/admin/users/ghost", ``, http.StatusNotFound},
+		{"DELETE", "/api/v1/admin/users/worker", ``, http.StatusNoContent},
+	} {
+		if w := doRequest(app, tc.method, tc.path, admin, tc.body); w.Code != tc.code {
+			t.Errorf("%s %s %s: expected %d, got %d", tc.method, tc.path, tc.body, tc.code, w.Code)
+		}
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", userTokens["token"], ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for deleted user, got %d", w.Code)
+	}
+}
+
+func TestAdmin_DeleteUserDropsQueuedNodes(t *testing.T) {
+	app := newTestApp(t)
+	admin := loginAdmin(t, app, "boss")
+	owner := loginTestUser(t, app, "owner", "pass")["token"]
+	other := loginTestUser(t, app, "other", "pass")["token"]
+	app.mu.Lock()
+	app.loadDeps()
+	app.mu.Unlock()
+	doRequest(app, "POST", "/api/v1/calculate", owner, `{"expression": "(1+2)*(3+4)"}`)
+	doRequest(app, "POST", "/api/v1/calculate", other, `{"expression": "5+6"}`)
+
+	if w := doRequest(app, "DELETE", "/api/v1/admin/users/owner", admin, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	// В очереди остаётся только узел другого пользователя.
+	items := app.sched.Snapshot()
+	if len(items) != 1 || items[0].User != "other" || len(app.deps.nodes) != 1 {
+		t.Errorf("Expected only the other user's node to stay queued, got %+v, %d in graph", items, len(app.deps.nodes))
+	}
+}
+
+func TestAdmin_Expressions(t *testing.T) {
+	app := newTestApp(t)
+	admin := loginAdmin(t, app, "boss")
+	owner := loginTestUser(t, app, "owner", "pass")["token"]
+
+	w := doRequest(app, "POST", "/api/v1/calculate", owner, `{"expression": "2*3"}`)
+	var created map[string]string
+	json.NewDecoder(w.Body).Decode(&created)
+
+	// Чужое выражение недоступно через пользовательский API, но доступно администратору.
+	if w := doRequest(app, "GET", "/api/v1/expressions/"+created["id"], admin, ""); w.Code == http.StatusOK {
+		t.Error("Expected user endpoint to keep the ownership check")
+	}
+	w = doRequest(app, "GET", "/api/v1/admin/expressions/"+created["id"], admin, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"username":"owner"`) {
+		t.Errorf("Expected admin to see the expression, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Выражения в обработке не удаляются.
+	w = doRequest(app, "POST", "/api/v1/admin/purge", admin, "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"deleted":0}` {
+		t.Errorf("Expected nothing to be purged, got %d %s", w.Code, w.Body.String())
+	}
+	app.store.SetExpressionResult(created["id"], 6)
+	w = doRequest(app, "POST", "/api/v1/admin/purge", admin, `{"user": "owner"}`)
+	if strings.TrimSpace(w.Body.String()) != `{"deleted":1}` {
+		t.Errorf("Expected one expression to be purged, got %s", w.Body.String())
+	}
+	if w := doRequest(app, "GET", "/api/v1/admin/expressions/"+created["id"], admin, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected purged expression to be gone, got %d", w.Code)
+	}
+}
+
+func TestEnsureAdmin(t *testing.T) {
+	store := db.NewMemoryStore()
+	store.InsertUser(&db.User{Name: "existing", Password: "hash"})
+	store.SetUserDisabled("existing", true)
+
+	created, err := EnsureAdmin(store, nil, "existing", "")
+	if err != nil || created {
+		t.Fatalf("EnsureAdmin(existing): %v %v", created, err)
+	}
+	if user, _ := store.SelectUser("existing"); user.Role != db.RoleAdmin || user.Disabled || user.Password != "hash" {
+		t.Errorf("Expected existing user to become an enabled admin with the same password, got %+v", user)
+	}
+
+	if _, err := EnsureAdmin(store, nil, "fresh", ""); err == nil {
+		t.Error("Expected error for new admin without password")
+	}
+	// Пароль администратора проверяется теми же правилами, что и при регистрации.
+	for _, weak := range []string{"secret", "password1", "Fresh-fresh-1"} {
+		if _, err := EnsureAdmin(store, nil, "fresh", weak); err == nil {
+			t.Errorf("Expected weak password %q to be rejected", weak)
+		}
+	}
+	if _, err := EnsureAdmin(store, &Config{PasswordMinLength: 20}, "fresh", "Str0ng-secret"); err == nil {
+		t.Error("Expected the configured password policy to apply")
+	}
+	if _, err := EnsureAdmin(store, nil, " bad", "Str0ng-secret"); err == nil {
+		t.Error("Expected invalid login to be rejected")
+	}
+	created, err = EnsureAdmin(store, nil, "fresh", "Str0ng-secret")
+	if err != nil || !created {
+		t.Fatalf("EnsureAdmin(fresh): %v %v", created, err)
+	}
+	user, _ := store.SelectUser("fresh")
+	if user.Role != db.RoleAdmin || (&db.User{OriginPassword: "Str0ng-secret"}).ComparePassword(user) != nil {
+		t.Errorf("Unexpected new admin: %+v", user)
+	}
+}
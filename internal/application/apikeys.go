@@ -0,0 +1,242 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+// API ключ имеет вид calc_<prefix>_<secret>. По prefix ключ ищется в
+// хранилище, сам ключ хранится только в виде SHA-256.
+const apiKeyPrefix = "calc_"
+
+// apiKeyPattern находит API ключи, чтобы вырезать их из логов.
+var apiKeyPattern = regexp.MustCompile(apiKeyPrefix + `[0-9a-f]+_[A-Za-z0-9_-]+`)
+
+const (
+	scopeCalculate = "calculate"
+	scopeRead      = "read"
+)
+
+var apiKeyScopes = []string{scopeCalculate, scopeRead}
+
+// last_used_at обновляется не чаще раза в apiKeyTouchInterval,
+// чтобы не писать в базу на каждый запрос.
+const apiKeyTouchInterval = time.Minute
+
+// maxAPIKeyLifetime ограничивает expires_in: слишком большие значения
+// переполняют time.Duration.
+const maxAPIKeyLifetime = 10 * 365 * 24 * time.Hour
+
+// apiKeyKey - ключ контекста запроса для API ключа, которым он авторизован.
+type apiKeyKey struct{}
+
+func requestAPIKey(r *http.Request) *db.APIKey {
+	key, _ := r.Context().Value(apiKeyKey{}).(*db.APIKey)
+	return key
+}
+
+// newAPIKey возвращает новый ключ и его префикс. Префикс - hex, так что
+// не содержит "_" и однозначно отделяется от секрета.
+func newAPIKey() (raw, prefix string, err error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(b)
+	return apiKeyPrefix + prefix + "_" + secret, prefix, nil
+}
+
+func isAPIKey(bearer string) bool {
+	return strings.HasPrefix(bearer, apiKeyPrefix)
+}
+
+// authenticateAPIKey проверяет ключ и отмечает время его использования.
+func (a *Application) authenticateAPIKey(raw string) (*db.APIKey, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(raw, apiKeyPrefix), "_")
+	if !ok || prefix == "" {
+		return nil, errors.New("malformed api key")
+	}
+	key, err := a.store.SelectAPIKey(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("api key %s: %w", prefix, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashToken(raw))) != 1 {
+		return nil, fmt.Errorf("api key %s: hash mismatch", prefix)
+	}
+	now := a.now()
+	if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+		return nil, fmt.Errorf("api key %s expired", prefix)
+	}
+	if now.Sub(key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := a.store.TouchAPIKey(key.ID, now); err != nil {
+			a.logger.Error("Error while updating api key", "prefix", prefix, "error", err)
+		}
+		key.LastUsedAt = now
+	}
+	return &key, nil
+}
+
+// requireScope пропускает запросы по JWT и запросы по API ключам с нужной областью.
+func (a *Application) requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := requestAPIKey(r); key != nil && !slices.Contains(key.Scopes, scope) {
+			a.requestLogger(r).Info("API key has no scope", "prefix", key.Prefix, "scope", scope)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionOnly запрещает доступ по API ключам: ими нельзя, например,
+// выпустить новый ключ.
+func (a *Application) sessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := requestAPIKey(r); key != nil {
+			a.requestLogger(r).Info("API key used for session-only endpoint", "prefix", key.Prefix)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withAPIKey(ctx context.Context, key *db.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, key)
+}
+
+type APIKeyInfo struct {
+	ID         int64      `json:"id"`
+	Prefix     string     `json:"prefix"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func apiKeyInfo(key db.APIKey) APIKeyInfo {
+	info := APIKeyInfo{ID: key.ID, Prefix: key.Prefix, Name: key.Name, Scopes: key.Scopes, CreatedAt: key.CreatedAt.UTC()}
+	if !key.ExpiresAt.IsZero() {
+		t := key.ExpiresAt.UTC()
+		info.ExpiresAt = &t
+	}
+	if !key.LastUsedAt.IsZero() {
+		t := key.LastUsedAt.UTC()
+		info.LastUsedAt = &t
+	}
+	return info
+}
+
+// CreateAPIKeyHandler выпускает новый ключ. Сам ключ возвращается
+// только в этом ответе.
+func (a *Application) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int64    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpiresIn < 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresIn > int64(maxAPIKeyLifetime/time.Second) {
+		http.Error(w, fmt.Sprintf("expires_in must not exceed %d seconds", int64(maxAPIKeyLifetime/time.Second)), http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = apiKeyScopes
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			http.Error(w, "unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	raw, prefix, err := newAPIKey()
+	if err != nil {
+		a.requestLogger(r).Error("Error while generating api key", "error", err)
+		http.Error(w, "Error while generating api key", http.StatusInternalServerError)
+		return
+	}
+
+	now := a.now()
+	key := db.APIKey{
+		Prefix:    prefix,
+		Hash:      hashToken(raw),
+		Username:  r.Header.Get("username"),
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+	}
+	if req.ExpiresIn > 0 {
+		key.ExpiresAt = now.Add(time.Duration(req.ExpiresIn) * time.Second)
+	}
+	if key.ID, err = a.store.InsertAPIKey(key); err != nil {
+		a.requestLogger(r).Error("Error while saving api key", "error", err)
+		http.Error(w, "Error while generating api key", http.StatusInternalServerError)
+		return
+	}
+	a.requestLogger(r).Info("API key created", "prefix", prefix)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Key string `json:"key"`
+		APIKeyInfo
+	}{raw, apiKeyInfo(key)})
+}
+
+func (a *Application) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.store.SelectAPIKeysByUser(r.Header.Get("username"))
+	if err != nil {
+		a.requestLogger(r).Error("Error while getting api keys", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	infos := make([]APIKeyInfo, 0, len(keys))
+	for _, key := range keys {
+		infos = append(infos, apiKeyInfo(key))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]APIKeyInfo{"keys": infos})
+}
+
+func (a *Application) DeleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	username := r.Header.Get("username")
+	switch err := a.store.DeleteAPIKey(username, id); {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "API key not found", http.StatusNotFound)
+	case err != nil:
+		a.requestLogger(r).Error("Error while deleting api key", "id", id, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		a.requestLogger(r).Info("API key deleted", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
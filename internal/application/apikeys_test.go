@@ -0,0 +1,120 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type createdAPIKey struct {
+	Key string `json:"key"`
+	APIKeyInfo
+}
+
+func createAPIKey(t *testing.T, app *Application, token, body string) createdAPIKey {
+	t.Helper()
+	w := doRequest(app, "POST", "/api/v1/keys", token, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create key: expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	var key createdAPIKey
+	json.NewDecoder(w.Body).Decode(&key)
+	return key
+}
+
+func TestAPIKeys_Lifecycle(t *testing.T) {
+	app := newTestApp(t)
+	token := loginTestUser(t, app, "robot", "pass")["token"]
+
+	key := createAPIKey(t, app, token, `{"name": "ci"}`)
+	if !strings.HasPrefix(key.Key, "calc_"+key.Prefix+"_") || key.Name != "ci" {
+		t.Fatalf("Unexpected key: %+v", key)
+	}
+	if strings.Join(key.Scopes, ",") != "calculate,read" {
+		t.Errorf("Expected all scopes by default, got %v", key.Scopes)
+	}
+
+	w := doRequest(app, "POST", "/api/v1/calculate", key.Key, `{"expression": "2+2"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("calculate with api key: expected 201, got %d", w.Code)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	defer clearState(app, created.ID)
+	if w := doRequest(app, "GET", "/api/v1/expressions/"+created.ID, key.Key, ""); w.Code != http.StatusOK {
+		t.Errorf("read with api key: expected 200, got %d", w.Code)
+	}
+
+	// Список не раскрывает ключ, но показывает время использования.
+	w = doRequest(app, "GET", "/api/v1/keys", token, "")
+	if strings.Contains(w.Body.String(), key.Key) {
+		t.Error("Key list must not contain the secret")
+	}
+	var list struct {
+		Keys []APIKeyInfo `json:"keys"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Keys) != 1 || list.Keys[0].ID != key.ID || list.Keys[0].LastUsedAt == nil {
+		t.Errorf("Unexpected key list: %+v", list.Keys)
+	}
+
+	if w := doRequest(app, "DELETE", "/api/v1/keys/"+strconv.FormatInt(key.ID, 10), token, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete key: expected 204, got %d", w.Code)
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", key.Key, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected deleted key to be rejected, got %d", w.Code)
+	}
+	if w := doRequest(app, "DELETE", "/api/v1/keys/"+strconv.FormatInt(key.ID, 10), token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for already deleted key, got %d", w.Code)
+	}
+}
+
+func TestAPIKeys_Restrictions(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Now()
+	app.now = func() time.Time { return now }
+	token := loginTestUser(t, app, "limited", "pass")["token"]
+
+	readOnly := createAPIKey(t, app, token, `{"scopes": ["read"], "expires_in": 60}`)
+	if w := doRequest(app, "GET", "/api/v1/expressions", readOnly.Key, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected read scope to allow listing, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/calculate", readOnly.Key, `{"expression": "2+2"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without calculate scope, got %d", w.Code)
+	}
+	// Ключом нельзя управлять ключами.
+	if w := doRequest(app, "POST", "/api/v1/keys", readOnly.Key, `{}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 when creating a key with a key, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/keys", token, `{"scopes": ["admin"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown scope, got %d", w.Code)
+	}
+	// Срок больше 10 лет переполнил бы time.Duration.
+	if w := doRequest(app, "POST", "/api/v1/keys", token, `{"expires_in": 9223372036854775807}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for too long expires_in, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/keys", token, `{"expires_in": 315360000}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected a 10 year key to be accepted, got %d", w.Code)
+	}
+
+	// Чужой ключ не удаляется.
+	other := loginTestUser(t, app, "other", "pass")["token"]
+	if w := doRequest(app, "DELETE", "/api/v1/keys/"+strconv.FormatInt(readOnly.ID, 10), other, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when deleting another user's key, got %d", w.Code)
+	}
+
+	forged := readOnly.Key[:len(readOnly.Key)-2] + "xx"
+	if w := doRequest(app, "GET", "/api/v1/expressions", forged, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for wrong secret, got %d", w.Code)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if w := doRequest(app, "GET", "/api/v1/expressions", readOnly.Key, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for expired key, got %d", w.Code)
+	}
+}
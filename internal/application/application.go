@@ -3,33 +3,60 @@ package application
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/saykoooo/calc_go/internal/calc"
 	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/internal/scheduler"
+	"github.com/saykoooo/calc_go/internal/tracing"
 	"github.com/saykoooo/calc_go/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Config struct {
-	Addr               string
-	GRPC               string
+	Addr string
+	GRPC string
+	// DebugAddr - адрес диагностического HTTP сервера с pprof; пустой - выключен.
+	DebugAddr          string
 	JwtSecret          string
+	JwtKeysDir         string
+	JwtActiveKey       string
 	JwtExpiration      time.Duration
+	RefreshExpiration  time.Duration
+	LoginMinLength     int
+	LoginMaxLength     int
+	PasswordMinLength  int
+	PasswordMinClasses int
+	// Лимиты на пользователя; 0 - значение по-умолчанию, меньше 0 - без ограничения.
+	QuotaRequestsPerMinute     int
+	QuotaConcurrentExpressions int
+	QuotaMaxNodes              int
+	QuotaMaxExpressionLength   int
+	// Веса пользователей в очереди задач; по-умолчанию 1.
+	SchedulerWeights   map[string]int
 	TimeAddition       time.Duration
 	TimeSubtraction    time.Duration
 	TimeMultiplication time.Duration
 	TimeDivision       time.Duration
+	// LeaseTimeout - сколько сверх времени операции ждать результат агента,
+	// прежде чем выдать узел снова.
+	LeaseTimeout time.Duration
 }
 
 type Expression struct {
@@ -40,10 +67,27 @@ type Expression struct {
 }
 
 type ExpressionStatus struct {
-	ID         string  `json:"id"`
-	Status     string  `json:"status"`
-	Result     float64 `json:"result"`
-	Expression string  `json:"expression"`
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Result     float64    `json:"result"`
+	Expression string     `json:"expression"`
+	Priority   string     `json:"priority,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+}
+
+func newExpressionStatus(expr db.Expression) ExpressionStatus {
+	status := ExpressionStatus{
+		ID:         expr.ExprID,
+		Status:     expr.Status,
+		Result:     expr.Result,
+		Expression: expr.Expr,
+		Priority:   expr.Priority,
+	}
+	if !expr.Deadline.IsZero() {
+		deadline := expr.Deadline.UTC()
+		status.Deadline = &deadline
+	}
+	return status
 }
 
 type grpcServer struct {
@@ -51,31 +95,38 @@ type grpcServer struct {
 	proto.UnimplementedOrchestratorServer
 }
 
-var (
-	mu sync.Mutex
-)
-
 func ConfigFromEnv() *Config {
 	config := new(Config)
 	config.Addr = os.Getenv("PORT")
 	if config.Addr == "" {
-		log.Println("Missing PORT environment variable. Using default value: 8080")
+		slog.Info("Missing PORT environment variable. Using default value", "port", 8080)
 		config.Addr = "8080"
 	}
 	config.GRPC = os.Getenv("GRPC_PORT")
 	if config.GRPC == "" {
-		log.Println("Missing GRPC_PORT environment variable. Using default value: 5000")
+		slog.Info("Missing GRPC_PORT environment variable. Using default value", "port", 5000)
 		config.GRPC = "5000"
 	}
-	config.JwtSecret = os.Getenv("JWT_SECRET")
-	if config.JwtSecret == "" {
-		config.JwtSecret = "dumbSecretForLaziest"
+	if port := os.Getenv("DEBUG_PORT"); port != "" {
+		// Только localhost: pprof и состояние очереди не защищены авторизацией.
+		config.DebugAddr = "127.0.0.1:" + port
 	}
+	config.JwtSecret = os.Getenv("JWT_SECRET")
+	config.JwtKeysDir = os.Getenv("JWT_KEYS_DIR")
+	config.JwtActiveKey = os.Getenv("JWT_ACTIVE_KID")
 	config.JwtExpiration = 5 * time.Minute
+	config.RefreshExpiration = defaultRefreshExpiration
+	config.policyFromEnv()
+	config.QuotaRequestsPerMinute = getEnvInt("QUOTA_REQUESTS_PER_MINUTE", defaultRequestsPerMinute)
+	config.QuotaConcurrentExpressions = getEnvInt("QUOTA_CONCURRENT_EXPRESSIONS", defaultConcurrentExpressions)
+	config.QuotaMaxNodes = getEnvInt("QUOTA_MAX_NODES", defaultMaxNodes)
+	config.QuotaMaxExpressionLength = getEnvInt("QUOTA_MAX_EXPRESSION_LENGTH", defaultMaxExpressionLength)
+	config.SchedulerWeights = getEnvWeights("SCHEDULER_WEIGHTS")
 	config.TimeAddition = getEnvDuration("TIME_ADDITION_MS", 1000)
 	config.TimeSubtraction = getEnvDuration("TIME_SUBTRACTION_MS", 1000)
 	config.TimeMultiplication = getEnvDuration("TIME_MULTIPLICATION_MS", 1000)
 	config.TimeDivision = getEnvDuration("TIME_DIVISION_MS", 1000)
+	config.LeaseTimeout = getEnvDuration("TASK_LEASE_TIMEOUT_MS", int(defaultLeaseTimeout.Milliseconds()))
 	return config
 }
 
@@ -86,44 +137,202 @@ func getEnvDuration(name string, defVal int) time.Duration {
 	}
 	num, err := strconv.Atoi(val)
 	if err != nil {
-		log.Printf("Invalid value for %s: %s. Using default value %d ms", name, val, defVal)
+		slog.Warn("Invalid value. Using default value in ms", "name", name, "value", val, "default", defVal)
 		return time.Duration(defVal) * time.Millisecond
 	}
 	return time.Duration(num) * time.Millisecond
 }
 
 type Application struct {
-	config *Config
+	config    *Config
+	store     db.Store
+	storeOnce sync.Once
+	storeErr  error
+	ownStore  bool
+	now       func() time.Time
+	newID     func() string
+	logger    *slog.Logger
+	keys      *KeySet
+	traces    *tracing.Exporter
+	guard     *loginGuard
+	limiter   *rateLimiter
+	sched     *scheduler.Scheduler
+	// deps строится из хранилища при первом обращении, см. loadDeps.
+	deps       *depGraph
+	depsLoaded bool
+	// nextDeadline - ближайший срок выражения в обработке, нулевой, если сроков нет.
+	nextDeadline time.Time
+	// nextLease - ближайший конец аренды выданного узла, нулевой, если выданных нет.
+	nextLease time.Time
+	metrics   *appMetrics
+	// grpcServing - gRPC сервис принимает вызовы: ServeGRPC слушает порт
+	// или, для чужого сервера, так сообщил SetGRPCServing.
+	grpcServing atomic.Bool
+	// grpcExternal - сервис зарегистрирован через RegisterGRPC на сервере
+	// встраивающего приложения.
+	grpcExternal atomic.Bool
+	started      time.Time
+	mu           timedMutex
+}
+
+type Option func(*Application)
+
+func WithConfig(config *Config) Option {
+	return func(a *Application) { a.config = config }
+}
+
+// WithStore задаёт хранилище. Без него при запуске открывается data/store.db.
+func WithStore(store db.Store) Option {
+	return func(a *Application) { a.store = store }
+}
+
+func WithClock(now func() time.Time) Option {
+	return func(a *Application) { a.now = now }
+}
+
+func WithIDGenerator(newID func() string) Option {
+	return func(a *Application) { a.newID = newID }
+}
+
+// WithLogger задаёт логгер оркестратора; он же передаётся открываемому
+// им хранилищу. По-умолчанию - slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *Application) { a.logger = logger }
 }
 
-func New() *Application {
-	return &Application{
-		config: ConfigFromEnv(),
+// WithKeySet задаёт ключи подписи JWT вместо загружаемых по Config.
+func WithKeySet(keys *KeySet) Option {
+	return func(a *Application) { a.keys = keys }
+}
+
+// WithTraceExporter включает запись спанов запросов и задач.
+func WithTraceExporter(traces *tracing.Exporter) Option {
+	return func(a *Application) { a.traces = traces }
+}
+
+func New(opts ...Option) *Application {
+	a := &Application{
+		now:     time.Now,
+		started: time.Now(),
+		newID:   calc.GenerateID,
+		logger:  slog.Default(),
+		guard:   newLoginGuard(),
+		limiter: newRateLimiter(),
+		metrics: newAppMetrics(),
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	a.logger = slog.New(logging.Redact(a.logger.Handler(), apiKeyPattern))
+	if a.config == nil {
+		a.config = ConfigFromEnv()
+	}
+	if a.config.RefreshExpiration <= 0 {
+		a.config.RefreshExpiration = defaultRefreshExpiration
+	}
+	if a.config.LeaseTimeout <= 0 {
+		a.config.LeaseTimeout = defaultLeaseTimeout
+	}
+	a.config.applyPolicyDefaults()
+	a.config.applyQuotaDefaults()
+	if a.keys == nil {
+		a.keys = a.loadKeys()
+	}
+	a.resetQueue()
+	return a
+}
+
+// loadKeys выбирает ключи подписи: каталог JWT_KEYS_DIR, затем общий
+// секрет JWT_SECRET, иначе временный ключ на время работы процесса.
+func (a *Application) loadKeys() *KeySet {
+	switch {
+	case a.config.JwtKeysDir != "":
+		keys, err := LoadKeySet(a.config.JwtKeysDir, a.config.JwtActiveKey)
+		if err != nil {
+			a.logger.Error("Failed to load JWT keys", "error", err)
+			panic(err)
+		}
+		return keys
+	case a.config.JwtSecret != "":
+		return HMACKeySet(a.config.JwtSecret)
+	default:
+		keys, err := GenerateKeySet()
+		if err != nil {
+			a.logger.Error("Failed to generate JWT key", "error", err)
+			panic(err)
+		}
+		a.logger.Warn("No JWT_KEYS_DIR or JWT_SECRET set. Using an ephemeral signing key: tokens will not survive a restart")
+		return keys
+	}
+}
+
+// openStore открывает хранилище при первом обращении и выполняет
+// восстановление после возможной аварийной остановки.
+func (a *Application) openStore() error {
+	a.storeOnce.Do(func() {
+		if a.store == nil {
+			store, err := db.OpenSQLite(db.DefaultFile, db.WithLogger(a.logger))
+			if err != nil {
+				a.storeErr = err
+				return
+			}
+			a.store, a.ownStore = store, true
+		}
+		_, a.storeErr = a.Recover()
+	})
+	return a.storeErr
+}
+
+// Recover возвращает зависшие узлы в очередь и завершает выражения,
+// состояние которых не было записано до остановки оркестратора.
+func (a *Application) Recover() (db.RecoveryReport, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	report, err := a.store.Recover()
+	if err != nil {
+		a.logger.Error("Recovery failed", "error", err)
+		return report, err
+	}
+	a.resetQueue()
+	a.sweepDeadlines()
+	a.metrics.finished.Add(float64(len(report.Finished)), "done")
+	a.metrics.finished.Add(float64(len(report.Failed)), "failed")
+	a.logger.Info("Recovery completed", "nodes_reset", report.NodesReset,
+		"finished", report.Finished, "failed", report.Failed)
+	return report, nil
 }
 
 type Request struct {
 	Expression string `json:"expression"`
+	// Priority - "low", "normal" (по-умолчанию) или "high".
+	Priority string `json:"priority"`
+	// Deadline - срок в формате RFC 3339, после которого выражение
+	// помечается "expired".
+	Deadline string `json:"deadline"`
 }
 
 func (s *grpcServer) GetTask(ctx context.Context, req *proto.GetTaskRequest) (*proto.TaskResponse, error) {
-	task, err := db.SelectNodeAsTask()
-	if task.ID == "" || err != nil {
-		return nil, fmt.Errorf("no task available")
-	}
-	var opTime time.Duration
-	switch task.Oper {
-	case "+":
-		opTime = s.app.config.TimeAddition
-	case "-":
-		opTime = s.app.config.TimeSubtraction
-	case "*":
-		opTime = s.app.config.TimeMultiplication
-	case "/":
-		opTime = s.app.config.TimeDivision
-	default:
-		return nil, fmt.Errorf("invalid operation")
+	s.app.seeAgent(ctx)
+	task, span, err := s.app.nextTask()
+	if err != nil {
+		s.app.agentLogger(ctx).Error("Failed to get task", "error", err)
 	}
+	if task.ID == "" || err != nil {
+		s.app.metrics.getTask.Inc("empty")
+		return nil, status.Error(codes.NotFound, "no task available")
+	}
+	s.app.metrics.getTask.Inc("task")
+	logger := s.app.agentLogger(ctx).With(logging.NodeID, task.ID, logging.ExprID, task.ExprID)
+	if span.IsValid() {
+		// Контекст уходит агенту в заголовке ответа; вне gRPC потока,
+		// например в тестах, заголовок просто не отправляется.
+		grpc.SetHeader(ctx, metadata.Pairs(tracing.TraceparentKey, span.Traceparent()))
+		logger = logger.With(logging.TraceID, span.TraceID)
+	}
+	logger.Debug("Task handed out")
+	// nextTask не выдаёт операции без времени.
+	opTime, _ := s.app.operationTime(task.Oper)
 	return &proto.TaskResponse{
 		Id:            task.ID,
 		Arg1:          task.Arg1,
@@ -134,89 +343,186 @@ func (s *grpcServer) GetTask(ctx context.Context, req *proto.GetTaskRequest) (*p
 }
 
 func (s *grpcServer) SubmitResult(ctx context.Context, req *proto.ResultRequest) (*proto.SubmitResultResponse, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	err := db.SetNodeResult(req.Id, req.Result)
-	if err != nil {
+	s.app.seeAgent(ctx)
+	logger := s.app.agentLogger(ctx).With(logging.NodeID, req.Id)
+	s.app.mu.Lock()
+	defer s.app.mu.Unlock()
+	if err := s.app.loadDeps(); err != nil {
+		logger.Error("Failed to load pending nodes", "error", err)
+		s.app.metrics.submitErrors.Inc("store")
 		return nil, fmt.Errorf("failed to set node result")
 	}
 
-	node, err := db.SelectNode(req.Id)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get node")
+	exprID, _ := s.app.deps.exprOf(req.Id)
+	if exprID != "" {
+		logger = logger.With(logging.ExprID, exprID)
+	}
+	if node, ok := s.app.deps.nodes[req.Id]; ok && node.trace.TraceID != "" {
+		logger = logger.With(logging.TraceID, node.trace.TraceID)
+	}
+	var (
+		finished bool
+		err      error
+	)
+	if req.Error != "" {
+		// Агент не смог вычислить узел, например из-за деления на ноль.
+		exprID, err = s.app.store.FailNode(req.Id)
+	} else {
+		finished, err = s.app.store.CompleteNode(req.Id, req.Result)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		// Выражение удалено или просрочено, пока узел вычислялся.
+		if exprID != "" {
+			s.app.deps.remove(exprID)
+		}
+		s.app.metrics.submitErrors.Inc("unknown_node")
+	} else if err != nil {
+		s.app.metrics.submitErrors.Inc("store")
 	}
-
-	expr, err := db.SelectExpression(node.ExprID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get expression")
+		logger.Warn("Failed to complete node", "error", err)
+		return nil, fmt.Errorf("failed to set node result")
 	}
-
-	if node.ID == expr.RootNodeID {
-		db.SetExpressionResult(expr.ExprID, req.Result)
-		db.DeleteNodes(expr.ExprID)
+	s.app.observeTask(req.Id)
+	s.app.exportTaskSpan(ctx, req.Id, req.Error)
+	if req.Error != "" {
+		s.app.deps.remove(exprID)
+		s.app.metrics.finished.Inc("failed")
+		logger.Info("Expression failed", "error", req.Error)
+		return &proto.SubmitResultResponse{}, nil
+	}
+	s.app.deps.complete(req.Id)
+	logger.Debug("Task result submitted", "result", req.Result)
+	if finished {
+		s.app.metrics.finished.Inc("done")
+		logger.Info("Expression finished")
 	}
 
 	return &proto.SubmitResultResponse{}, nil
 }
 
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-	})
-}
-
+// AuthMiddleware принимает JWT токены и API ключи. Роль и блокировка
+// пользователя каждый раз берутся из хранилища, поэтому понижение или
+// блокировка действуют сразу, не дожидаясь истечения токена.
 func (a *Application) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bearer, err := ExtractToken(r)
 		if err != nil {
-			log.Println(err.Error())
+			a.requestLogger(r).Info("Rejected request", "error", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		log.Printf("Got bearer: %s", bearer)
 
-		tokenFromString, err := jwt.Parse(bearer, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		var (
+			name   string
+			ctx    context.Context
+			claims jwt.MapClaims
+		)
+		if isAPIKey(bearer) {
+			key, err := a.authenticateAPIKey(bearer)
+			if err != nil {
+				a.requestLogger(r).Info("Rejected API key", "error", err)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			name, ctx = key.Username, withAPIKey(r.Context(), key)
+		} else {
+			claims, err = a.parseAccessToken(bearer)
+			if err != nil {
+				a.requestLogger(r).Info("Rejected token", "error", err)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
 			}
-			return []byte(a.config.JwtSecret), nil
-		})
+			name, _ = claims["name"].(string)
+			ctx = context.WithValue(r.Context(), claimsKey{}, claims)
+		}
+		ctx = a.withUser(ctx, name)
+		r = r.WithContext(ctx)
+
+		user, err := a.store.SelectUser(name)
 		if err != nil {
-			log.Println(err)
+			a.requestLogger(r).Info("Rejected request of unknown user", "error", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		claims, ok := tokenFromString.Claims.(jwt.MapClaims)
-		if ok {
-			log.Println("Request from user: ", claims["name"])
-		} else {
-			log.Println("invalid jwt token")
+		if user.Disabled {
+			a.requestLogger(r).Info("Rejected request of disabled user")
+			http.Error(w, "Account disabled", http.StatusForbidden)
+			return
+		}
+		// Имя из токена могло перейти к другой учётной записи после
+		// удаления или переименования прежней.
+		if sub, _ := claims["sub"].(string); claims != nil && sub != strconv.FormatInt(user.ID, 10) {
+			a.requestLogger(r).Info("Rejected token of another account")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if claims != nil && !user.TokensNotBefore.IsZero() {
+			if iat, _ := claims.GetIssuedAt(); iat == nil || !iat.After(user.TokensNotBefore) {
+				a.requestLogger(r).Info("Rejected token issued before password change")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
 
-		r.Header.Set("username", claims["name"].(string))
-		next.ServeHTTP(w, r)
+		r.Header.Set("username", name)
+		r.Header.Set("role", user.Role)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+// parseAccessToken проверяет подпись, срок действия и отзыв JWT токена.
+func (a *Application) parseAccessToken(bearer string) (jwt.MapClaims, error) {
+	tokenFromString, err := jwt.Parse(bearer, a.keys.Keyfunc,
+		jwt.WithValidMethods(a.keys.Methods()), jwt.WithTimeFunc(a.now))
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := tokenFromString.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid jwt token")
+	}
+	if name, _ := claims["name"].(string); name == "" {
+		return nil, errors.New("jwt token without name")
+	}
+	if jti, _ := claims["jti"].(string); jti != "" {
+		revoked, err := a.store.IsTokenRevoked(jti)
+		if err != nil || revoked {
+			return nil, fmt.Errorf("rejected revoked token of user %s", claims["name"])
+		}
+	}
+	return claims, nil
+}
+
+func (a *Application) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Login    string `json:"login"`
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Invalid request body: %v", err)
-		http.Error(w, "invalid request", http.StatusBadRequest)
+		a.requestLogger(r).Info("Invalid request body", "error", err)
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid request body")
 		return
 	}
-	log.Printf("Got registration request from: %s", req.Login)
+
+	// Логин пишется в лог только после проверки: в поле логина по ошибке
+	// может оказаться пароль.
+	err := a.config.validateLogin(req.Login)
+	if err == nil {
+		err = a.config.validatePassword(req.Login, req.Password)
+	}
+	var perr *policyError
+	if errors.As(err, &perr) {
+		a.requestLogger(r).Info("Registration rejected", "code", perr.Code)
+		writeError(w, http.StatusBadRequest, perr.Code, perr.Message)
+		return
+	}
+	logger := a.requestLogger(r).With(logging.User, req.Login)
+
 	password, err := db.GenerateHash(req.Password)
 	if err != nil {
-		log.Printf("Error while generating hash: %v", err)
-		http.Error(w, "Error while generating hash", http.StatusInternalServerError)
+		logger.Error("Error while generating hash", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while registering user")
 		return
 	}
 	user := &db.User{
@@ -224,19 +530,19 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		Password:       password,
 		OriginPassword: req.Password,
 	}
-	userID, err := db.InsertUser(user)
-	if err != nil {
-		log.Printf("Error while registering user: %s", req.Login)
-		http.Error(w, "Error while registering user", http.StatusInternalServerError)
+	userID, err := a.store.InsertUser(user)
+	switch {
+	case errors.Is(err, db.ErrUserExists):
+		logger.Info("User already exists")
+		writeError(w, http.StatusConflict, "login_taken", "user already exists")
 		return
-	} else if userID == 0 {
-		log.Printf("User already exists: %s", req.Login)
-		http.Error(w, "User already exists", http.StatusBadRequest)
+	case err != nil:
+		logger.Error("Error while registering user", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while registering user")
 		return
-	} else {
-		user.ID = userID
-		log.Printf("User registered: %s (id:%v)", req.Login, user.ID)
 	}
+	user.ID = userID
+	logger.Info("User registered", "id", user.ID)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -254,63 +560,54 @@ func (a *Application) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Invalid request body: %v", err)
+		a.requestLogger(r).Info("Invalid request body", "error", err)
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
 
-	userFromDB, err := db.SelectUser(req.Login)
-	if err != nil {
-		log.Printf("Error while getting user %s: %v", req.Login, err)
-		http.Error(w, "Auth failed", http.StatusUnauthorized)
+	// Логин попадает в лог только после проверки пароля, см. RegisterHandler.
+	ip := clientIP(r)
+	logger := a.requestLogger(r).With("ip", ip)
+	if wait := a.guard.begin(req.Login, ip, a.now()); wait > 0 {
+		logger.Warn("Login locked", "retry_after", wait)
+		writeRetryAfter(w, wait)
 		return
 	}
-	password, err := db.GenerateHash(req.Password)
+
+	userFromDB, err := a.store.SelectUser(req.Login)
 	if err != nil {
-		log.Printf("Error while generating hash: %v", err)
-		http.Error(w, "Error while generating hash", http.StatusInternalServerError)
+		logger.Info("Auth failed", "error", err)
+		dummyCompare(req.Password)
+		a.guard.fail(req.Login, ip, a.now())
+		http.Error(w, "Auth failed", http.StatusUnauthorized)
 		return
 	}
 	user := &db.User{
 		Name:           req.Login,
-		Password:       password,
 		OriginPassword: req.Password,
 	}
 	if ok := user.ComparePassword(userFromDB); ok != nil {
-		log.Printf("Auth failed: %s", user.Name)
+		logger.Info("Auth failed", "error", "wrong password")
+		a.guard.fail(req.Login, ip, a.now())
 		http.Error(w, "Auth failed", http.StatusUnauthorized)
 		return
 	}
-	now := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"name": req.Login,
-		"nbf":  now.Unix(),
-		"exp":  now.Add(a.config.JwtExpiration).Unix(),
-		"iat":  now.Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(a.config.JwtSecret))
-	if err != nil {
-		log.Printf("Error while generating token string: %s", err)
-		http.Error(w, "Error while generating token string", http.StatusInternalServerError)
+	a.guard.succeed(req.Login, ip)
+	logger = logger.With(logging.User, userFromDB.Name)
+	if userFromDB.Disabled {
+		logger.Info("Login of disabled user")
+		http.Error(w, "Account disabled", http.StatusForbidden)
 		return
 	}
-	log.Printf("Token generated for user: %s", req.Login)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"token":      tokenString,
-		"expires_in": strconv.FormatInt(int64(a.config.JwtExpiration.Seconds()), 10),
-	})
+	logger.Info("User logged in")
+	a.issueTokens(w, r, userFromDB, "")
 }
 
-func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/api/v1/calculate" {
-		http.Error(w, "Bad URL", http.StatusNotFound)
-		return
-	}
+func (a *Application) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Bad URL", http.StatusNotFound)
 }
 
-func CalcHandler(w http.ResponseWriter, r *http.Request) {
+func (a *Application) CalcHandler(w http.ResponseWriter, r *http.Request) {
 	request := new(Request)
 	defer r.Body.Close()
 	if r.Method != "POST" {
@@ -319,25 +616,54 @@ func CalcHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user := r.Header.Get("username")
+	if !a.checkRate(w, user) {
+		a.requestLogger(r).Info("Rate limit exceeded")
+		return
+	}
 
+	if limit := a.config.QuotaMaxExpressionLength; limit >= 0 {
+		// Запас на JSON обёртку и экранирование.
+		r.Body = http.MaxBytesReader(w, r.Body, int64(2*limit+1024))
+	}
 	err := json.NewDecoder(r.Body).Decode(&request)
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		writeQuotaError(w, http.StatusRequestEntityTooLarge, "expression_too_long", a.config.QuotaMaxExpressionLength, "expression is too long")
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
+	if limit := a.config.QuotaMaxExpressionLength; limit >= 0 && len(request.Expression) > limit {
+		writeQuotaError(w, http.StatusRequestEntityTooLarge, "expression_too_long", limit, "expression is too long")
+		return
+	}
+	priority, deadline, ok := a.parseSchedule(w, request)
+	if !ok {
+		return
+	}
 
 	root, result, err := calc.ParseExpression(request.Expression)
 	if err != nil {
-		log.Printf("Error parsing expression: %v", err)
+		a.requestLogger(r).Info("Error parsing expression", "error", err)
 		http.Error(w, "invalid expression", http.StatusUnprocessableEntity)
 		return
 	}
+	if limit := a.config.QuotaMaxNodes; limit >= 0 && len(result) > limit {
+		writeQuotaError(w, http.StatusRequestEntityTooLarge, "too_many_nodes", limit, "expression has too many nodes")
+		return
+	}
 
-	log.Printf("Calculate expression for user: %s", user)
-	exprID := calc.GenerateID()
-	mu.Lock()
-	defer mu.Unlock()
+	exprID := a.newID()
+	logger := a.requestLogger(r).With(logging.ExprID, exprID)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.checkConcurrent(w, user) {
+		logger.Info("Concurrent expressions limit exceeded")
+		return
+	}
 
 	expr := db.Expression{
 		ExprID:     exprID,
@@ -345,41 +671,55 @@ func CalcHandler(w http.ResponseWriter, r *http.Request) {
 		Status:     "processing",
 		RootNodeID: root.ID,
 		Expr:       request.Expression,
+		Priority:   priority,
+		Deadline:   deadline,
 	}
-	expr_num, err := db.InsertExpression(expr)
-	if err != nil {
-		log.Printf("Error adding expression to db: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	if span := tracing.SpanFromContext(r.Context()); span.IsValid() {
+		expr.TraceID, expr.SpanID = span.TraceID, span.SpanID
 	}
-
 	for i := range result {
 		result[i].ExprID = exprID
 	}
+	// Выражение без операций, например "5", вычислено сразу: агентам
+	// выдавать нечего, и узлы не нужны.
+	trivial := root.Status == "done"
+	if trivial {
+		expr.Status, expr.Result = "done", root.Result
+		result = nil
+	}
 
-	num, err := db.InsertNodes(result)
+	err = a.store.CreateExpression(expr, result)
 	if err != nil {
-		log.Printf("Error saving nodes to db: %v", err)
+		logger.Error("Error saving expression to db", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if a.depsLoaded {
+		a.deps.add(pendingNodes(expr, result))
+	}
+	a.metrics.submitted.Inc()
+	if trivial {
+		a.metrics.finished.Inc("done")
+	} else {
+		a.watchDeadline(deadline)
+	}
 
-	log.Printf("Expression with ID %s created and processing started", exprID)
-
-	log.Printf("Nodes added: %d / Expr added: %d", num, expr_num)
+	logger.Info("Expression created", "nodes", len(result), "priority", priority)
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"id": exprID})
 }
 
-func GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	mu.Lock()
-	defer mu.Unlock()
+func (a *Application) GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expireOverdue()
 
 	user := r.Header.Get("username")
 
-	exprs, err := db.SelectExpressionsByUser(user)
+	exprs, err := a.store.SelectExpressionsByUser(user)
 	if err != nil {
-		log.Printf("Error while getting expressions: %v", err)
+		a.requestLogger(r).Error("Error while getting expressions", "error", err)
 	}
 
 	response := struct {
@@ -389,89 +729,176 @@ func GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, expr := range exprs {
-		response.Expressions = append(response.Expressions, ExpressionStatus{
-			ID:         expr.ExprID,
-			Status:     expr.Status,
-			Result:     expr.Result,
-			Expression: expr.Expr,
-		})
+		response.Expressions = append(response.Expressions, newExpressionStatus(expr))
 	}
 
-	log.Println("Returning list of expressions")
 	w.Header().Set("Content-Type", "application/json")
-	log.Println("Expressions: ", len(exprs))
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		a.requestLogger(r).Error("Error encoding response", "error", err)
 		http.Error(w, "failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
-func GetExpressionByIdHandler(w http.ResponseWriter, r *http.Request) {
+func (a *Application) GetExpressionByIdHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	mu.Lock()
-	defer mu.Unlock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expireOverdue()
 
-	expr, err := db.SelectExpression(id)
+	expr, err := a.store.SelectExpression(id)
 	if err != nil {
-		log.Printf("Error while getting expression (%s): %v", id, err)
+		a.requestLogger(r).Info("Error while getting expression", logging.ExprID, id, "error", err)
 		http.Error(w, "Expression not found", http.StatusNotFound)
 		return
 	}
 	user := r.Header.Get("username")
 	if user != expr.Username {
-		log.Printf("Invalid username: %s, expect: %s", user, expr.Username)
+		a.requestLogger(r).Warn("Expression of another user requested", logging.ExprID, id)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	log.Printf("Returning expression with ID %s", id)
 	response := struct {
 		Expression ExpressionStatus `json:"expression"`
 	}{
-		Expression: ExpressionStatus{
-			ID:         expr.ExprID,
-			Status:     expr.Status,
-			Result:     expr.Result,
-			Expression: expr.Expr,
-		},
+		Expression: newExpressionStatus(expr),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		a.requestLogger(r).Error("Error encoding response", "error", err)
 		http.Error(w, "failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (a *Application) RunGRPCServer() error {
+// RegisterGRPC регистрирует сервис оркестратора на переданном gRPC сервере.
+// Сервером управляет вызывающий, поэтому /readyz считает gRPC доступным,
+// пока тот не сообщит обратное через SetGRPCServing.
+func (a *Application) RegisterGRPC(s grpc.ServiceRegistrar) {
+	a.grpcExternal.Store(true)
+	a.grpcServing.Store(true)
+	a.registerGRPC(s)
+}
+
+// SetGRPCServing сообщает /readyz, обслуживает ли сервер, на котором
+// сервис зарегистрирован через RegisterGRPC, вызовы.
+func (a *Application) SetGRPCServing(serving bool) {
+	a.grpcServing.Store(serving)
+}
+
+func (a *Application) registerGRPC(s grpc.ServiceRegistrar) {
+	if err := a.openStore(); err != nil {
+		a.logger.Error("Failed to open store", "error", err)
+		panic(err)
+	}
+	proto.RegisterOrchestratorServer(s, &grpcServer{app: a})
+}
+
+// Handler возвращает HTTP API оркестратора без статики веб-интерфейса.
+func (a *Application) Handler() http.Handler {
+	if err := a.openStore(); err != nil {
+		a.logger.Error("Failed to open store", "error", err)
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", a.LoggingMiddleware(http.HandlerFunc(a.NotFoundHandler)))
+	mux.Handle("/api/v1/calculate", a.LoggingMiddleware(a.AuthMiddleware(a.requireScope(scopeCalculate, http.HandlerFunc(a.CalcHandler)))))
+	mux.Handle("/api/v1/expressions", a.LoggingMiddleware(a.AuthMiddleware(a.requireScope(scopeRead, http.HandlerFunc(a.GetExpressionsHandler)))))
+	mux.Handle("/api/v1/expressions/{id}", a.LoggingMiddleware(a.AuthMiddleware(a.requireScope(scopeRead, http.HandlerFunc(a.GetExpressionByIdHandler)))))
+	mux.Handle("GET /.well-known/jwks.json", a.LoggingMiddleware(http.HandlerFunc(a.JWKSHandler)))
+	mux.Handle("POST /api/v1/register", a.LoggingMiddleware(http.HandlerFunc(a.RegisterHandler)))
+	mux.Handle("POST /api/v1/login", a.LoggingMiddleware(http.HandlerFunc(a.LoginHandler)))
+	mux.Handle("POST /api/v1/refresh", a.LoggingMiddleware(http.HandlerFunc(a.RefreshHandler)))
+	mux.Handle("POST /api/v1/logout", a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(http.HandlerFunc(a.LogoutHandler)))))
+	mux.Handle("POST /api/v1/keys", a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(http.HandlerFunc(a.CreateAPIKeyHandler)))))
+	mux.Handle("GET /api/v1/keys", a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(http.HandlerFunc(a.ListAPIKeysHandler)))))
+	mux.Handle("DELETE /api/v1/keys/{id}", a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(http.HandlerFunc(a.DeleteAPIKeyHandler)))))
+	mux.Handle("GET /api/v1/me", a.LoggingMiddleware(a.AuthMiddleware(a.requireScope(scopeRead, http.HandlerFunc(a.MeHandler)))))
+	mux.Handle("PATCH /api/v1/me", a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(http.HandlerFunc(a.RenameHandler)))))
+	mux.Handle("DELETE /api/v1/me", a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(http.HandlerFunc(a.DeleteMeHandler)))))
+	mux.Handle("PUT /api/v1/me/password", a.LoggingMiddleware(a.AuthMiddleware(a.sessionOnly(http.HandlerFunc(a.ChangePasswordHandler)))))
+	mux.Handle("GET /api/v1/admin/users", a.adminOnly(a.ListUsersHandler))
+	mux.Handle("PATCH /api/v1/admin/users/{name}", a.adminOnly(a.UpdateUserHandler))
+	mux.Handle("DELETE /api/v1/admin/users/{name}", a.adminOnly(a.DeleteUserHandler))
+	mux.Handle("GET /api/v1/admin/expressions/{id}", a.adminOnly(a.GetAnyExpressionHandler))
+	mux.Handle("POST /api/v1/admin/purge", a.adminOnly(a.PurgeHandler))
+	mux.Handle("POST /api/v1/admin/unlock", a.adminOnly(a.UnlockHandler))
+	mux.Handle("GET /metrics", http.HandlerFunc(a.MetricsHandler))
+	mux.Handle("GET /healthz", http.HandlerFunc(a.HealthHandler))
+	mux.Handle("GET /readyz", http.HandlerFunc(a.ReadyHandler))
+	mux.Handle("GET /version", http.HandlerFunc(a.VersionHandler))
+	return a.RequestIDMiddleware(a.instrument(mux))
+}
+
+// shutdownTimeout - сколько HTTP сервер ждёт завершения текущих запросов
+// при остановке.
+const shutdownTimeout = 10 * time.Second
+
+// RunGRPCServer обслуживает gRPC сервис на Config.GRPC до отмены ctx.
+func (a *Application) RunGRPCServer(ctx context.Context) error {
 	lis, err := net.Listen("tcp", ":"+a.config.GRPC)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		a.logger.Error("Failed to listen", "error", err)
+		return err
 	}
 
+	a.logger.Info("Starting gRPC server", "port", a.config.GRPC)
+	return a.ServeGRPC(ctx, lis)
+}
+
+// ServeGRPC обслуживает gRPC сервис на lis; пока он работает, /readyz
+// считает gRPC доступным. После отмены ctx сервер дожидается текущих
+// вызовов и останавливается.
+func (a *Application) ServeGRPC(ctx context.Context, lis net.Listener) error {
 	server := grpc.NewServer()
-	proto.RegisterOrchestratorServer(server, &grpcServer{app: a})
-	log.Printf("Starting gRPC server on port %s", a.config.GRPC)
+	a.registerGRPC(server)
+	a.grpcServing.Store(true)
+	defer a.grpcServing.Store(false)
+	stop := context.AfterFunc(ctx, server.GracefulStop)
+	defer stop()
 	return server.Serve(lis)
 }
 
-func (a *Application) RunServer() error {
+// serveHTTP обслуживает server до отмены ctx, затем даёт текущим запросам
+// shutdownTimeout на завершение.
+func (a *Application) serveHTTP(ctx context.Context, server *http.Server) error {
+	errc := make(chan error, 1)
+	go func() { errc <- server.ListenAndServe() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// Close закрывает хранилище, если оркестратор открыл его сам. Вызывается
+// после остановки всех серверов.
+func (a *Application) Close() error {
+	if a.ownStore {
+		return a.store.Close()
+	}
+	return nil
+}
+
+// RunServer обслуживает HTTP API и веб-интерфейс на Config.Addr до отмены ctx.
+func (a *Application) RunServer(ctx context.Context) error {
+	err := a.openStore()
+	if err != nil {
+		a.logger.Error("Failed to open store", "error", err)
+		panic(err)
+	}
+
 	mux := http.NewServeMux()
 	fs := http.FileServer(http.Dir("web/"))
 	mux.Handle("/web/", http.StripPrefix("/web/", fs))
-	mux.Handle("/", LoggingMiddleware(http.HandlerFunc(NotFoundHandler)))
-	mux.Handle("/api/v1/calculate", LoggingMiddleware(a.AuthMiddleware(http.HandlerFunc(CalcHandler))))
-	mux.Handle("/api/v1/expressions", LoggingMiddleware(a.AuthMiddleware(http.HandlerFunc(GetExpressionsHandler))))
-	mux.Handle("/api/v1/expressions/{id}", LoggingMiddleware(a.AuthMiddleware(http.HandlerFunc(GetExpressionByIdHandler))))
-	mux.Handle("POST /api/v1/register", LoggingMiddleware(http.HandlerFunc(RegisterHandler)))
-	mux.Handle("POST /api/v1/login", LoggingMiddleware(http.HandlerFunc(a.LoginHandler)))
-	log.Printf("Web server run on port: %s\n", a.config.Addr)
-	err := db.Init("data/store.db")
-	if err != nil {
-		log.Panicln(err)
-	}
-	defer db.Stop()
-	return http.ListenAndServe(":"+a.config.Addr, mux)
+	mux.Handle("/", a.Handler())
+	a.logger.Info("Web server started", "port", a.config.Addr)
+	err = a.serveHTTP(ctx, &http.Server{Addr: ":" + a.config.Addr, Handler: mux})
+	a.logger.Info("Web server stopped")
+	return err
 }
@@ -0,0 +1,197 @@
+package application
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+)
+
+const defaultRefreshExpiration = 7 * 24 * time.Hour
+
+// claimsKey - ключ контекста запроса, под которым AuthMiddleware
+// сохраняет claims проверенного токена.
+type claimsKey struct{}
+
+func requestClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsKey{}).(jwt.MapClaims)
+	return claims
+}
+
+// randomToken возвращает случайную строку из n байт в base64url.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signAccessToken выпускает access токен. sub - ID пользователя: имя можно
+// сменить или занять заново после удаления. sid связывает токен с цепочкой
+// refresh токенов, чтобы при выходе отозвать всю сессию.
+func (a *Application) signAccessToken(user db.User, family string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := a.now()
+	// iat хранится с точностью до секунды, а токены, выпущенные в секунду
+	// смены пароля, недействительны: новые токены датируются следующей.
+	iat := max(now.Unix(), user.TokensNotBefore.Unix()+1)
+	return a.keys.Sign(jwt.MapClaims{
+		"sub":  strconv.FormatInt(user.ID, 10),
+		"name": user.Name,
+		"role": user.Role,
+		"nbf":  now.Unix(),
+		"exp":  now.Add(a.config.JwtExpiration).Unix(),
+		"iat":  iat,
+		"jti":  jti,
+		"sid":  family,
+	})
+}
+
+func (a *Application) newRefreshToken(username, family string) (string, db.RefreshToken, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", db.RefreshToken{}, err
+	}
+	return raw, db.RefreshToken{
+		Hash:      hashToken(raw),
+		FamilyID:  family,
+		Username:  username,
+		ExpiresAt: a.now().Add(a.config.RefreshExpiration),
+	}, nil
+}
+
+// issueTokens начинает новую сессию (family == "") и отвечает парой токенов.
+func (a *Application) issueTokens(w http.ResponseWriter, r *http.Request, user db.User, family string) {
+	if family == "" {
+		var err error
+		if family, err = randomToken(16); err != nil {
+			a.requestLogger(r).Error("Error while generating session id", "error", err)
+			http.Error(w, "Error while generating token string", http.StatusInternalServerError)
+			return
+		}
+	}
+	refresh, stored, err := a.newRefreshToken(user.Name, family)
+	if err == nil {
+		err = a.store.InsertRefreshToken(stored)
+	}
+	if err != nil {
+		a.requestLogger(r).Error("Error while saving refresh token", "error", err)
+		http.Error(w, "Error while generating token string", http.StatusInternalServerError)
+		return
+	}
+	a.writeTokens(w, r, user, family, refresh)
+}
+
+func (a *Application) writeTokens(w http.ResponseWriter, r *http.Request, user db.User, family, refresh string) {
+	tokenString, err := a.signAccessToken(user, family)
+	if err != nil {
+		a.requestLogger(r).Error("Error while generating token string", logging.User, user.Name, "error", err)
+		http.Error(w, "Error while generating token string", http.StatusInternalServerError)
+		return
+	}
+	a.requestLogger(r).Debug("Token generated", logging.User, user.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"token":              tokenString,
+		"expires_in":         strconv.FormatInt(int64(a.config.JwtExpiration.Seconds()), 10),
+		"refresh_token":      refresh,
+		"refresh_expires_in": strconv.FormatInt(int64(a.config.RefreshExpiration.Seconds()), 10),
+	})
+}
+
+// RefreshHandler обменивает refresh токен на новую пару токенов.
+// Каждый refresh токен одноразовый; повторное использование отзывает сессию.
+func (a *Application) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	refresh, next, err := a.newRefreshToken("", "")
+	if err != nil {
+		a.requestLogger(r).Error("Error while generating refresh token", "error", err)
+		http.Error(w, "Error while generating token string", http.StatusInternalServerError)
+		return
+	}
+	used, err := a.store.RotateRefreshToken(hashToken(req.RefreshToken), next, a.now())
+	switch {
+	case errors.Is(err, db.ErrTokenReused):
+		a.requestLogger(r).Warn("Refresh token reuse detected, session revoked", logging.User, used.Username)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case err != nil:
+		a.requestLogger(r).Info("Refresh failed", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := a.store.SelectUser(used.Username)
+	if err != nil || user.Disabled {
+		a.requestLogger(r).Info("Refresh for unknown or disabled user", logging.User, used.Username)
+		a.store.RevokeRefreshFamily(used.FamilyID)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	a.writeTokens(w, r, user, used.FamilyID, refresh)
+}
+
+// LogoutHandler отзывает текущий access токен и всю цепочку refresh токенов
+// сессии. Прежние access токены сессии не отзываются и действуют до конца
+// своего короткого срока; все токены сразу отзывает смена пароля.
+func (a *Application) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+	if err := a.revokeAccessToken(claims); errors.Is(err, errNoExpiration) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		a.requestLogger(r).Error("Error while revoking token", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if sid, _ := claims["sid"].(string); sid != "" {
+		if err := a.store.RevokeRefreshFamily(sid); err != nil {
+			a.requestLogger(r).Error("Error while revoking session", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	a.requestLogger(r).Info("User logged out")
+	w.WriteHeader(http.StatusOK)
+}
+
+var errNoExpiration = errors.New("token has no expiration time")
+
+// revokeAccessToken отзывает access токен запроса до конца его срока.
+func (a *Application) revokeAccessToken(claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return errNoExpiration
+	}
+	return a.store.RevokeToken(jti, exp.Time, a.now())
+}
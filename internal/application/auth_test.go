@@ -0,0 +1,100 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+// loginTestUser регистрирует пользователя и возвращает ответ /login.
+func loginTestUser(t *testing.T, app *Application, login, password string) map[string]string {
+	t.Helper()
+	hash, _ := db.GenerateHash(password)
+	app.store.InsertUser(&db.User{Name: login, Password: hash})
+
+	req := httptest.NewRequest("POST", "/api/v1/login", strings.NewReader(`{"login": "`+login+`", "password": "`+password+`"}`))
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: expected 200, got %d", w.Code)
+	}
+	var tokens map[string]string
+	json.NewDecoder(w.Body).Decode(&tokens)
+	return tokens
+}
+
+func doRequest(app *Application, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func refresh(app *Application, token string) (*httptest.ResponseRecorder, map[string]string) {
+	w := doRequest(app, "POST", "/api/v1/refresh", "", `{"refresh_token": "`+token+`"}`)
+	var tokens map[string]string
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	return w, tokens
+}
+
+func TestRefreshHandler_Rotation(t *testing.T) {
+	app := newTestApp(t)
+	first := loginTestUser(t, app, "refresher", "pass")
+	if first["refresh_token"] == "" {
+		t.Fatal("Expected refresh token in login response")
+	}
+
+	w, second := refresh(app, first["refresh_token"])
+	if w.Code != http.StatusOK || second["token"] == "" || second["refresh_token"] == "" {
+		t.Fatalf("refresh: expected new token pair, got %d %v", w.Code, second)
+	}
+	if second["refresh_token"] == first["refresh_token"] {
+		t.Error("Expected refresh token to rotate")
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", second["token"], ""); w.Code != http.StatusOK {
+		t.Errorf("Expected refreshed access token to work, got %d", w.Code)
+	}
+
+	// Повторное использование старого токена отзывает всю цепочку.
+	if w, _ := refresh(app, first["refresh_token"]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 on reuse, got %d", w.Code)
+	}
+	if w, _ := refresh(app, second["refresh_token"]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected whole family to be revoked after reuse, got %d", w.Code)
+	}
+}
+
+func TestRefreshHandler_Invalid(t *testing.T) {
+	app := newTestApp(t)
+	if w, _ := refresh(app, "garbage"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unknown token, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/refresh", "", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty body, got %d", w.Code)
+	}
+}
+
+func TestLogoutHandler(t *testing.T) {
+	app := newTestApp(t)
+	tokens := loginTestUser(t, app, "leaver", "pass")
+
+	if w := doRequest(app, "POST", "/api/v1/logout", tokens["token"], ""); w.Code != http.StatusOK {
+		t.Fatalf("logout: expected 200, got %d", w.Code)
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", tokens["token"], ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked access token to be rejected, got %d", w.Code)
+	}
+	if w, _ := refresh(app, tokens["refresh_token"]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected refresh token to be revoked on logout, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/logout", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected logout without token to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,261 @@
+package application
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/scheduler"
+)
+
+// Частота выборки профилей mutex и block, пока работает диагностический сервер.
+const (
+	mutexProfileFraction = 5
+	blockProfileRate     = int(10 * time.Microsecond)
+)
+
+// timedMutex - sync.Mutex, который считает захваты и время ожидания.
+// Незанятый мьютекс захватывается без замера времени.
+type timedMutex struct {
+	sync.Mutex
+	acquired atomic.Int64
+	waits    atomic.Int64
+	waitNs   atomic.Int64
+	maxNs    atomic.Int64
+}
+
+func (m *timedMutex) Lock() {
+	m.acquired.Add(1)
+	if m.TryLock() {
+		return
+	}
+	start := time.Now()
+	m.Mutex.Lock()
+	wait := int64(time.Since(start))
+	m.waits.Add(1)
+	m.waitNs.Add(wait)
+	for {
+		prev := m.maxNs.Load()
+		if wait <= prev || m.maxNs.CompareAndSwap(prev, wait) {
+			break
+		}
+	}
+}
+
+// LockStats - ожидание захвата блокировки с момента запуска.
+type LockStats struct {
+	// Acquired - число захватов; 0, если не считается.
+	Acquired int64 `json:"acquired,omitempty"`
+	// Waits - сколько захватов ждали освобождения.
+	Waits          int64   `json:"waits"`
+	WaitSeconds    float64 `json:"wait_seconds"`
+	MaxWaitSeconds float64 `json:"max_wait_seconds,omitempty"`
+}
+
+func (m *timedMutex) stats() LockStats {
+	return LockStats{
+		Acquired:       m.acquired.Load(),
+		Waits:          m.waits.Load(),
+		WaitSeconds:    time.Duration(m.waitNs.Load()).Seconds(),
+		MaxWaitSeconds: time.Duration(m.maxNs.Load()).Seconds(),
+	}
+}
+
+// dbStatser - хранилище с пулом соединений database/sql; есть у db.SQLiteStore.
+type dbStatser interface {
+	Stats() sql.DBStats
+}
+
+// RuntimeStats - ответ /debug/runtime.
+type RuntimeStats struct {
+	GoVersion           string  `json:"go_version"`
+	UptimeSeconds       float64 `json:"uptime_seconds"`
+	Goroutines          int     `json:"goroutines"`
+	GOMAXPROCS          int     `json:"gomaxprocs"`
+	NumCPU              int     `json:"num_cpu"`
+	HeapAllocBytes      uint64  `json:"heap_alloc_bytes"`
+	HeapInuseBytes      uint64  `json:"heap_inuse_bytes"`
+	SysBytes            uint64  `json:"sys_bytes"`
+	NumGC               uint32  `json:"num_gc"`
+	GCPauseTotalSeconds float64 `json:"gc_pause_total_seconds"`
+}
+
+// ReadyNode - узел в очереди планировщика.
+type ReadyNode struct {
+	NodeID   string     `json:"node_id"`
+	ExprID   string     `json:"expr_id"`
+	User     string     `json:"user"`
+	Depth    int        `json:"depth"`
+	Priority string     `json:"priority"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// Lease - узел, выданный агенту и ещё не вычисленный.
+type Lease struct {
+	NodeID     string    `json:"node_id"`
+	ExprID     string    `json:"expr_id"`
+	User       string    `json:"user"`
+	Operation  string    `json:"operation"`
+	ClaimedAt  time.Time `json:"claimed_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+	// ExpiresAt - когда узел вернётся в очередь, если результата не будет.
+	ExpiresAt time.Time `json:"expires_at"`
+	TraceID   string    `json:"trace_id,omitempty"`
+}
+
+// SchedulerDump - ответ /debug/scheduler.
+type SchedulerDump struct {
+	// Ready - очередь в порядке Scheduler.Snapshot.
+	Ready  []ReadyNode `json:"ready"`
+	Leases []Lease     `json:"leases"`
+	// Waiting - узлы, ждущие вычисления операндов.
+	Waiting int `json:"waiting"`
+	// Locks: app - общий мьютекс оркестратора, store - ожидание соединения с SQLite.
+	Locks map[string]LockStats `json:"locks"`
+}
+
+// DebugHandler возвращает диагностический HTTP API:
+//   - /debug/pprof/ - профили net/http/pprof;
+//   - /debug/runtime - состояние среды выполнения Go;
+//   - /debug/scheduler - очередь, выданные узлы и ожидание блокировок.
+//
+// Авторизации нет, поэтому обработчик обслуживается только на localhost.
+func (a *Application) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/runtime", a.RuntimeHandler)
+	mux.HandleFunc("GET /debug/scheduler", a.SchedulerDumpHandler)
+	return mux
+}
+
+// RunDebugServer обслуживает DebugHandler на Config.DebugAddr до отмены ctx
+// и включает выборку профилей mutex и block. Без адреса ничего не делает.
+func (a *Application) RunDebugServer(ctx context.Context) error {
+	if a.config.DebugAddr == "" {
+		return nil
+	}
+	if err := validateDebugAddr(a.config.DebugAddr); err != nil {
+		a.logger.Error("Invalid debug server address", "addr", a.config.DebugAddr, "error", err)
+		return err
+	}
+	if err := a.openStore(); err != nil {
+		a.logger.Error("Failed to open store", "error", err)
+		return err
+	}
+	runtime.SetMutexProfileFraction(mutexProfileFraction)
+	runtime.SetBlockProfileRate(blockProfileRate)
+	a.logger.Info("Debug server started", "addr", a.config.DebugAddr)
+	server := &http.Server{Addr: a.config.DebugAddr, Handler: a.DebugHandler(), ReadHeaderTimeout: 5 * time.Second}
+	return a.serveHTTP(ctx, server)
+}
+
+// validateDebugAddr разрешает только адреса localhost: у диагностического
+// сервера нет авторизации.
+func validateDebugAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("debug server must listen on a loopback address, got %q", host)
+	}
+	return nil
+}
+
+func (a *Application) RuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeJSON(w, http.StatusOK, RuntimeStats{
+		GoVersion:           runtime.Version(),
+		UptimeSeconds:       time.Since(a.started).Seconds(),
+		Goroutines:          runtime.NumGoroutine(),
+		GOMAXPROCS:          runtime.GOMAXPROCS(0),
+		NumCPU:              runtime.NumCPU(),
+		HeapAllocBytes:      mem.HeapAlloc,
+		HeapInuseBytes:      mem.HeapInuse,
+		SysBytes:            mem.Sys,
+		NumGC:               mem.NumGC,
+		GCPauseTotalSeconds: time.Duration(mem.PauseTotalNs).Seconds(),
+	})
+}
+
+func (a *Application) SchedulerDumpHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	if err := a.loadDeps(); err != nil {
+		a.logger.Error("Failed to load pending nodes", "error", err)
+	}
+	dump := SchedulerDump{Ready: []ReadyNode{}, Leases: []Lease{}}
+	for _, item := range a.sched.Snapshot() {
+		node := ReadyNode{
+			NodeID:   item.NodeID,
+			ExprID:   item.ExprID,
+			User:     item.User,
+			Depth:    item.Depth,
+			Priority: priorityName(item.Priority),
+		}
+		if !item.Deadline.IsZero() {
+			deadline := item.Deadline.UTC()
+			node.Deadline = &deadline
+		}
+		dump.Ready = append(dump.Ready, node)
+	}
+	now := a.now()
+	for id, node := range a.deps.nodes {
+		switch {
+		case !node.claimed.IsZero():
+			dump.Leases = append(dump.Leases, Lease{
+				NodeID:     id,
+				ExprID:     node.item.ExprID,
+				User:       node.item.User,
+				Operation:  node.operation,
+				ClaimedAt:  node.claimed.UTC(),
+				AgeSeconds: now.Sub(node.claimed).Seconds(),
+				ExpiresAt:  node.leaseUntil.UTC(),
+				TraceID:    node.trace.TraceID,
+			})
+		case !a.sched.Contains(id):
+			dump.Waiting++
+		}
+	}
+	a.mu.Unlock()
+
+	// Самые старые выдачи - первыми: они вероятнее всего потеряны агентом.
+	slices.SortFunc(dump.Leases, func(x, y Lease) int {
+		if c := x.ClaimedAt.Compare(y.ClaimedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(x.NodeID, y.NodeID)
+	})
+	dump.Locks = map[string]LockStats{"app": a.mu.stats()}
+	if s, ok := a.store.(dbStatser); ok {
+		st := s.Stats()
+		dump.Locks["store"] = LockStats{Waits: st.WaitCount, WaitSeconds: st.WaitDuration.Seconds()}
+	}
+	writeJSON(w, http.StatusOK, dump)
+}
+
+func priorityName(p scheduler.Priority) string {
+	for name, prio := range priorities {
+		if prio == p {
+			return name
+		}
+	}
+	return db.PriorityNormal
+}
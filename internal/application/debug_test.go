@@ -0,0 +1,135 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/proto"
+)
+
+func debugRequest(t *testing.T, app *Application, path string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	app.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if v != nil {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return w
+}
+
+func TestDebugHandler_PprofAndRuntime(t *testing.T) {
+	app := newTestApp(t)
+	if w := debugRequest(t, app, "/debug/pprof/", nil); w.Code != http.StatusOK {
+		t.Errorf("pprof index: expected 200, got %d", w.Code)
+	}
+	if w := debugRequest(t, app, "/debug/pprof/goroutine?debug=1", nil); w.Code != http.StatusOK {
+		t.Errorf("goroutine profile: expected 200, got %d", w.Code)
+	}
+	var stats RuntimeStats
+	debugRequest(t, app, "/debug/runtime", &stats)
+	if stats.Goroutines == 0 || stats.GOMAXPROCS == 0 || stats.HeapAllocBytes == 0 || stats.GoVersion == "" {
+		t.Errorf("Unexpected runtime stats: %+v", stats)
+	}
+	// Диагностика не входит в публичный API.
+	if w := doRequest(app, "GET", "/debug/pprof/", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected pprof to be hidden from the API listener, got %d", w.Code)
+	}
+}
+
+func TestDebugHandler_SchedulerDump(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Unix(1_700_000_000, 0)
+	app.now = func() time.Time { return now }
+	createTestExpression(t, app, "e-1", "alice", "1+2")
+	createTestExpression(t, app, "e-2", "bob", "(1+2)*3")
+
+	srv := &grpcServer{app: app}
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	now = now.Add(3 * time.Second)
+
+	var dump SchedulerDump
+	debugRequest(t, app, "/debug/scheduler", &dump)
+	if len(dump.Leases) != 1 || dump.Leases[0].NodeID != task.Id || dump.Leases[0].Operation != task.Operation ||
+		dump.Leases[0].AgeSeconds != 3 {
+		t.Errorf("Unexpected leases: %+v", dump.Leases)
+	}
+	if len(dump.Ready) != 1 || dump.Ready[0].NodeID == task.Id || dump.Ready[0].Priority != "normal" {
+		t.Errorf("Unexpected ready queue: %+v", dump.Ready)
+	}
+	if dump.Waiting != 1 {
+		t.Errorf("Expected the multiplication to wait for its operand, got %d", dump.Waiting)
+	}
+	if dump.Locks["app"].Acquired == 0 {
+		t.Errorf("Expected app lock acquisitions to be counted: %+v", dump.Locks)
+	}
+	if _, ok := dump.Locks["store"]; !ok {
+		t.Errorf("Expected SQLite pool stats: %+v", dump.Locks)
+	}
+}
+
+func TestTimedMutex(t *testing.T) {
+	var m timedMutex
+	m.Lock()
+	done := make(chan struct{})
+	go func() {
+		m.Lock()
+		m.Unlock()
+		close(done)
+	}()
+	// Второй захват начался и ждёт.
+	for m.acquired.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	m.Unlock()
+	<-done
+
+	stats := m.stats()
+	if stats.Acquired != 2 || stats.Waits != 1 || stats.WaitSeconds < 0.005 || stats.MaxWaitSeconds != stats.WaitSeconds {
+		t.Errorf("Unexpected lock stats: %+v", stats)
+	}
+}
+
+func TestConfigFromEnv_DebugAddr(t *testing.T) {
+	os.Setenv("DEBUG_PORT", "6060")
+	defer os.Unsetenv("DEBUG_PORT")
+	if addr := ConfigFromEnv().DebugAddr; addr != "127.0.0.1:6060" {
+		t.Errorf("Expected the debug listener on localhost, got %q", addr)
+	}
+	os.Unsetenv("DEBUG_PORT")
+	if addr := ConfigFromEnv().DebugAddr; addr != "" {
+		t.Errorf("Expected the debug listener to be off by default, got %q", addr)
+	}
+}
+
+func TestRunDebugServer_LoopbackOnly(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		"127.0.0.1:6060": true,
+		"[::1]:6060":     true,
+		"localhost:6060": true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.5:6060":  false,
+		"6060":           false,
+	} {
+		if err := validateDebugAddr(addr); (err == nil) != ok {
+			t.Errorf("validateDebugAddr(%q) = %v", addr, err)
+		}
+	}
+
+	app := newTestApp(t)
+	app.config.DebugAddr = "0.0.0.0:0"
+	if err := app.RunDebugServer(context.Background()); err == nil {
+		t.Error("Expected RunDebugServer to refuse a public address")
+	}
+}
@@ -0,0 +1,180 @@
+package application
+
+import (
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/scheduler"
+	"github.com/saykoooo/calc_go/internal/tracing"
+)
+
+// depNode - ещё не вычисленная операция.
+type depNode struct {
+	item   scheduler.Item
+	parent string
+	// waiting - число ещё не вычисленных операндов.
+	waiting int
+	// claimed - когда узел выдан агенту; нулевое, пока не выдан.
+	claimed   time.Time
+	operation string
+	// leaseUntil - до какого времени ждать результат выданного узла.
+	leaseUntil time.Time
+	// trace - спан запроса, создавшего выражение; span - спан выдачи
+	// узла агенту, создаётся при выдаче.
+	trace tracing.SpanContext
+	span  string
+}
+
+// depGraph хранит в памяти зависимости невычисленных операций и ставит
+// узел в очередь планировщика, как только вычислены оба его операнда.
+// Хранилище остаётся журналом: после перезапуска граф строится заново
+// по PendingNodes. Используется под a.mu.
+type depGraph struct {
+	sched *scheduler.Scheduler
+	nodes map[string]*depNode
+	exprs map[string][]string
+}
+
+func newDepGraph(sched *scheduler.Scheduler) *depGraph {
+	return &depGraph{
+		sched: sched,
+		nodes: make(map[string]*depNode),
+		exprs: make(map[string][]string),
+	}
+}
+
+// add регистрирует операции выражений. Родители находятся по операндам,
+// поэтому выражение должно добавляться целиком.
+func (g *depGraph) add(nodes []db.PendingNode) {
+	for _, n := range nodes {
+		g.nodes[n.NodeID] = &depNode{
+			item: scheduler.Item{
+				NodeID:   n.NodeID,
+				ExprID:   n.ExprID,
+				User:     n.Username,
+				Depth:    n.Depth,
+				Priority: priorities[n.Priority],
+				Deadline: n.Deadline,
+			},
+			waiting: n.Waiting,
+			trace:   tracing.SpanContext{TraceID: n.TraceID, SpanID: n.SpanID},
+		}
+		g.exprs[n.ExprID] = append(g.exprs[n.ExprID], n.NodeID)
+	}
+	for _, n := range nodes {
+		for _, child := range []string{n.Left, n.Right} {
+			if c, ok := g.nodes[child]; ok {
+				c.parent = n.NodeID
+			}
+		}
+	}
+	for _, n := range nodes {
+		if n.Status == "pending" && n.Waiting == 0 {
+			g.sched.Push(g.nodes[n.NodeID].item)
+		}
+	}
+}
+
+// complete отмечает узел вычисленным и ставит в очередь родителя,
+// если он больше ничего не ждёт.
+func (g *depGraph) complete(nodeID string) {
+	node, ok := g.nodes[nodeID]
+	if !ok {
+		return
+	}
+	delete(g.nodes, nodeID)
+	// Результат мог прийти после конца аренды, когда узел уже снова в очереди.
+	g.sched.RemoveNode(nodeID)
+	parent, ok := g.nodes[node.parent]
+	if !ok {
+		// Вычислен корень, выражение завершено.
+		delete(g.exprs, node.item.ExprID)
+		return
+	}
+	parent.waiting--
+	if parent.waiting == 0 {
+		g.sched.Push(parent.item)
+	}
+}
+
+// remove забывает все узлы выражения: оно удалено или просрочено.
+func (g *depGraph) remove(exprID string) {
+	for _, id := range g.exprs[exprID] {
+		delete(g.nodes, id)
+		g.sched.RemoveNode(id)
+	}
+	delete(g.exprs, exprID)
+}
+
+// claim запоминает, когда и какая операция выдана агенту и до какого
+// времени ждать результат. Возвращает контекст спана выдачи; пустой, если
+// выражение не трассируется.
+func (g *depGraph) claim(task db.Task, at, leaseUntil time.Time) tracing.SpanContext {
+	node, ok := g.nodes[task.ID]
+	if !ok {
+		return tracing.SpanContext{}
+	}
+	node.claimed, node.leaseUntil, node.operation = at, leaseUntil, task.Oper
+	if node.trace.TraceID == "" {
+		return tracing.SpanContext{}
+	}
+	node.span = tracing.NewSpanID()
+	return tracing.SpanContext{TraceID: node.trace.TraceID, SpanID: node.span}
+}
+
+// release возвращает выданный узел в очередь.
+func (g *depGraph) release(nodeID string) {
+	node, ok := g.nodes[nodeID]
+	if !ok {
+		return
+	}
+	node.claimed, node.leaseUntil, node.span = time.Time{}, time.Time{}, ""
+	g.sched.Push(node.item)
+}
+
+// exprOf возвращает выражение узла, если узел ещё не вычислен.
+func (g *depGraph) exprOf(nodeID string) (string, bool) {
+	node, ok := g.nodes[nodeID]
+	if !ok {
+		return "", false
+	}
+	return node.item.ExprID, true
+}
+
+// pendingNodes описывает операции нового выражения для depGraph.
+func pendingNodes(expr db.Expression, nodes []*calc.Node) []db.PendingNode {
+	isOperation := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		isOperation[n.ID] = n.Type == "operation"
+	}
+
+	var pending []db.PendingNode
+	for _, n := range nodes {
+		if n.Type != "operation" {
+			continue
+		}
+		p := db.PendingNode{
+			ReadyTask: db.ReadyTask{
+				NodeID:   n.ID,
+				ExprID:   expr.ExprID,
+				Username: expr.Username,
+				Depth:    n.Depth,
+				Priority: expr.Priority,
+				Deadline: expr.Deadline,
+				TraceID:  expr.TraceID,
+				SpanID:   expr.SpanID,
+			},
+			Left:   n.Left,
+			Right:  n.Right,
+			Status: n.Status,
+		}
+		for _, child := range []string{n.Left, n.Right} {
+			if isOperation[child] {
+				p.Waiting++
+			}
+		}
+		pending = append(pending, p)
+	}
+	return pending
+}
@@ -0,0 +1,172 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/internal/scheduler"
+	"github.com/saykoooo/calc_go/proto"
+)
+
+func TestDepGraph_PushesReadyParents(t *testing.T) {
+	root, nodes, _ := calc.ParseExpression("(1+2)*(3+4)-5")
+	expr := db.Expression{ExprID: "e", Username: "u"}
+	g := newDepGraph(scheduler.New(scheduler.Config{}))
+	g.add(pendingNodes(expr, nodes))
+
+	pop := func() string {
+		item, ok := g.sched.Pop()
+		if !ok {
+			return ""
+		}
+		return item.NodeID
+	}
+	ops := map[string]string{}
+	for _, n := range nodes {
+		ops[n.ID] = n.Operation
+	}
+
+	first, second := pop(), pop()
+	if ops[first] != "+" || ops[second] != "+" || pop() != "" {
+		t.Fatalf("Expected only the two additions to be ready, got %s %s", ops[first], ops[second])
+	}
+	g.complete(first)
+	if g.sched.Len() != 0 {
+		t.Fatal("Multiplication must wait for both operands")
+	}
+	g.complete(second)
+	mul := pop()
+	if ops[mul] != "*" {
+		t.Fatalf("Expected multiplication to become ready, got %q", ops[mul])
+	}
+	g.complete(mul)
+	if sub := pop(); sub != root.ID {
+		t.Fatalf("Expected root to become ready, got %q", sub)
+	}
+	g.complete(root.ID)
+	if len(g.nodes) != 0 || len(g.exprs) != 0 {
+		t.Errorf("Expected empty graph after root completed, got %d nodes", len(g.nodes))
+	}
+}
+
+func TestDepGraph_Remove(t *testing.T) {
+	g := newDepGraph(scheduler.New(scheduler.Config{}))
+	for _, id := range []string{"a", "b"} {
+		_, nodes, _ := calc.ParseExpression("(1+2)*3")
+		g.add(pendingNodes(db.Expression{ExprID: id, Username: "u"}, nodes))
+	}
+
+	g.remove("a")
+	if g.sched.Len() != 1 || len(g.nodes) != 2 {
+		t.Fatalf("Expected only nodes of b, got %d queued, %d nodes", g.sched.Len(), len(g.nodes))
+	}
+	if item, _ := g.sched.Pop(); item.ExprID != "b" {
+		t.Errorf("Expected node of b, got %+v", item)
+	}
+}
+
+func TestGetTask_EvaluatesWholeExpression(t *testing.T) {
+	app := newTestApp(t)
+	srv := &grpcServer{app: app}
+	createTestExpression(t, app, "chain-0", "u", "((1+2)*3-4)/5")
+
+	apply := map[string]func(a, b float64) float64{
+		"+": func(a, b float64) float64 { return a + b },
+		"-": func(a, b float64) float64 { return a - b },
+		"*": func(a, b float64) float64 { return a * b },
+		"/": func(a, b float64) float64 { return a / b },
+	}
+	for {
+		task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+		if err != nil {
+			break
+		}
+		result := apply[task.Operation](task.Arg1, task.Arg2)
+		if _, err := srv.SubmitResult(context.Background(), &proto.ResultRequest{Id: task.Id, Result: result}); err != nil {
+			t.Fatalf("SubmitResult: %v", err)
+		}
+	}
+
+	expr, _ := app.store.SelectExpression("chain-0")
+	if expr.Status != "done" || expr.Result != 1 {
+		t.Errorf("Expected done with result 1, got %+v", expr)
+	}
+	if len(app.deps.nodes) != 0 || app.sched.Len() != 0 {
+		t.Errorf("Expected empty queue, got %d nodes", len(app.deps.nodes))
+	}
+}
+
+// sumOfProducts возвращает выражение из terms произведений: все
+// умножения готовы сразу, сложения ждут операндов.
+func sumOfProducts(terms int) string {
+	return strings.TrimSuffix(strings.Repeat("2*3+", terms), "+")
+}
+
+// newBenchApp создаёт оркестратор с SQLite хранилищем, в котором ждут
+// вычисления около nodes операций; половина из них готова сразу.
+func newBenchApp(b *testing.B, nodes int) *Application {
+	b.Helper()
+	quiet := logging.Discard()
+	store, err := db.OpenSQLite(":memory:", db.WithLogger(quiet))
+	if err != nil {
+		b.Fatalf("Failed to open store: %v", err)
+	}
+	b.Cleanup(func() { store.Close() })
+	app := New(WithStore(store), WithConfig(&Config{JwtSecret: "s"}), WithLogger(quiet))
+
+	const terms = 50
+	for i := 0; i*(2*terms-1) < nodes; i++ {
+		root, parsed, _ := calc.ParseExpression(sumOfProducts(terms))
+		id := fmt.Sprintf("bench-%d", i)
+		for _, n := range parsed {
+			n.ExprID = id
+		}
+		expr := db.Expression{ExprID: id, Username: fmt.Sprintf("user-%d", i%10), Status: "processing", RootNodeID: root.ID}
+		if err := store.CreateExpression(expr, parsed); err != nil {
+			b.Fatalf("CreateExpression: %v", err)
+		}
+	}
+	app.mu.Lock()
+	app.loadDeps()
+	app.mu.Unlock()
+	return app
+}
+
+// BenchmarkGetTask измеряет выдачу задачи, когда в очереди 100k узлов,
+// а агенты ещё не вернули ни одного результата.
+func BenchmarkGetTask(b *testing.B) {
+	const queued = 100000
+
+	run := func(b *testing.B, claim func(app *Application) error) {
+		app := newBenchApp(b, queued)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := claim(app); err != nil {
+				// Готовые узлы закончились, начинаем с новой очереди.
+				b.StopTimer()
+				app = newBenchApp(b, queued)
+				b.StartTimer()
+				i--
+			}
+		}
+	}
+
+	b.Run("DependencyGraph", func(b *testing.B) {
+		run(b, func(app *Application) error {
+			_, err := (&grpcServer{app: app}).GetTask(context.Background(), &proto.GetTaskRequest{})
+			return err
+		})
+	})
+	// Для сравнения: поиск готового узла запросом к таблице nodes.
+	b.Run("StoreQuery", func(b *testing.B) {
+		run(b, func(app *Application) error {
+			_, err := app.store.ClaimTask()
+			return err
+		})
+	})
+}
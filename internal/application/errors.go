@@ -0,0 +1,20 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// APIError - тело ответа с ошибкой: {"error": {"code": ..., "message": ...}}.
+// Code - стабильный машиночитаемый идентификатор, Message - для человека.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]APIError{"error": {Code: code, Message: message}})
+}
@@ -6,24 +6,22 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/saykoooo/calc_go/internal/calc"
 	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
 )
 
 func TestCalcHandler_Success(t *testing.T) {
-	err := db.Init("../../data/store.db")
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer db.Stop()
+	app := newTestApp(t)
 
 	reqBody := `{"expression": "2 + 3 * 4"}`
 	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(reqBody))
 	req.Header.Set("username", "testuser")
 	w := httptest.NewRecorder()
 
-	CalcHandler(w, req)
+	app.CalcHandler(w, req)
 
 	if w.Code != http.StatusCreated {
 		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
@@ -38,40 +36,45 @@ func TestCalcHandler_Success(t *testing.T) {
 		t.Error("Expected non-empty ID in response")
 	}
 
-	clearState(response["id"])
-}
-
-func clearState(expr_id string) {
-	db.DeleteNodes(expr_id)
-	db.DeleteExpression(expr_id)
+	clearState(app, response["id"])
 }
 
-func TestRegisterHandler_Success(t *testing.T) {
-	err := db.Init("../../data/store.db")
+func newTestApp(t *testing.T) *Application {
+	t.Helper()
+	store, err := db.OpenSQLite(":memory:")
 	if err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer db.Stop()
+	t.Cleanup(func() { store.Close() })
+	return New(WithStore(store), WithConfig(&Config{
+		JwtSecret:     "testSecret",
+		JwtExpiration: 5 * time.Minute,
+	}))
+}
+
+func clearState(app *Application, expr_id string) {
+	app.store.DeleteNodes(expr_id)
+	app.store.DeleteExpression(expr_id)
+}
 
-	reqBody := `{"login": "newuser", "password": "newpass"}`
+func TestRegisterHandler_Success(t *testing.T) {
+	app := newTestApp(t)
+
+	reqBody := `{"login": "newuser", "password": "new-pass-42"}`
 	req := httptest.NewRequest("POST", "/api/v1/register", strings.NewReader(reqBody))
 	w := httptest.NewRecorder()
 
-	RegisterHandler(w, req)
+	app.RegisterHandler(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
 	}
 
-	db.DeleteUser("newuser")
+	app.store.DeleteUser("newuser")
 }
 
 func TestRegisterHandler_UserExists(t *testing.T) {
-	err := db.Init("../../data/store.db")
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer db.Stop()
+	app := newTestApp(t)
 
 	password, err := db.GenerateHash("existingpass")
 	if err != nil {
@@ -81,31 +84,29 @@ func TestRegisterHandler_UserExists(t *testing.T) {
 		Name:     "existinguser",
 		Password: password,
 	}
-	_, err = db.InsertUser(user)
+	_, err = app.store.InsertUser(user)
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
 
-	reqBody := `{"login": "existinguser", "password": "newpass"}`
+	reqBody := `{"login": "existinguser", "password": "new-pass-42"}`
 	req := httptest.NewRequest("POST", "/api/v1/register", strings.NewReader(reqBody))
 	w := httptest.NewRecorder()
 
-	RegisterHandler(w, req)
+	app.RegisterHandler(w, req)
 
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"code":"login_taken"`) {
+		t.Errorf("Expected login_taken error, got %s", w.Body.String())
 	}
 
-	db.DeleteUser("existinguser")
+	app.store.DeleteUser("existinguser")
 }
 
 func TestLoginHandler_Success(t *testing.T) {
-	app := New()
-	err := db.Init("../../data/store.db")
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer db.Stop()
+	app := newTestApp(t)
 
 	password, err := db.GenerateHash("loginpass")
 	if err != nil {
@@ -115,7 +116,7 @@ func TestLoginHandler_Success(t *testing.T) {
 		Name:     "loginuser",
 		Password: password,
 	}
-	_, err = db.InsertUser(user)
+	_, err = app.store.InsertUser(user)
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
@@ -139,17 +140,11 @@ func TestLoginHandler_Success(t *testing.T) {
 		t.Error("Expected non-empty token in response")
 	}
 
-	db.DeleteUser("loginuser")
+	app.store.DeleteUser("loginuser")
 }
 
 func TestLoginHandler_InvalidCredentials(t *testing.T) {
-	app := New()
-
-	err := db.Init("../../data/store.db")
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer db.Stop()
+	app := newTestApp(t)
 
 	password, err := db.GenerateHash("loginpass2")
 	if err != nil {
@@ -159,7 +154,7 @@ func TestLoginHandler_InvalidCredentials(t *testing.T) {
 		Name:     "loginuser2",
 		Password: password,
 	}
-	_, err = db.InsertUser(user)
+	_, err = app.store.InsertUser(user)
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
@@ -174,21 +169,17 @@ func TestLoginHandler_InvalidCredentials(t *testing.T) {
 		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, w.Code)
 	}
 
-	db.DeleteUser("loginuser2")
+	app.store.DeleteUser("loginuser2")
 }
 
 func TestGetExpressionsHandler_Success(t *testing.T) {
-	err := db.Init("../../data/store.db")
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer db.Stop()
+	app := newTestApp(t)
 
 	user := &db.User{
 		Name:     "expruser",
 		Password: "exprpass",
 	}
-	_, err = db.InsertUser(user)
+	_, err := app.store.InsertUser(user)
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
@@ -201,7 +192,7 @@ func TestGetExpressionsHandler_Success(t *testing.T) {
 		Expr:       "2 + 2",
 		Result:     4,
 	}
-	_, err = db.InsertExpression(expr)
+	_, err = app.store.InsertExpression(expr)
 	if err != nil {
 		t.Fatalf("Failed to create test expression: %v", err)
 	}
@@ -210,7 +201,7 @@ func TestGetExpressionsHandler_Success(t *testing.T) {
 	req.Header.Set("username", "expruser")
 	w := httptest.NewRecorder()
 
-	GetExpressionsHandler(w, req)
+	app.GetExpressionsHandler(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
@@ -227,16 +218,12 @@ func TestGetExpressionsHandler_Success(t *testing.T) {
 		t.Error("Expected at least one expression in response")
 	}
 
-	db.DeleteExpression("testexpr1")
-	db.DeleteUser("expruser")
+	app.store.DeleteExpression("testexpr1")
+	app.store.DeleteUser("expruser")
 }
 
 func TestGetExpressionByIdHandler_Success(t *testing.T) {
-	err := db.Init("../../data/store.db")
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer db.Stop()
+	app := newTestApp(t)
 
 	password, err := db.GenerateHash("loginpass")
 	if err != nil {
@@ -247,7 +234,7 @@ func TestGetExpressionByIdHandler_Success(t *testing.T) {
 		Password: password,
 	}
 
-	_, err = db.InsertUser(user)
+	_, err = app.store.InsertUser(user)
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
@@ -266,7 +253,7 @@ func TestGetExpressionByIdHandler_Success(t *testing.T) {
 		},
 	}
 
-	_, err = db.InsertNodes(nodes)
+	_, err = app.store.InsertNodes(nodes)
 	if err != nil {
 		t.Fatalf("Failed to insert test nodes: %v", err)
 	}
@@ -279,7 +266,7 @@ func TestGetExpressionByIdHandler_Success(t *testing.T) {
 		Expr:       "2 + 3",
 		Result:     5,
 	}
-	_, err = db.InsertExpression(expr)
+	_, err = app.store.InsertExpression(expr)
 	if err != nil {
 		t.Fatalf("Failed to create test expression: %v", err)
 	}
@@ -289,7 +276,7 @@ func TestGetExpressionByIdHandler_Success(t *testing.T) {
 	req.SetPathValue("id", "testexpr2")
 	w := httptest.NewRecorder()
 
-	GetExpressionByIdHandler(w, req)
+	app.GetExpressionByIdHandler(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
@@ -306,16 +293,12 @@ func TestGetExpressionByIdHandler_Success(t *testing.T) {
 		t.Errorf("Expected expression ID %s, got %s", "testexpr2", response.Expression.ID)
 	}
 
-	clearState("testexpr2")
-	db.DeleteUser("exprbyiduser")
+	clearState(app, "testexpr2")
+	app.store.DeleteUser("exprbyiduser")
 }
 
 func TestGetExpressionByIdHandler_Unauthorized(t *testing.T) {
-	err := db.Init("../../data/store.db")
-	if err != nil {
-		t.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer db.Stop()
+	app := newTestApp(t)
 
 	nodes := []*calc.Node{
 		{ID: "a1", ExprID: "testexpr2", Type: "number", Status: "done", Result: 2},
@@ -331,7 +314,7 @@ func TestGetExpressionByIdHandler_Unauthorized(t *testing.T) {
 		},
 	}
 
-	_, err = db.InsertNodes(nodes)
+	_, err := app.store.InsertNodes(nodes)
 	if err != nil {
 		t.Fatalf("Failed to insert test nodes: %v", err)
 	}
@@ -344,7 +327,7 @@ func TestGetExpressionByIdHandler_Unauthorized(t *testing.T) {
 		Expr:       "2 + 3",
 		Result:     5,
 	}
-	_, err = db.InsertExpression(expr)
+	_, err = app.store.InsertExpression(expr)
 	if err != nil {
 		t.Fatalf("Failed to create test expression: %v", err)
 	}
@@ -354,11 +337,72 @@ func TestGetExpressionByIdHandler_Unauthorized(t *testing.T) {
 	req.SetPathValue("id", "testexpr2")
 	w := httptest.NewRecorder()
 
-	GetExpressionByIdHandler(w, req)
+	app.GetExpressionByIdHandler(w, req)
 
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, w.Code)
 	}
 
-	clearState("testexpr2")
+	clearState(app, "testexpr2")
+}
+
+func TestApplication_IsolatedInstances(t *testing.T) {
+	fixed := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	newApp := func(id string) *Application {
+		return New(
+			WithStore(db.NewMemoryStore()),
+			WithClock(func() time.Time { return fixed }),
+			WithIDGenerator(func() string { return id }),
+			WithLogger(logging.Discard()),
+			WithConfig(&Config{JwtSecret: "s", JwtExpiration: time.Hour}),
+		)
+	}
+	first, second := newApp("first-expr"), newApp("second-expr")
+
+	mux := http.NewServeMux()
+	mux.Handle("/one/", http.StripPrefix("/one", first.Handler()))
+	mux.Handle("/two/", http.StripPrefix("/two", second.Handler()))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	post := func(path, token, body string) *http.Response {
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		return resp
+	}
+
+	creds := `{"login": "same", "password": "Correct-Horse-9"}`
+	for _, prefix := range []string{"/one", "/two"} {
+		if resp := post(prefix+"/api/v1/register", "", creds); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: register status %d", prefix, resp.StatusCode)
+		}
+	}
+
+	resp := post("/one/api/v1/login", "", creds)
+	var login map[string]string
+	json.NewDecoder(resp.Body).Decode(&login)
+	resp.Body.Close()
+
+	resp = post("/one/api/v1/calculate", login["token"], `{"expression": "1+2"}`)
+	var created map[string]string
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || created["id"] != "first-expr" {
+		t.Fatalf("Expected 201 with id first-expr, got %d %v", resp.StatusCode, created)
+	}
+
+	exprs, _ := second.store.SelectExpressionsByUser("same")
+	if len(exprs) != 0 {
+		t.Errorf("Expected second instance to be empty, got %d expressions", len(exprs))
+	}
+	exprs, _ = first.store.SelectExpressionsByUser("same")
+	if len(exprs) != 1 {
+		t.Errorf("Expected one expression in first instance, got %d", len(exprs))
+	}
 }
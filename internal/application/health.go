@@ -0,0 +1,124 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Version и Commit задаются при сборке, например:
+//
+//	go build -ldflags "-X github.com/saykoooo/calc_go/internal/application.Version=v1.2.0 -X github.com/saykoooo/calc_go/internal/application.Commit=abc123" ./cmd
+//
+// Без них берутся из сведений о сборке Go.
+var (
+	Version string
+	Commit  string
+)
+
+// readyTimeout ограничивает проверку хранилища в /readyz.
+const readyTimeout = 2 * time.Second
+
+// Необязательные возможности хранилища; есть у db.SQLiteStore.
+type (
+	pinger interface {
+		Ping(ctx context.Context) error
+	}
+	schemaVersioner interface {
+		SchemaVersion() (int, error)
+	}
+)
+
+// BuildInfo - ответ /version.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+	// SchemaVersion - последняя применённая миграция; nil для хранилища без схемы.
+	SchemaVersion *int `json:"schema_version"`
+}
+
+// Readiness - ответ /readyz: общий статус и результат каждой проверки.
+type Readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// buildInfo дополняет Version и Commit сведениями о сборке Go.
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: Commit}
+	bi, ok := debug.ReadBuildInfo()
+	if ok {
+		info.GoVersion = bi.GoVersion
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = s.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// HealthHandler сообщает, что процесс жив.
+func (a *Application) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyHandler проверяет, что хранилище отвечает и, если задан порт gRPC,
+// что RunGRPCServer уже слушает его. Для сервиса на чужом сервере
+// (RegisterGRPC) учитывается состояние из SetGRPCServing.
+func (a *Application) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	ready := Readiness{Status: "ok", Checks: map[string]string{"store": "ok"}}
+	if p, ok := a.store.(pinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := p.Ping(ctx); err != nil {
+			a.logger.Warn("Store is not ready", "error", err)
+			ready.Status, ready.Checks["store"] = "unavailable", "ping failed"
+		}
+	}
+	if a.config.GRPC != "" || a.grpcExternal.Load() {
+		ready.Checks["grpc"] = "ok"
+		switch {
+		case a.grpcServing.Load():
+		case a.grpcExternal.Load():
+			ready.Status, ready.Checks["grpc"] = "unavailable", "not serving"
+		default:
+			ready.Status, ready.Checks["grpc"] = "unavailable", "not listening"
+		}
+	}
+	status := http.StatusOK
+	if ready.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, ready)
+}
+
+// VersionHandler отдаёт версию сборки и схемы базы данных.
+func (a *Application) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	info := buildInfo()
+	if s, ok := a.store.(schemaVersioner); ok {
+		version, err := s.SchemaVersion()
+		if err != nil {
+			a.logger.Error("Failed to read schema version", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal", "failed to read schema version")
+			return
+		}
+		info.SchemaVersion = &version
+	}
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,126 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+	"google.golang.org/grpc"
+)
+
+func TestHealthAndNotFound(t *testing.T) {
+	app := newTestApp(t)
+	if w := doRequest(app, "GET", "/healthz", "", ""); w.Code != http.StatusOK {
+		t.Errorf("healthz: expected 200, got %d", w.Code)
+	}
+	if w := doRequest(app, "GET", "/unknown", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown path: expected 404, got %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	app.NotFoundHandler(w, httptest.NewRequest("GET", "/api/v1/calculate", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("NotFoundHandler: expected 404 for any path, got %d", w.Code)
+	}
+}
+
+func readiness(t *testing.T, app *Application) (int, Readiness) {
+	t.Helper()
+	w := doRequest(app, "GET", "/readyz", "", "")
+	var ready Readiness
+	if err := json.NewDecoder(w.Body).Decode(&ready); err != nil {
+		t.Fatalf("readyz: %v", err)
+	}
+	return w.Code, ready
+}
+
+func TestReadyHandler(t *testing.T) {
+	store, err := db.OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	app := New(WithStore(store), WithConfig(&Config{JwtSecret: "s", JwtExpiration: time.Minute, GRPC: "0"}))
+
+	code, ready := readiness(t, app)
+	if code != http.StatusServiceUnavailable || ready.Checks["store"] != "ok" || ready.Checks["grpc"] != "not listening" {
+		t.Errorf("Expected not ready before gRPC starts: %d %+v", code, ready)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- app.ServeGRPC(context.Background(), lis) }()
+	for deadline := time.Now().Add(5 * time.Second); !app.grpcServing.Load(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("gRPC server did not start")
+		}
+	}
+	if code, ready := readiness(t, app); code != http.StatusOK || ready.Status != "ok" {
+		t.Errorf("Expected ready: %d %+v", code, ready)
+	}
+
+	store.Close()
+	code, ready = readiness(t, app)
+	if code != http.StatusServiceUnavailable || ready.Checks["store"] != "ping failed" {
+		t.Errorf("Expected not ready with closed store: %d %+v", code, ready)
+	}
+
+	lis.Close()
+	<-done
+	if app.grpcServing.Load() {
+		t.Error("Expected gRPC to be marked down after Serve returns")
+	}
+}
+
+func TestReadyHandler_EmbeddedGRPC(t *testing.T) {
+	// ConfigFromEnv задаёт порт gRPC по-умолчанию, но сервер - чужой.
+	app := New(WithStore(db.NewMemoryStore()), WithConfig(&Config{JwtSecret: "s", GRPC: "5000"}))
+	server := grpc.NewServer()
+	app.RegisterGRPC(server)
+	defer server.Stop()
+
+	if code, ready := readiness(t, app); code != http.StatusOK || ready.Checks["grpc"] != "ok" {
+		t.Errorf("Expected embedded gRPC to be ready: %d %+v", code, ready)
+	}
+	app.SetGRPCServing(false)
+	if code, ready := readiness(t, app); code != http.StatusServiceUnavailable || ready.Checks["grpc"] != "not serving" {
+		t.Errorf("Expected not ready after the embedder stopped serving: %d %+v", code, ready)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	defer func(v, c string) { Version, Commit = v, c }(Version, Commit)
+	Version, Commit = "v1.2.3", "abc123"
+
+	store, err := db.OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	schema, _ := store.SchemaVersion()
+	app := New(WithStore(store), WithConfig(&Config{JwtSecret: "s", JwtExpiration: time.Minute}))
+
+	w := doRequest(app, "GET", "/version", "", "")
+	var info BuildInfo
+	json.NewDecoder(w.Body).Decode(&info)
+	if w.Code != http.StatusOK || info.Version != "v1.2.3" || info.Commit != "abc123" || info.GoVersion == "" {
+		t.Errorf("Unexpected build info: %d %+v", w.Code, info)
+	}
+	if schema == 0 || info.SchemaVersion == nil || *info.SchemaVersion != schema {
+		t.Errorf("Expected schema version %d, got %v", schema, info.SchemaVersion)
+	}
+
+	memory := New(WithStore(db.NewMemoryStore()), WithConfig(&Config{JwtSecret: "s", JwtExpiration: time.Minute}))
+	w = doRequest(memory, "GET", "/version", "", "")
+	info = BuildInfo{}
+	json.NewDecoder(w.Body).Decode(&info)
+	if info.SchemaVersion != nil {
+		t.Errorf("Expected no schema version for the memory store, got %d", *info.SchemaVersion)
+	}
+}
@@ -0,0 +1,222 @@
+package application
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey - ключ подписи JWT. Для асимметричных ключей без закрытой
+// части (выведенных из ротации) токены только проверяются.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private any
+	public  any
+}
+
+// KeySet хранит ключи JWT: новые токены подписываются активным ключом,
+// а проверка принимает любой ключ набора. Так можно менять ключи, не
+// разлогинивая пользователей с токенами, подписанными прошлым ключом.
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	order  []string
+}
+
+// NewKeySet собирает набор из ключей; активным становится active
+// (или последний ключ с закрытой частью, если active пуст).
+func NewKeySet(active string, keys ...*SigningKey) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*SigningKey)}
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+		ks.order = append(ks.order, key.ID)
+		if key.private != nil && (active == "" || key.ID == active) {
+			ks.active = key
+		}
+	}
+	if ks.active == nil {
+		return nil, fmt.Errorf("no signing key %q with a private part", active)
+	}
+	return ks, nil
+}
+
+// HMACKeySet - набор из одного HS256 ключа на общем секрете.
+func HMACKeySet(secret string) *KeySet {
+	key := &SigningKey{ID: "hs256", Method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+	ks, _ := NewKeySet("", key)
+	return ks
+}
+
+// GenerateKeySet создаёт временный Ed25519 ключ. Токены, подписанные им,
+// перестают действовать после перезапуска.
+func GenerateKeySet() (*KeySet, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet("", &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, private: priv, public: pub})
+}
+
+// LoadKeySet читает ключи из PEM файлов каталога dir. Идентификатор ключа
+// (kid) - имя файла без расширения. Закрытые ключи (PKCS#8 или PKCS#1)
+// используются для подписи, открытые (PKIX) - только для проверки.
+// Если active пуст, активным становится последний по имени закрытый ключ.
+func LoadKeySet(dir, active string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var keys []*SigningKey
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no *.pem keys in %s", dir)
+	}
+	return NewKeySet(active, keys...)
+}
+
+func parseKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block")
+	}
+
+	var (
+		parsed any
+		err    error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: kid}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case *rsa.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodRS256, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.public = jwt.SigningMethodEdDSA, k
+	case *rsa.PublicKey:
+		key.Method, key.public = jwt.SigningMethodRS256, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+// Sign подписывает claims активным ключом и проставляет kid в заголовок.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.private)
+}
+
+// Keyfunc выбирает ключ проверки по kid и не допускает подмены алгоритма.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok && kid == "" && len(ks.keys) == 1 {
+		// Токены, выпущенные до появления kid.
+		key, ok = ks.active, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// Methods - алгоритмы, допустимые для ключей набора.
+func (ks *KeySet) Methods() []string {
+	var methods []string
+	for _, id := range ks.order {
+		alg := ks.keys[id].Method.Alg()
+		if !slices.Contains(methods, alg) {
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// JWK - открытый ключ в формате RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS возвращает открытые ключи набора в формате RFC 7517.
+// Симметричные ключи не публикуются.
+func (ks *KeySet) JWKS() []JWK {
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys := make([]JWK, 0, len(ks.order))
+	for _, id := range ks.order {
+		key := ks.keys[id]
+		switch pub := key.public.(type) {
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{Kty: "OKP", Kid: id, Use: "sig", Alg: key.Method.Alg(), Crv: "Ed25519", X: b64(pub)})
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{Kty: "RSA", Kid: id, Use: "sig", Alg: key.Method.Alg(),
+				N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())})
+		}
+	}
+	return keys
+}
+
+func (a *Application) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string][]JWK{"keys": a.keys.JWKS()})
+}
@@ -0,0 +1,176 @@
+package application
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newKeyDir создаёт каталог с ключами: выведенный из ротации (только
+// открытый), прошлый Ed25519 и текущий RSA. Возвращает закрытые ключи.
+func newKeyDir(t *testing.T) (string, ed25519.PrivateKey, ed25519.PrivateKey) {
+	dir := t.TempDir()
+
+	retiredPub, retired, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(retiredPub)
+	writePEM(t, dir, "2023-01.pem", "PUBLIC KEY", der)
+
+	_, previous, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ = x509.MarshalPKCS8PrivateKey(previous)
+	writePEM(t, dir, "2024-01.pem", "PRIVATE KEY", der)
+
+	current, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ = x509.MarshalPKCS8PrivateKey(current)
+	writePEM(t, dir, "2025-01.pem", "PRIVATE KEY", der)
+
+	return dir, retired, previous
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"name": "u", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func parseWith(ks *KeySet, token string) error {
+	_, err := jwt.Parse(token, ks.Keyfunc, jwt.WithValidMethods(ks.Methods()))
+	return err
+}
+
+func TestLoadKeySet_Rotation(t *testing.T) {
+	dir, retired, _ := newKeyDir(t)
+	ks, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	token, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if parsed.Header["kid"] != "2025-01" || parsed.Header["alg"] != "RS256" {
+		t.Errorf("Expected newest RSA key to sign, got header %v", parsed.Header)
+	}
+	if err := parseWith(ks, token); err != nil {
+		t.Errorf("Token signed by active key rejected: %v", err)
+	}
+
+	// Токены, подписанные прошлым ключом, продолжают приниматься.
+	previous, err := LoadKeySet(dir, "2024-01")
+	if err != nil {
+		t.Fatalf("LoadKeySet with active 2024-01: %v", err)
+	}
+	old, _ := previous.Sign(testClaims())
+	if err := parseWith(ks, old); err != nil {
+		t.Errorf("Token signed by previous key rejected: %v", err)
+	}
+
+	// Ключ, от которого остался только открытый, проверяет, но не подписывает.
+	tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, testClaims())
+	tok.Header["kid"] = "2023-01"
+	signed, _ := tok.SignedString(retired)
+	if err := parseWith(ks, signed); err != nil {
+		t.Errorf("Token signed by retired key rejected: %v", err)
+	}
+	if _, err := LoadKeySet(dir, "2023-01"); err == nil {
+		t.Error("Expected error when active key has no private part")
+	}
+}
+
+func TestKeySet_RejectsForeignTokens(t *testing.T) {
+	dir, _, _ := newKeyDir(t)
+	ks, _ := LoadKeySet(dir, "")
+
+	_, stranger, _ := ed25519.GenerateKey(rand.Reader)
+	tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, testClaims())
+	tok.Header["kid"] = "unknown"
+	signed, _ := tok.SignedString(stranger)
+	if err := parseWith(ks, signed); err == nil {
+		t.Error("Expected token with unknown kid to be rejected")
+	}
+
+	// Подмена алгоритма: HS256, где секретом выступает открытый ключ.
+	tok = jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	tok.Header["kid"] = "2024-01"
+	signed, _ = tok.SignedString([]byte("2024-01"))
+	if err := parseWith(ks, signed); err == nil {
+		t.Error("Expected HS256 token to be rejected by asymmetric key set")
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	dir, _, previous := newKeyDir(t)
+	ks, _ := LoadKeySet(dir, "")
+	app := New(WithStore(db.NewMemoryStore()), WithKeySet(ks), WithConfig(&Config{JwtExpiration: time.Minute}))
+
+	w := doRequest(app, "GET", "/.well-known/jwks.json", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var body struct {
+		Keys []JWK `json:"keys"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if len(body.Keys) != 3 {
+		t.Fatalf("Expected 3 keys, got %+v", body.Keys)
+	}
+	byID := make(map[string]JWK)
+	for _, k := range body.Keys {
+		byID[k.Kid] = k
+	}
+	if byID["2025-01"].Kty != "RSA" || byID["2025-01"].N == "" || byID["2025-01"].E != "AQAB" {
+		t.Errorf("Unexpected RSA key: %+v", byID["2025-01"])
+	}
+
+	// Сторонний сервис проверяет токен, имея только JWKS.
+	okp := byID["2024-01"]
+	x, _ := base64.RawURLEncoding.DecodeString(okp.X)
+	if okp.Kty != "OKP" || okp.Crv != "Ed25519" || !ed25519.PublicKey(x).Equal(previous.Public()) {
+		t.Errorf("Unexpected Ed25519 key: %+v", okp)
+	}
+}
+
+func TestAuthMiddleware_EdDSA(t *testing.T) {
+	ks, err := GenerateKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := New(WithStore(db.NewMemoryStore()), WithKeySet(ks), WithConfig(&Config{JwtExpiration: time.Minute}))
+	tokens := loginTestUser(t, app, "eddsa", "pass")
+
+	parsed, _, _ := jwt.NewParser().ParseUnverified(tokens["token"], jwt.MapClaims{})
+	if parsed.Header["alg"] != "EdDSA" || parsed.Header["kid"] == nil {
+		t.Errorf("Expected EdDSA token with kid, got header %v", parsed.Header)
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", tokens["token"], ""); w.Code != http.StatusOK {
+		t.Errorf("Expected EdDSA token to be accepted, got %d", w.Code)
+	}
+
+	hs := HMACKeySet("dumbSecretForLaziest")
+	forged, _ := hs.Sign(jwt.MapClaims{"name": "eddsa", "exp": time.Now().Add(time.Minute).Unix()})
+	if w := doRequest(app, "GET", "/api/v1/expressions", forged, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected token signed with the old default secret to be rejected, got %d", w.Code)
+	}
+}
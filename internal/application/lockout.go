@@ -0,0 +1,246 @@
+package application
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+// lockoutPolicy задаёт, сколько неудачных попыток входа прощается и как
+// растёт блокировка: после FreeAttempts каждая следующая ошибка удваивает
+// паузу, начиная с BaseDelay, но не больше MaxDelay. Счётчик забывается
+// через Window без ошибок.
+type lockoutPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+}
+
+var (
+	// Подбор пароля к одной учётной записи.
+	accountLockout = lockoutPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+	// Перебор учётных записей с одного адреса.
+	ipLockout = lockoutPolicy{FreeAttempts: 20, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+)
+
+// maxLockoutEntries - после этого размера устаревшие счётчики вычищаются.
+const maxLockoutEntries = 10000
+
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+	// inFlight - попытки, которые уже прошли begin, но ещё не завершились.
+	inFlight int
+}
+
+// loginGuard считает неудачные попытки входа по учётным записям и адресам.
+// Счётчики живут в памяти и сбрасываются при перезапуске.
+type loginGuard struct {
+	mu       sync.Mutex
+	accounts map[string]*loginFailures
+	ips      map[string]*loginFailures
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{
+		accounts: make(map[string]*loginFailures),
+		ips:      make(map[string]*loginFailures),
+	}
+}
+
+// begin резервирует попытку входа до проверки пароля. Если вход сейчас
+// запрещён, возвращает, сколько ждать; иначе 0, и вызывающий обязан
+// завершить попытку через fail, succeed или release. Параллельных попыток
+// не больше, чем осталось бесплатных: если все они окажутся неудачными,
+// блокировка наступит ровно так же, как при последовательных запросах.
+func (g *loginGuard) begin(login, ip string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	wait := time.Duration(0)
+	for _, f := range []*loginFailures{g.accounts[login], g.ips[ip]} {
+		if f != nil && f.lockedUntil.After(now) {
+			wait = max(wait, f.lockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	account := entry(g.accounts, login, accountLockout, now)
+	addr := entry(g.ips, ip, ipLockout, now)
+	if busy(account, accountLockout) || busy(addr, ipLockout) {
+		// Исход уже идущих попыток неизвестен: просим повторить позже.
+		return accountLockout.BaseDelay
+	}
+	account.inFlight++
+	addr.inFlight++
+	return 0
+}
+
+// busy сообщает, что ещё одна параллельная попытка могла бы пройти
+// сверх бесплатных.
+func busy(f *loginFailures, policy lockoutPolicy) bool {
+	return f.inFlight > 0 && f.count+f.inFlight > policy.FreeAttempts
+}
+
+// fail завершает попытку, начатую begin, как неудачную.
+func (g *loginGuard) fail(login, ip string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	done(g.accounts[login])
+	done(g.ips[ip])
+	record(g.accounts, login, accountLockout, now)
+	record(g.ips, ip, ipLockout, now)
+}
+
+// release завершает попытку, не учитывая её, например при ошибке базы.
+func (g *loginGuard) release(login, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	done(g.accounts[login])
+	done(g.ips[ip])
+}
+
+func done(f *loginFailures) {
+	if f != nil && f.inFlight > 0 {
+		f.inFlight--
+	}
+}
+
+// entry возвращает счётчик по ключу, заводя новый вместо отсутствующего
+// или устаревшего. Незавершённые попытки переносятся в новый счётчик.
+func entry(entries map[string]*loginFailures, key string, policy lockoutPolicy, now time.Time) *loginFailures {
+	if len(entries) >= maxLockoutEntries {
+		for k, f := range entries {
+			if expired(f, policy, now) {
+				delete(entries, k)
+			}
+		}
+	}
+
+	f, ok := entries[key]
+	if !ok || expired(f, policy, now) {
+		inFlight := 0
+		if ok {
+			inFlight = f.inFlight
+		}
+		f = &loginFailures{inFlight: inFlight}
+		entries[key] = f
+	}
+	return f
+}
+
+func record(entries map[string]*loginFailures, key string, policy lockoutPolicy, now time.Time) {
+	f := entry(entries, key, policy, now)
+	f.count++
+	f.last = now
+	if over := f.count - policy.FreeAttempts; over > 0 {
+		delay := policy.MaxDelay
+		if over < 32 {
+			delay = time.Duration(math.Min(float64(policy.BaseDelay)*math.Pow(2, float64(over-1)), float64(policy.MaxDelay)))
+		}
+		f.lockedUntil = now.Add(delay)
+	}
+}
+
+func expired(f *loginFailures, policy lockoutPolicy, now time.Time) bool {
+	return f.inFlight == 0 && now.Sub(f.last) > policy.Window && !f.lockedUntil.After(now)
+}
+
+// succeed завершает попытку как удачную и сбрасывает счётчик учётной
+// записи. Счётчик адреса остаётся: иначе успешный вход в свою учётную
+// запись обнулял бы перебор чужих.
+func (g *loginGuard) succeed(login, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	done(g.ips[ip])
+	if f, ok := g.accounts[login]; ok {
+		if f.inFlight <= 1 {
+			delete(g.accounts, login)
+			return
+		}
+		// Параллельные попытки ещё идут: их резерв сохраняется.
+		*f = loginFailures{inFlight: f.inFlight - 1}
+	}
+}
+
+// rename переносит счётчик учётной записи на новое имя, чтобы смена
+// имени не сбрасывала блокировку.
+func (g *loginGuard) rename(from, to string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.accounts[from]; ok {
+		g.accounts[to] = f
+		delete(g.accounts, from)
+	}
+}
+
+// unlock снимает блокировку с учётной записи и/или адреса.
+// Возвращает true, если что-то было сброшено.
+func (g *loginGuard) unlock(login, ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, hadAccount := g.accounts[login]
+	_, hadIP := g.ips[ip]
+	delete(g.accounts, login)
+	delete(g.ips, ip)
+	return hadAccount || hadIP
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+	http.Error(w, "Too many login attempts", http.StatusTooManyRequests)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyCompare тратит на проверку пароля несуществующего пользователя
+// столько же времени, сколько на настоящую, чтобы по времени ответа
+// нельзя было узнать, есть ли учётная запись.
+func dummyCompare(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = db.GenerateHash("dummy password")
+	})
+	(&db.User{OriginPassword: password}).ComparePassword(db.User{Password: dummyHash})
+}
+
+// UnlockHandler снимает блокировку входа: {"user": "name", "ip": "addr"}.
+func (a *Application) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User string `json:"user"`
+		IP   string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.User == "" && req.IP == "") {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	unlocked := a.guard.unlock(req.User, req.IP)
+	a.requestLogger(r).Info("Login lockout reset", "target", req.User, "ip", req.IP)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"unlocked": unlocked})
+}
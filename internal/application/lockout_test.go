@@ -0,0 +1,168 @@
+package application
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func loginFrom(app *Application, ip, login, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/login", strings.NewReader(`{"login": "`+login+`", "password": "`+password+`"}`))
+	req.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func TestLogin_AccountLockout(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Now()
+	app.now = func() time.Time { return now }
+	loginTestUser(t, app, "victim", "right")
+
+	for i := 0; i < accountLockout.FreeAttempts+1; i++ {
+		if w := loginFrom(app, "10.0.0.1", "victim", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+
+	// Во время блокировки не проходит даже верный пароль, в том числе с другого адреса.
+	w := loginFrom(app, "10.0.0.2", "victim", "right")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Expected 429 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Каждая следующая ошибка удваивает паузу.
+	now = now.Add(time.Second)
+	loginFrom(app, "10.0.0.1", "victim", "wrong")
+	if w := loginFrom(app, "10.0.0.1", "victim", "right"); w.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected Retry-After 2, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	now = now.Add(2 * time.Second)
+	if w := loginFrom(app, "10.0.0.1", "victim", "right"); w.Code != http.StatusOK {
+		t.Fatalf("Expected login after lockout to succeed, got %d", w.Code)
+	}
+	// Успешный вход сбрасывает счётчик.
+	if w := loginFrom(app, "10.0.0.1", "victim", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected counter to be reset after success, got %d", w.Code)
+	}
+}
+
+func TestLogin_LockoutSurvivesRename(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Now()
+	app.now = func() time.Time { return now }
+	token := loginTestUser(t, app, "target", "Target-pass-1")["token"]
+
+	for i := 0; i < accountLockout.FreeAttempts+1; i++ {
+		loginFrom(app, "10.0.0.1", "target", "wrong")
+	}
+	if w := doRequest(app, "PATCH", "/api/v1/me", token, `{"login": "renamed"}`); w.Code != http.StatusOK {
+		t.Fatalf("rename: expected 200, got %d", w.Code)
+	}
+	if w := loginFrom(app, "10.0.0.2", "renamed", "Target-pass-1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected lockout to follow the rename, got %d", w.Code)
+	}
+}
+
+func TestLogin_IPLockout(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Now()
+	app.now = func() time.Time { return now }
+	loginTestUser(t, app, "bystander", "pass")
+
+	// Перебор несуществующих учётных записей с одного адреса.
+	for i := 0; i < ipLockout.FreeAttempts+1; i++ {
+		login := "ghost" + strings.Repeat("x", i)
+		if w := loginFrom(app, "10.0.0.66", login, "guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	if w := loginFrom(app, "10.0.0.66", "bystander", "pass"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for locked address, got %d", w.Code)
+	}
+	if w := loginFrom(app, "10.0.0.7", "bystander", "pass"); w.Code != http.StatusOK {
+		t.Errorf("Expected other addresses to be unaffected, got %d", w.Code)
+	}
+}
+
+func TestLogin_ConcurrentAttempts(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Now()
+	app.now = func() time.Time { return now }
+	loginTestUser(t, app, "victim", "right")
+
+	// Параллельные запросы не дают проверить больше паролей, чем
+	// последовательные: каждый адрес свой, лимит учётной записи общий.
+	const attempts = 50
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- loginFrom(app, fmt.Sprintf("10.0.1.%d", i), "victim", "wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	checked := 0
+	for code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			checked++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("Unexpected status %d", code)
+		}
+	}
+	if checked == 0 || checked > accountLockout.FreeAttempts+1 {
+		t.Errorf("Expected at most %d password checks, got %d", accountLockout.FreeAttempts+1, checked)
+	}
+	if w := loginFrom(app, "10.0.2.1", "victim", "right"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected account to be locked, got %d", w.Code)
+	}
+}
+
+func TestAdmin_Unlock(t *testing.T) {
+	app := newTestApp(t)
+	admin := loginAdmin(t, app, "boss")
+	loginTestUser(t, app, "locked", "pass")
+	for i := 0; i <= accountLockout.FreeAttempts; i++ {
+		loginFrom(app, "10.0.0.1", "locked", "wrong")
+	}
+	if w := loginFrom(app, "10.0.0.1", "locked", "pass"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected account to be locked, got %d", w.Code)
+	}
+
+	w := doRequest(app, "POST", "/api/v1/admin/unlock", admin, `{"user": "locked"}`)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"unlocked":true}` {
+		t.Fatalf("unlock: got %d %s", w.Code, w.Body.String())
+	}
+	if w := loginFrom(app, "10.0.0.1", "locked", "pass"); w.Code != http.StatusOK {
+		t.Errorf("Expected login after unlock to succeed, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/admin/unlock", admin, `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without user and ip, got %d", w.Code)
+	}
+}
+
+func TestLoginGuard_MaxDelay(t *testing.T) {
+	g := newLoginGuard()
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		g.fail("u", "ip", now)
+	}
+	if wait := g.begin("u", "", now); wait != accountLockout.MaxDelay {
+		t.Errorf("Expected delay capped at %v, got %v", accountLockout.MaxDelay, wait)
+	}
+	if wait := g.begin("u", "", now.Add(accountLockout.MaxDelay)); wait != 0 {
+		t.Errorf("Expected lockout to expire, got %v", wait)
+	}
+}
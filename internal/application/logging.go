@@ -0,0 +1,70 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/saykoooo/calc_go/internal/logging"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// agentIDKey - ключ метаданных gRPC, в котором агент передаёт свой идентификатор.
+const agentIDKey = "agent-id"
+
+// LoggingMiddleware пишет строку о каждом запросе логгером запроса,
+// который создаёт RequestIDMiddleware.
+func (a *Application) LoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := a.now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		a.requestLogger(r).Info("HTTP request", "method", r.Method, "path", r.URL.Path,
+			"status", rec.status, "duration", a.now().Sub(start))
+	})
+}
+
+// requestLogger возвращает логгер запроса: с request_id и trace_id, а после
+// AuthMiddleware - и с пользователем.
+func (a *Application) requestLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context(), a.logger)
+}
+
+// withUser добавляет пользователя к логгеру запроса.
+func (a *Application) withUser(ctx context.Context, name string) context.Context {
+	return logging.WithContext(ctx, logging.FromContext(ctx, a.logger).With(logging.User, name))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// agentLogger возвращает логгер вызова агента.
+func (a *Application) agentLogger(ctx context.Context) *slog.Logger {
+	if id := agentID(ctx); id != "" {
+		return a.logger.With(logging.AgentID, id)
+	}
+	return a.logger
+}
+
+// agentID возвращает идентификатор агента из метаданных вызова. Агент без
+// идентификатора обозначается адресом соединения.
+func agentID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(agentIDKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
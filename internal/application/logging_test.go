@@ -0,0 +1,161 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+// newLoggedTestApp создаёт оркестратор, который пишет JSON логи в буфер.
+func newLoggedTestApp(t *testing.T, level string) (*Application, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "json", level)
+	if err != nil {
+		t.Fatalf("logging.New: %v", err)
+	}
+	store := db.NewMemoryStore()
+	return New(WithStore(store), WithLogger(logger), WithConfig(&Config{JwtSecret: "s", JwtExpiration: time.Minute})), &buf
+}
+
+// logRecords разбирает JSON логи, оставляя записи с сообщением msg.
+func logRecords(t *testing.T, buf *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Log line is not JSON: %q", line)
+		}
+		if rec["msg"] == msg {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+func TestLogging_RequestAttributes(t *testing.T) {
+	app, buf := newLoggedTestApp(t, "info")
+	tokens := loginTestUser(t, app, "alice", "Str0ng-passwd")
+
+	w := doRequest(app, "POST", "/api/v1/calculate", tokens["token"], `{"expression": "1+2"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", w.Code)
+	}
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	created := logRecords(t, buf, "Expression created")
+	if len(created) != 1 {
+		t.Fatalf("Expected one record about the expression:\n%s", buf)
+	}
+	rec := created[0]
+	if rec[logging.User] != "alice" || rec[logging.ExprID] != resp["id"] || rec[logging.RequestID] == "" {
+		t.Errorf("Unexpected attributes: %v", rec)
+	}
+	var sameRequest bool
+	for _, r := range logRecords(t, buf, "HTTP request") {
+		if r[logging.RequestID] == rec[logging.RequestID] && r["path"] == "/api/v1/calculate" {
+			sameRequest = true
+		}
+	}
+	if !sameRequest {
+		t.Errorf("Expected the request line with the same request_id:\n%s", buf)
+	}
+}
+
+func TestLogging_AgentCallsAtDebug(t *testing.T) {
+	app, buf := newLoggedTestApp(t, "info")
+	srv := &grpcServer{app: app}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "agent-7"))
+
+	createTestExpression(t, app, "e-1", "u", "1+2")
+	task, err := srv.GetTask(ctx, &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	srv.GetTask(ctx, &proto.GetTaskRequest{})
+	srv.SubmitResult(ctx, &proto.ResultRequest{Id: task.Id, Result: 3})
+
+	if n := len(logRecords(t, buf, "Task handed out")); n != 0 {
+		t.Errorf("Expected task hand-outs only at debug level:\n%s", buf)
+	}
+	finished := logRecords(t, buf, "Expression finished")
+	if len(finished) != 1 {
+		t.Fatalf("Expected one finished record:\n%s", buf)
+	}
+	if rec := finished[0]; rec[logging.AgentID] != "agent-7" || rec[logging.ExprID] != "e-1" || rec[logging.NodeID] != task.Id {
+		t.Errorf("Unexpected attributes: %v", rec)
+	}
+}
+
+func TestLogging_NoSecrets(t *testing.T) {
+	app, buf := newLoggedTestApp(t, "debug")
+	const (
+		password = "Str0ng-passwd"
+		// Пароль, по ошибке введённый в поле логина.
+		mistyped = "Typo-S3cret-passwd"
+	)
+
+	if w := doRequest(app, "POST", "/api/v1/register", "", `{"login": "alice", "password": "`+password+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("register: expected 200, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/register", "", `{"login": "bob", "password": "alice-`+password+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("register: expected 200, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/login", "", `{"login": "`+mistyped+`", "password": "`+password+`"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("login: expected 401, got %d", w.Code)
+	}
+	w := doRequest(app, "POST", "/api/v1/login", "", `{"login": "alice", "password": "`+password+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: expected 200, got %d", w.Code)
+	}
+	var tokens map[string]string
+	json.NewDecoder(w.Body).Decode(&tokens)
+
+	if w := doRequest(app, "POST", "/api/v1/calculate", tokens["token"], `{"expression": "1+2"}`); w.Code != http.StatusCreated {
+		t.Fatalf("calculate: expected 201, got %d", w.Code)
+	}
+	key := createAPIKey(t, app, tokens["token"], `{"scopes": ["calculate"]}`)
+	if w := doRequest(app, "POST", "/api/v1/calculate", key.Key, `{"expression": "2+3"}`); w.Code != http.StatusCreated {
+		t.Fatalf("calculate with api key: expected 201, got %d", w.Code)
+	}
+	// Недействительные учётные данные тоже не должны попадать в лог.
+	forged := tokens["token"][:len(tokens["token"])-4] + "AAAA"
+	doRequest(app, "POST", "/api/v1/calculate", forged, `{"expression": "2+3"}`)
+	doRequest(app, "POST", "/api/v1/calculate", key.Key+"x", `{"expression": "2+3"}`)
+	_, next := refresh(app, tokens["refresh_token"])
+
+	out := buf.String()
+	for name, secret := range map[string]string{
+		"password":          password,
+		"mistyped login":    mistyped,
+		"access token":      tokens["token"],
+		"forged token":      forged,
+		"refresh token":     tokens["refresh_token"],
+		"new access token":  next["token"],
+		"new refresh token": next["refresh_token"],
+		"api key":           key.Key,
+	} {
+		if secret == "" {
+			t.Fatalf("Empty %s", name)
+		}
+		if strings.Contains(out, secret) {
+			t.Errorf("Log contains %s:\n%s", name, out)
+		}
+	}
+
+	loggedIn := logRecords(t, buf, "User logged in")
+	if len(loggedIn) != 1 || loggedIn[0][logging.User] != "alice" {
+		t.Errorf("Expected the validated login to be logged once:\n%s", out)
+	}
+}
@@ -0,0 +1,192 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+type AccountStats struct {
+	Expressions int64            `json:"expressions"`
+	ByStatus    map[string]int64 `json:"by_status"`
+	APIKeys     int              `json:"api_keys"`
+}
+
+type Account struct {
+	ID    int64        `json:"id"`
+	Name  string       `json:"name"`
+	Role  string       `json:"role"`
+	Stats AccountStats `json:"stats"`
+}
+
+// MeHandler возвращает учётную запись текущего пользователя и статистику.
+func (a *Application) MeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.Header.Get("username")
+	user, err := a.store.SelectUser(name)
+	var stats map[string]int64
+	if err == nil {
+		stats, err = a.store.ExpressionStats(name)
+	}
+	var keys []db.APIKey
+	if err == nil {
+		keys, err = a.store.SelectAPIKeysByUser(name)
+	}
+	if err != nil {
+		a.requestLogger(r).Error("Error while getting account", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while getting account")
+		return
+	}
+
+	account := Account{ID: user.ID, Name: user.Name, Role: user.Role,
+		Stats: AccountStats{ByStatus: stats, APIKeys: len(keys)}}
+	for _, num := range stats {
+		account.Stats.Expressions += num
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(account)
+}
+
+// checkPassword проверяет пароль текущего пользователя. Ошибки учитываются
+// так же, как при входе, чтобы украденный токен не позволял подбирать пароль.
+func (a *Application) checkPassword(w http.ResponseWriter, r *http.Request, password string) (db.User, bool) {
+	name, ip := r.Header.Get("username"), clientIP(r)
+	if wait := a.guard.begin(name, ip, a.now()); wait > 0 {
+		writeRetryAfter(w, wait)
+		return db.User{}, false
+	}
+	user, err := a.store.SelectUser(name)
+	if err != nil {
+		a.guard.release(name, ip)
+		a.requestLogger(r).Error("Error while getting user", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while checking password")
+		return db.User{}, false
+	}
+	if err := (&db.User{OriginPassword: password}).ComparePassword(user); err != nil {
+		a.requestLogger(r).Info("Wrong password")
+		a.guard.fail(name, ip, a.now())
+		writeError(w, http.StatusForbidden, "wrong_password", "current password is wrong")
+		return db.User{}, false
+	}
+	a.guard.succeed(name, ip)
+	return user, true
+}
+
+// ChangePasswordHandler меняет пароль. Все выданные ранее токены
+// перестают действовать, в ответ выдаётся новая пара токенов.
+func (a *Application) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return
+	}
+	user, ok := a.checkPassword(w, r, req.CurrentPassword)
+	if !ok {
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		writeError(w, http.StatusBadRequest, "weak_password", "new password must differ from the current one")
+		return
+	}
+	var perr *policyError
+	if err := a.config.validatePassword(user.Name, req.NewPassword); errors.As(err, &perr) {
+		writeError(w, http.StatusBadRequest, perr.Code, perr.Message)
+		return
+	}
+
+	now := a.now()
+	hash, err := db.GenerateHash(req.NewPassword)
+	if err == nil {
+		err = a.store.ChangePassword(user.Name, hash, now)
+	}
+	if err == nil {
+		// Токен запроса уже недействителен по tokens_not_before, но отзыв
+		// не зависит от точности часов.
+		err = a.revokeAccessToken(requestClaims(r))
+	}
+	if err != nil && !errors.Is(err, errNoExpiration) {
+		a.requestLogger(r).Error("Error while changing password", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while changing password")
+		return
+	}
+	a.requestLogger(r).Info("Password changed")
+	user.TokensNotBefore = time.Unix(now.Unix(), 0)
+	a.issueTokens(w, r, user, "")
+}
+
+// RenameHandler меняет имя пользователя. Старые токены с прежним именем
+// перестают действовать, в ответ выдаётся новая пара токенов.
+func (a *Application) RenameHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return
+	}
+	var perr *policyError
+	if err := a.config.validateLogin(req.Login); errors.As(err, &perr) {
+		writeError(w, http.StatusBadRequest, perr.Code, perr.Message)
+		return
+	}
+
+	name := r.Header.Get("username")
+	// Иначе перебором имён можно было бы выпускать токены без ограничений.
+	if !a.checkRate(w, name) {
+		return
+	}
+	a.mu.Lock()
+	err := a.store.RenameUser(name, req.Login)
+	if err == nil {
+		a.limiter.rename(name, req.Login)
+		a.guard.rename(name, req.Login)
+	}
+	a.mu.Unlock()
+	switch {
+	case errors.Is(err, db.ErrUserExists):
+		writeError(w, http.StatusConflict, "login_taken", "user already exists")
+		return
+	case err != nil:
+		a.requestLogger(r).Error("Error while renaming user", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while renaming user")
+		return
+	}
+	a.requestLogger(r).Info("User renamed", "new_name", req.Login)
+
+	user, err := a.store.SelectUser(req.Login)
+	if err != nil {
+		a.requestLogger(r).Error("Error while getting user", "new_name", req.Login, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while renaming user")
+		return
+	}
+	a.issueTokens(w, r, user, "")
+}
+
+// DeleteMeHandler удаляет учётную запись текущего пользователя вместе с
+// выражениями, узлами, API ключами и сессиями. Требует пароль.
+func (a *Application) DeleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return
+	}
+	user, ok := a.checkPassword(w, r, req.Password)
+	if !ok {
+		return
+	}
+
+	if err := a.deleteUser(user.Name); err != nil {
+		a.requestLogger(r).Error("Error while deleting user", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while deleting account")
+		return
+	}
+	a.requestLogger(r).Info("User deleted own account")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,199 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+// newClockedTestApp - тестовое приложение с управляемыми часами.
+func newClockedTestApp(t *testing.T) (*Application, *time.Time) {
+	app := newTestApp(t)
+	now := time.Now().Truncate(time.Second)
+	app.now = func() time.Time { return now }
+	return app, &now
+}
+
+func decodeTokens(w *httptest.ResponseRecorder) map[string]string {
+	var tokens map[string]string
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	return tokens
+}
+
+func TestMeHandler(t *testing.T) {
+	app := newTestApp(t)
+	token := loginTestUser(t, app, "stats", "Stats-pass-1")["token"]
+	for _, e := range []string{"1+1", "2*2"} {
+		doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "`+e+`"}`)
+	}
+	doRequest(app, "POST", "/api/v1/keys", token, `{}`)
+
+	w := doRequest(app, "GET", "/api/v1/me", token, "")
+	var account Account
+	json.NewDecoder(w.Body).Decode(&account)
+	if w.Code != http.StatusOK || account.Name != "stats" || account.Role != db.RoleUser {
+		t.Fatalf("Unexpected account: %d %+v", w.Code, account)
+	}
+	if account.Stats.Expressions != 2 || account.Stats.ByStatus["processing"] != 2 || account.Stats.APIKeys != 1 {
+		t.Errorf("Unexpected stats: %+v", account.Stats)
+	}
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	app, now := newClockedTestApp(t)
+	old := loginTestUser(t, app, "changer", "Old-pass-1")
+	*now = now.Add(time.Second)
+
+	for _, tc := range []struct {
+		body, code string
+		status     int
+	}{
+		{`{"current_password": "wrong", "new_password": "New-pass-2"}`, "wrong_password", http.StatusForbidden},
+		{`{"current_password": "Old-pass-1", "new_password": "short"}`, "weak_password", http.StatusBadRequest},
+		{`{"current_password": "Old-pass-1", "new_password": "Old-pass-1"}`, "weak_password", http.StatusBadRequest},
+	} {
+		w := doRequest(app, "PUT", "/api/v1/me/password", old["token"], tc.body)
+		var resp struct {
+			Error APIError `json:"error"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != tc.status || resp.Error.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", tc.body, tc.status, tc.code, w.Code, resp.Error)
+		}
+	}
+
+	w := doRequest(app, "PUT", "/api/v1/me/password", old["token"], `{"current_password": "Old-pass-1", "new_password": "New-pass-2"}`)
+	fresh := decodeTokens(w)
+	if w.Code != http.StatusOK || fresh["token"] == "" {
+		t.Fatalf("change password: expected new tokens, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Старые токены больше не действуют, новые - действуют.
+	if w := doRequest(app, "GET", "/api/v1/expressions", old["token"], ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected old access token to be rejected, got %d", w.Code)
+	}
+	if w, _ := refresh(app, old["refresh_token"]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected old refresh token to be revoked, got %d", w.Code)
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", fresh["token"], ""); w.Code != http.StatusOK {
+		t.Errorf("Expected new access token to work, got %d", w.Code)
+	}
+	if w := doRequest(app, "POST", "/api/v1/login", "", `{"login": "changer", "password": "New-pass-2"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected login with new password, got %d", w.Code)
+	}
+}
+
+func TestChangePasswordHandler_SameSecond(t *testing.T) {
+	app, _ := newClockedTestApp(t)
+	current := loginTestUser(t, app, "quick", "Old-pass-1")
+	other := decodeTokens(doRequest(app, "POST", "/api/v1/login", "", `{"login": "quick", "password": "Old-pass-1"}`))
+
+	// Часы не сдвигаются: все токены выпущены в ту же секунду, что и смена пароля.
+	w := doRequest(app, "PUT", "/api/v1/me/password", current["token"], `{"current_password": "Old-pass-1", "new_password": "New-pass-2"}`)
+	fresh := decodeTokens(w)
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	for name, token := range map[string]string{"current": current["token"], "other": other["token"]} {
+		if w := doRequest(app, "GET", "/api/v1/expressions", token, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s token from the same second to be rejected, got %d", name, w.Code)
+		}
+	}
+	claims := jwt.MapClaims{}
+	jwt.NewParser().ParseUnverified(current["token"], claims)
+	if revoked, err := app.store.IsTokenRevoked(claims["jti"].(string)); err != nil || !revoked {
+		t.Errorf("Expected token of the request to be revoked, got %v %v", revoked, err)
+	}
+
+	if w := doRequest(app, "GET", "/api/v1/expressions", fresh["token"], ""); w.Code != http.StatusOK {
+		t.Errorf("Expected new access token to work, got %d", w.Code)
+	}
+	w, refreshed := refresh(app, fresh["refresh_token"])
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: expected 200, got %d", w.Code)
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", refreshed["token"], ""); w.Code != http.StatusOK {
+		t.Errorf("Expected refreshed access token to work, got %d", w.Code)
+	}
+	relogin := decodeTokens(doRequest(app, "POST", "/api/v1/login", "", `{"login": "quick", "password": "New-pass-2"}`))
+	if w := doRequest(app, "GET", "/api/v1/expressions", relogin["token"], ""); w.Code != http.StatusOK {
+		t.Errorf("Expected token from a new login to work, got %d", w.Code)
+	}
+}
+
+func TestRenameHandler(t *testing.T) {
+	app := newTestApp(t)
+	old := loginTestUser(t, app, "before", "Rename-pass-1")["token"]
+	loginTestUser(t, app, "taken", "Taken-pass-1")
+	doRequest(app, "POST", "/api/v1/calculate", old, `{"expression": "1+1"}`)
+
+	if w := doRequest(app, "PATCH", "/api/v1/me", old, `{"login": "taken"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for taken login, got %d", w.Code)
+	}
+	if w := doRequest(app, "PATCH", "/api/v1/me", old, `{"login": " bad name"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid login, got %d", w.Code)
+	}
+
+	w := doRequest(app, "PATCH", "/api/v1/me", old, `{"login": "after"}`)
+	fresh := decodeTokens(w)
+	if w.Code != http.StatusOK || fresh["token"] == "" {
+		t.Fatalf("rename: expected new tokens, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(app, "GET", "/api/v1/expressions", old, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected token with old name to be rejected, got %d", w.Code)
+	}
+	loginTestUser(t, app, "before", "Squat-pass-1")
+	if w := doRequest(app, "GET", "/api/v1/expressions", old, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected token with old name to be rejected after re-registration, got %d", w.Code)
+	}
+	w = doRequest(app, "GET", "/api/v1/me", fresh["token"], "")
+	var account Account
+	json.NewDecoder(w.Body).Decode(&account)
+	if account.Name != "after" || account.Stats.Expressions != 1 {
+		t.Errorf("Expected history to follow the rename, got %+v", account)
+	}
+}
+
+func TestDeleteMeHandler(t *testing.T) {
+	app := newTestApp(t)
+	token := loginTestUser(t, app, "leaving", "Leave-pass-1")["token"]
+	app.mu.Lock()
+	app.loadDeps()
+	app.mu.Unlock()
+	w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "(1+2)*3"}`)
+	var created map[string]string
+	json.NewDecoder(w.Body).Decode(&created)
+
+	if w := doRequest(app, "DELETE", "/api/v1/me", token, `{"password": "wrong"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for wrong password, got %d", w.Code)
+	}
+	if app.sched.Len() == 0 {
+		t.Fatal("Expected nodes of the expression to be queued")
+	}
+	if w := doRequest(app, "DELETE", "/api/v1/me", token, `{"password": "Leave-pass-1"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	if app.sched.Len() != 0 || len(app.deps.nodes) != 0 {
+		t.Errorf("Expected nodes of deleted user to leave the queue, got %d queued, %d in graph", app.sched.Len(), len(app.deps.nodes))
+	}
+
+	if w := doRequest(app, "GET", "/api/v1/me", token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected token of deleted user to be rejected, got %d", w.Code)
+	}
+	// Имя занято заново, но старый токен принадлежит удалённой учётной записи.
+	loginTestUser(t, app, "leaving", "Other-pass-1")
+	if w := doRequest(app, "GET", "/api/v1/me", token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected token of deleted user to be rejected after re-registration, got %d", w.Code)
+	}
+	if _, err := app.store.SelectExpression(created["id"]); err == nil {
+		t.Error("Expected expressions to be deleted with the account")
+	}
+	if _, err := app.store.ClaimTask(); err == nil {
+		t.Error("Expected nodes to be deleted with the account")
+	}
+}
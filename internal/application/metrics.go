@@ -0,0 +1,160 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/metrics"
+)
+
+// activeAgentWindow - агент считается активным, если обращался к
+// оркестратору за это время.
+const activeAgentWindow = time.Minute
+
+// taskBuckets - границы гистограммы времени вычисления узла агентом, в
+// секундах. Операции по-умолчанию занимают около секунды.
+var taskBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// appMetrics - метрики оркестратора, которые отдаются на /metrics.
+type appMetrics struct {
+	registry     *metrics.Registry
+	submitted    *metrics.Counter
+	finished     *metrics.Counter
+	queue        *metrics.Gauge
+	taskDuration *metrics.Histogram
+	getTask      *metrics.Counter
+	submitErrors *metrics.Counter
+	leases       *metrics.Counter
+	httpDuration *metrics.Histogram
+	agents       *metrics.Gauge
+
+	mu sync.Mutex
+	// agentsSeen - время последнего обращения агента по адресу соединения.
+	agentsSeen map[string]time.Time
+}
+
+func newAppMetrics() *appMetrics {
+	r := metrics.NewRegistry()
+	return &appMetrics{
+		registry: r,
+		submitted: r.NewCounter("calc_expressions_submitted_total",
+			"Expressions accepted for calculation."),
+		finished: r.NewCounter("calc_expressions_finished_total",
+			"Expressions that left processing, by final status.", "status"),
+		queue: r.NewGauge("calc_queue_nodes",
+			"Operations not yet calculated: ready to be handed out, in progress on agents or waiting for operands.", "state"),
+		taskDuration: r.NewHistogram("calc_task_duration_seconds",
+			"Time from handing a task to an agent until its result is submitted.", taskBuckets, "operation"),
+		getTask: r.NewCounter("calc_get_task_total",
+			"GetTask calls by result: a task was handed out or the queue was empty.", "result"),
+		submitErrors: r.NewCounter("calc_submit_result_errors_total",
+			"Rejected SubmitResult calls.", "reason"),
+		leases: r.NewCounter("calc_task_leases_expired_total",
+			"Tasks returned to the queue because the agent did not submit a result in time."),
+		httpDuration: r.NewHistogram("calc_http_request_duration_seconds",
+			"HTTP request duration by route pattern and status code.", nil, "route", "code"),
+		agents: r.NewGauge("calc_active_agents",
+			"Agents that called the orchestrator within the last minute."),
+		agentsSeen: make(map[string]time.Time),
+	}
+}
+
+// seeAgent отмечает обращение агента. Агенты различаются по ID из
+// метаданных, поэтому переподключение с нового порта не удваивает счёт.
+func (a *Application) seeAgent(ctx context.Context) {
+	id := agentID(ctx)
+	if id == "" {
+		return
+	}
+	m := a.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.agentsSeen[id] = a.now()
+}
+
+// observeTask учитывает время вычисления узла перед его завершением.
+// Вызывается под a.mu.
+func (a *Application) observeTask(nodeID string) {
+	if node, ok := a.deps.nodes[nodeID]; ok && !node.claimed.IsZero() {
+		a.metrics.taskDuration.Observe(a.now().Sub(node.claimed).Seconds(), node.operation)
+	}
+}
+
+// MetricsHandler отдаёт метрики в текстовом формате Prometheus.
+// Размер очереди и число агентов считаются в момент запроса.
+func (a *Application) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	m := a.metrics
+
+	a.mu.Lock()
+	if err := a.loadDeps(); err != nil {
+		a.logger.Error("Failed to load pending nodes", "error", err)
+	}
+	states := map[string]int{"ready": 0, "in_progress": 0, "waiting": 0}
+	for id, node := range a.deps.nodes {
+		switch {
+		case !node.claimed.IsZero():
+			states["in_progress"]++
+		case a.sched.Contains(id):
+			states["ready"]++
+		default:
+			states["waiting"]++
+		}
+	}
+	a.mu.Unlock()
+	for state, n := range states {
+		m.queue.Set(float64(n), state)
+	}
+
+	m.mu.Lock()
+	for addr, seen := range m.agentsSeen {
+		if a.now().Sub(seen) > activeAgentWindow {
+			delete(m.agentsSeen, addr)
+		}
+	}
+	m.agents.Set(float64(len(m.agentsSeen)))
+	m.mu.Unlock()
+
+	m.registry.Handler().ServeHTTP(w, r)
+}
+
+// statusRecorder запоминает код ответа обработчика.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// instrument измеряет время запросов к mux. Маршрут берётся из шаблона,
+// который выбрал mux, чтобы число серий не зависело от параметров пути.
+func (a *Application) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := a.now()
+		rec := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		a.metrics.httpDuration.Observe(a.now().Sub(start).Seconds(), route, strconv.Itoa(rec.status))
+	})
+}
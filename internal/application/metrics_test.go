@@ -0,0 +1,112 @@
+package application
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	app := New(WithStore(db.NewMemoryStore()), WithConfig(&Config{JwtSecret: "s"}), WithClock(func() time.Time { return now }))
+	handler := app.Handler()
+	srv := &grpcServer{app: app}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}})
+
+	createTestExpression(t, app, "m-0", "u", "(1+2)*3")
+	for {
+		task, err := srv.GetTask(ctx, &proto.GetTaskRequest{})
+		if err != nil {
+			break
+		}
+		now = now.Add(2 * time.Second)
+		srv.SubmitResult(ctx, &proto.ResultRequest{Id: task.Id, Result: 1})
+	}
+	srv.SubmitResult(ctx, &proto.ResultRequest{Id: "missing", Result: 1})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`calc_expressions_finished_total{status="done"} 1`,
+		`calc_get_task_total{result="task"} 2`,
+		`calc_get_task_total{result="empty"} 1`,
+		`calc_task_duration_seconds_bucket{operation="+",le="1"} 0`,
+		`calc_task_duration_seconds_bucket{operation="+",le="2.5"} 1`,
+		`calc_task_duration_seconds_count{operation="*"} 1`,
+		`calc_submit_result_errors_total{reason="unknown_node"} 1`,
+		`calc_http_request_duration_seconds_count{route="/",code="404"} 1`,
+		`calc_queue_nodes{state="ready"} 0`,
+		`calc_active_agents 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("Expected %q in metrics:\n%s", want, body)
+		}
+	}
+
+	// Агент, который долго не обращался, больше не считается активным.
+	now = now.Add(2 * activeAgentWindow)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "calc_active_agents 0\n") {
+		t.Errorf("Expected no active agents:\n%s", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `calc_http_request_duration_seconds_count{route="GET /metrics",code="200"} 1`) {
+		t.Errorf("Expected the first scrape to be measured:\n%s", w.Body.String())
+	}
+}
+
+func TestMetricsHandler_QueueDepth(t *testing.T) {
+	app := newTestApp(t)
+	handler := app.Handler()
+	createTestExpression(t, app, "q-0", "u", "(1+2)*(3+4)")
+	if _, err := (&grpcServer{app: app}).GetTask(context.Background(), &proto.GetTaskRequest{}); err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`calc_queue_nodes{state="ready"} 1`,
+		`calc_queue_nodes{state="in_progress"} 1`,
+		`calc_queue_nodes{state="waiting"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf("Expected %q in metrics:\n%s", want, w.Body.String())
+		}
+	}
+}
+
+func TestMetricsHandler_AgentsByID(t *testing.T) {
+	app := New(WithStore(db.NewMemoryStore()), WithConfig(&Config{JwtSecret: "s"}))
+	conn := func(port int, id string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port}})
+		if id == "" {
+			return ctx
+		}
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(agentIDKey, id))
+	}
+
+	// Переподключившийся агент приходит с нового порта, но с тем же ID.
+	app.seeAgent(conn(4000, "agent-1"))
+	app.seeAgent(conn(4001, "agent-1"))
+	app.seeAgent(conn(4002, "agent-2"))
+	// Агент без ID считается по адресу соединения.
+	app.seeAgent(conn(5000, ""))
+	app.seeAgent(conn(5000, ""))
+
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "calc_active_agents 3\n") {
+		t.Errorf("Expected 3 active agents:\n%s", w.Body.String())
+	}
+}
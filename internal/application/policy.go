@@ -0,0 +1,141 @@
+package application
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcrypt учитывает только первые 72 байта пароля, остальное молча
+// отбрасывается, поэтому более длинные пароли не принимаются.
+const maxPasswordBytes = 72
+
+const (
+	defaultLoginMinLength     = 3
+	defaultLoginMaxLength     = 32
+	defaultPasswordMinLength  = 8
+	defaultPasswordMinClasses = 2
+)
+
+// Пароли, которые подбираются первыми. Проверяются без учёта регистра.
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "12345678": true, "123456789": true, "1234567890": true,
+	"qwerty123": true, "qwertyuiop": true, "11111111": true, "iloveyou": true, "admin123": true,
+	"welcome1": true, "letmein1": true, "abc12345": true, "passw0rd": true, "p@ssw0rd": true,
+}
+
+// policyError - нарушение правил регистрации; Code уходит клиенту.
+type policyError struct {
+	Code    string
+	Message string
+}
+
+func (e *policyError) Error() string { return e.Message }
+
+func getEnvInt(name string, defVal int) int {
+	val := os.Getenv(name)
+	if val == "" {
+		return defVal
+	}
+	num, err := strconv.Atoi(val)
+	if err != nil {
+		slog.Warn("Invalid value. Using default value", "name", name, "value", val, "default", defVal)
+		return defVal
+	}
+	return num
+}
+
+// PolicyFromEnv читает из окружения только правила для логинов и паролей
+// (LOGIN_*, PASSWORD_*), например для EnsureAdmin в утилитах, которым не
+// нужна остальная конфигурация.
+func PolicyFromEnv() *Config {
+	config := new(Config)
+	config.policyFromEnv()
+	return config
+}
+
+func (c *Config) policyFromEnv() {
+	c.LoginMinLength = getEnvInt("LOGIN_MIN_LENGTH", defaultLoginMinLength)
+	c.LoginMaxLength = getEnvInt("LOGIN_MAX_LENGTH", defaultLoginMaxLength)
+	c.PasswordMinLength = getEnvInt("PASSWORD_MIN_LENGTH", defaultPasswordMinLength)
+	c.PasswordMinClasses = getEnvInt("PASSWORD_MIN_CLASSES", defaultPasswordMinClasses)
+}
+
+// applyPolicyDefaults заполняет незаданные правила значениями по-умолчанию.
+func (c *Config) applyPolicyDefaults() {
+	if c.LoginMinLength <= 0 {
+		c.LoginMinLength = defaultLoginMinLength
+	}
+	if c.LoginMaxLength <= 0 {
+		c.LoginMaxLength = defaultLoginMaxLength
+	}
+	if c.PasswordMinLength <= 0 {
+		c.PasswordMinLength = defaultPasswordMinLength
+	}
+	if c.PasswordMinClasses <= 0 {
+		c.PasswordMinClasses = defaultPasswordMinClasses
+	}
+}
+
+// validateLogin допускает буквы, цифры и символы "._-"; имя должно
+// начинаться с буквы или цифры.
+func (c *Config) validateLogin(login string) error {
+	n := utf8.RuneCountInString(login)
+	if n < c.LoginMinLength || n > c.LoginMaxLength {
+		return &policyError{"invalid_login", fmt.Sprintf("login must be %d to %d characters long",
+			c.LoginMinLength, c.LoginMaxLength)}
+	}
+	for i, r := range login {
+		ok := unicode.IsLetter(r) || unicode.IsDigit(r) || (i > 0 && strings.ContainsRune("._-", r))
+		if !ok {
+			return &policyError{"invalid_login", "login may contain only letters, digits and . _ - and must start with a letter or digit"}
+		}
+	}
+	return nil
+}
+
+// validatePassword проверяет длину и сложность пароля: число классов
+// символов (строчные, заглавные, цифры, прочие), отсутствие имени
+// пользователя и пароля в списке распространённых.
+func (c *Config) validatePassword(login, password string) error {
+	if len(password) > maxPasswordBytes {
+		return &policyError{"invalid_password", fmt.Sprintf("password must not exceed %d bytes", maxPasswordBytes)}
+	}
+	if utf8.RuneCountInString(password) < c.PasswordMinLength {
+		return &policyError{"weak_password", fmt.Sprintf("password must be at least %d characters long", c.PasswordMinLength)}
+	}
+	if strings.TrimSpace(password) == "" {
+		return &policyError{"weak_password", "password must not be blank"}
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < c.PasswordMinClasses {
+		return &policyError{"weak_password", fmt.Sprintf("password must mix at least %d of: lowercase, uppercase, digits, symbols",
+			c.PasswordMinClasses)}
+	}
+
+	lowered := strings.ToLower(password)
+	if commonPasswords[lowered] {
+		return &policyError{"weak_password", "password is too common"}
+	}
+	if login != "" && strings.Contains(lowered, strings.ToLower(login)) {
+		return &policyError{"weak_password", "password must not contain the login"}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package application
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/logging"
+)
+
+const (
+	defaultRequestsPerMinute     = 60
+	defaultConcurrentExpressions = 10
+	defaultMaxNodes              = 1000
+	defaultMaxExpressionLength   = 1000
+)
+
+// applyQuotaDefaults заполняет незаданные (нулевые) лимиты значениями
+// по-умолчанию. Отрицательное значение отключает лимит.
+func (c *Config) applyQuotaDefaults() {
+	if c.QuotaRequestsPerMinute == 0 {
+		c.QuotaRequestsPerMinute = defaultRequestsPerMinute
+	}
+	if c.QuotaConcurrentExpressions == 0 {
+		c.QuotaConcurrentExpressions = defaultConcurrentExpressions
+	}
+	if c.QuotaMaxNodes == 0 {
+		c.QuotaMaxNodes = defaultMaxNodes
+	}
+	if c.QuotaMaxExpressionLength == 0 {
+		c.QuotaMaxExpressionLength = defaultMaxExpressionLength
+	}
+}
+
+// rateBucket - корзина токенов: вмещает limit запросов и пополняется
+// со скоростью limit в минуту.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter ограничивает число запросов пользователя в минуту.
+// Состояние хранится в памяти.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*rateBucket)}
+}
+
+// take забирает токен из корзины user. Возвращает число оставшихся
+// запросов и, если токенов нет, время до появления следующего.
+func (l *rateLimiter) take(user string, limit int, now time.Time) (remaining int, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rate := float64(limit) / float64(time.Minute)
+	b, ok := l.buckets[user]
+	if !ok {
+		b = &rateBucket{tokens: float64(limit), last: now}
+		l.buckets[user] = b
+	}
+	b.tokens = math.Min(float64(limit), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+
+	// Полные корзины ничего не хранят, их можно забыть.
+	if len(l.buckets) > maxLockoutEntries {
+		for u, other := range l.buckets {
+			if u != user && other.tokens+float64(now.Sub(other.last))*rate >= float64(limit) {
+				delete(l.buckets, u)
+			}
+		}
+	}
+
+	if b.tokens < 1 {
+		return 0, time.Duration((1 - b.tokens) / rate)
+	}
+	b.tokens--
+	return int(b.tokens), 0
+}
+
+// rename переносит корзину пользователя на новое имя, чтобы смена имени
+// не обнуляла лимит.
+func (l *rateLimiter) rename(from, to string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[from]; ok {
+		l.buckets[to] = b
+		delete(l.buckets, from)
+	}
+}
+
+func writeQuotaError(w http.ResponseWriter, status int, code string, limit int, what string) {
+	writeError(w, status, code, fmt.Sprintf("%s (limit %d)", what, limit))
+}
+
+// checkRate применяет лимит запросов и проставляет заголовки X-RateLimit-*.
+func (a *Application) checkRate(w http.ResponseWriter, user string) bool {
+	limit := a.config.QuotaRequestsPerMinute
+	if limit < 0 {
+		return true
+	}
+	remaining, wait := a.limiter.take(user, limit, a.now())
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+		writeQuotaError(w, http.StatusTooManyRequests, "rate_limited", limit, "too many requests per minute")
+		return false
+	}
+	return true
+}
+
+// checkConcurrent проверяет число выражений пользователя в обработке.
+// Вызывается под a.mu, чтобы параллельные запросы не превысили лимит.
+func (a *Application) checkConcurrent(w http.ResponseWriter, user string) bool {
+	limit := a.config.QuotaConcurrentExpressions
+	if limit < 0 {
+		return true
+	}
+	stats, err := a.store.ExpressionStats(user)
+	if err != nil {
+		a.logger.Error("Error while counting expressions", logging.User, user, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "error while checking quota")
+		return false
+	}
+	active := int(stats["processing"])
+	w.Header().Set("X-Quota-Concurrent-Limit", strconv.Itoa(limit))
+	if active >= limit {
+		w.Header().Set("X-Quota-Concurrent-Remaining", "0")
+		writeQuotaError(w, http.StatusTooManyRequests, "too_many_expressions", limit, "too many expressions in progress")
+		return false
+	}
+	w.Header().Set("X-Quota-Concurrent-Remaining", strconv.Itoa(limit-active-1))
+	return true
+}
@@ -0,0 +1,175 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+func newQuotaTestApp(t *testing.T, config Config) (*Application, *time.Time) {
+	now := time.Now()
+	config.JwtSecret, config.JwtExpiration = "s", time.Hour
+	app := New(WithStore(db.NewMemoryStore()), WithConfig(&config), WithClock(func() time.Time { return now }))
+	return app, &now
+}
+
+func quotaError(t *testing.T, body string) APIError {
+	var resp struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("Expected JSON error body, got %q", body)
+	}
+	return resp.Error
+}
+
+func TestQuota_RequestsPerMinute(t *testing.T) {
+	app, now := newQuotaTestApp(t, Config{QuotaRequestsPerMinute: 3, QuotaConcurrentExpressions: -1})
+	token := loginTestUser(t, app, "busy", "Busy-pass-1")["token"]
+	other := loginTestUser(t, app, "calm", "Calm-pass-1")["token"]
+
+	for i, want := range []string{"2", "1", "0"} {
+		w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "1+1"}`)
+		if w.Code != http.StatusCreated || w.Header().Get("X-RateLimit-Remaining") != want || w.Header().Get("X-RateLimit-Limit") != "3" {
+			t.Fatalf("request %d: got %d, remaining %q", i+1, w.Code, w.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+
+	w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "1+1"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "20" {
+		t.Fatalf("Expected 429 with Retry-After 20, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if e := quotaError(t, w.Body.String()); e.Code != "rate_limited" || !strings.Contains(e.Message, "limit 3") {
+		t.Errorf("Unexpected error: %+v", e)
+	}
+
+	// Лимит у каждого пользователя свой.
+	if w := doRequest(app, "POST", "/api/v1/calculate", other, `{"expression": "1+1"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected other user to be unaffected, got %d", w.Code)
+	}
+
+	*now = now.Add(20 * time.Second)
+	if w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "1+1"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected quota to refill, got %d", w.Code)
+	}
+}
+
+func TestQuota_RenameKeepsRateLimit(t *testing.T) {
+	app, _ := newQuotaTestApp(t, Config{QuotaRequestsPerMinute: 3, QuotaConcurrentExpressions: -1})
+	token := loginTestUser(t, app, "hopper", "Hopper-pass-1")["token"]
+
+	for i := 0; i < 2; i++ {
+		doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "1+1"}`)
+	}
+	// Смена имени тоже расходует лимит.
+	w := doRequest(app, "PATCH", "/api/v1/me", token, `{"login": "hopper2"}`)
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("rename: expected 200 with 0 remaining, got %d %q", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+	fresh := decodeTokens(w)["token"]
+	if w := doRequest(app, "POST", "/api/v1/calculate", fresh, `{"expression": "1+1"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the limit to follow the rename, got %d", w.Code)
+	}
+	if w := doRequest(app, "PATCH", "/api/v1/me", fresh, `{"login": "hopper3"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected rename to be rate limited, got %d", w.Code)
+	}
+}
+
+func TestQuota_ConcurrentExpressions(t *testing.T) {
+	app, _ := newQuotaTestApp(t, Config{QuotaConcurrentExpressions: 2})
+	token := loginTestUser(t, app, "parallel", "Parallel-pass-1")["token"]
+
+	var ids []string
+	for _, want := range []string{"1", "0"} {
+		w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "1+1"}`)
+		if w.Code != http.StatusCreated || w.Header().Get("X-Quota-Concurrent-Remaining") != want {
+			t.Fatalf("Expected 201 with %s remaining, got %d %q", want, w.Code, w.Header().Get("X-Quota-Concurrent-Remaining"))
+		}
+		var created map[string]string
+		json.NewDecoder(w.Body).Decode(&created)
+		ids = append(ids, created["id"])
+	}
+
+	w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "1+1"}`)
+	if w.Code != http.StatusTooManyRequests || quotaError(t, w.Body.String()).Code != "too_many_expressions" {
+		t.Fatalf("Expected 429 too_many_expressions, got %d %s", w.Code, w.Body.String())
+	}
+
+	app.store.SetExpressionResult(ids[0], 2)
+	if w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "1+1"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected a slot after an expression finished, got %d", w.Code)
+	}
+}
+
+func TestQuota_TrivialExpressionsDoNotHoldSlots(t *testing.T) {
+	app, _ := newQuotaTestApp(t, Config{QuotaConcurrentExpressions: 1})
+	token := loginTestUser(t, app, "single", "Single-pass-1")["token"]
+
+	for i := 0; i < 3; i++ {
+		w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "5"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("request %d: expected 201, got %d %s", i+1, w.Code, w.Body.String())
+		}
+		var created map[string]string
+		json.NewDecoder(w.Body).Decode(&created)
+		expr, err := app.store.SelectExpression(created["id"])
+		if err != nil || expr.Status != "done" || expr.Result != 5 {
+			t.Fatalf("Expected done expression with result 5, got %+v %v", expr, err)
+		}
+	}
+	if app.sched.Len() != 0 {
+		t.Errorf("Expected nothing queued, got %d", app.sched.Len())
+	}
+}
+
+func TestQuota_ExpressionSize(t *testing.T) {
+	app, _ := newQuotaTestApp(t, Config{QuotaMaxExpressionLength: 10, QuotaMaxNodes: 3})
+	token := loginTestUser(t, app, "big", "Big-pass-1")["token"]
+
+	tests := []struct {
+		expr   string
+		status int
+		code   string
+	}{
+		{"1+2", http.StatusCreated, ""},
+		{"1+2+3", http.StatusRequestEntityTooLarge, "too_many_nodes"},
+		{"1+1+1+1+1+1", http.StatusRequestEntityTooLarge, "expression_too_long"},
+		{strings.Repeat("1+", 5000) + "1", http.StatusRequestEntityTooLarge, "expression_too_long"},
+	}
+	for _, tt := range tests {
+		w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "`+tt.expr+`"}`)
+		if w.Code != tt.status {
+			t.Errorf("%.20s: expected %d, got %d", tt.expr, tt.status, w.Code)
+			continue
+		}
+		if tt.code != "" {
+			if e := quotaError(t, w.Body.String()); e.Code != tt.code {
+				t.Errorf("%.20s: expected %s, got %+v", tt.expr, tt.code, e)
+			}
+		}
+	}
+}
+
+func TestQuota_Unlimited(t *testing.T) {
+	app, _ := newQuotaTestApp(t, Config{
+		QuotaRequestsPerMinute:     -1,
+		QuotaConcurrentExpressions: -1,
+		QuotaMaxNodes:              -1,
+		QuotaMaxExpressionLength:   -1,
+	})
+	token := loginTestUser(t, app, "free", "Free-pass-1")["token"]
+	expr := strings.Repeat("1+", 1500) + "1"
+	for i := 0; i < 70; i++ {
+		w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "`+expr+`"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("request %d: expected 201 without limits, got %d", i+1, w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatal("Expected no quota headers without limits")
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package application
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/proto"
+)
+
+func TestApplication_RecoversAfterRestart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.db")
+	config := &Config{JwtSecret: "s", JwtExpiration: time.Minute}
+	quiet := WithLogger(logging.Discard())
+
+	store, err := db.OpenSQLite(file)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	root, nodes, _ := calc.ParseExpression("2+3")
+	for _, n := range nodes {
+		n.ExprID = "restart"
+	}
+	store.CreateExpression(db.Expression{ExprID: "restart", Username: "u", Status: "processing", RootNodeID: root.ID}, nodes)
+
+	first := New(WithStore(store), WithConfig(config), quiet)
+	srv := &grpcServer{app: first}
+	first.openStore()
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	// Оркестратор "падает", не дождавшись результата.
+	store.Close()
+
+	store, err = db.OpenSQLite(file)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	second := New(WithStore(store), WithConfig(config), quiet)
+	if err := second.openStore(); err != nil {
+		t.Fatalf("Recovery failed: %v", err)
+	}
+
+	srv = &grpcServer{app: second}
+	again, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("Expected in-flight node to be handed out again: %v", err)
+	}
+	if again.Id != task.Id {
+		t.Errorf("Expected task %s, got %s", task.Id, again.Id)
+	}
+	if _, err := srv.SubmitResult(context.Background(), &proto.ResultRequest{Id: again.Id, Result: 5}); err != nil {
+		t.Fatalf("SubmitResult: %v", err)
+	}
+	expr, _ := store.SelectExpression("restart")
+	if expr.Status != "done" || expr.Result != 5 {
+		t.Errorf("Unexpected expression after recovery: %+v", expr)
+	}
+}
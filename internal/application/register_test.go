@@ -0,0 +1,93 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+)
+
+func register(app *Application, body string) (int, APIError) {
+	w := doRequest(app, "POST", "/api/v1/register", "", body)
+	var resp struct {
+		Error APIError `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Error
+}
+
+func TestRegisterHandler_Validation(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name, login, password string
+		code                  string
+	}{
+		{"empty login", "", "Str0ng-pass", "invalid_login"},
+		{"blank login", "   ", "Str0ng-pass", "invalid_login"},
+		{"short login", "ab", "Str0ng-pass", "invalid_login"},
+		{"long login", strings.Repeat("a", 33), "Str0ng-pass", "invalid_login"},
+		{"login with space", "john doe", "Str0ng-pass", "invalid_login"},
+		{"login starts with dot", ".john", "Str0ng-pass", "invalid_login"},
+		{"empty password", "john", "", "weak_password"},
+		{"blank password", "john", "          ", "weak_password"},
+		{"short password", "john", "Ab1-", "weak_password"},
+		{"single class", "john", "abcdefghij", "weak_password"},
+		{"common password", "john", "Passw0rd", "weak_password"},
+		{"contains login", "john", "John-1234", "weak_password"},
+		{"over bcrypt limit", "john", "Ab1-" + strings.Repeat("x", 69), "invalid_password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"login": tt.login, "password": tt.password})
+			status, apiErr := register(app, string(body))
+			if status != http.StatusBadRequest || apiErr.Code != tt.code || apiErr.Message == "" {
+				t.Errorf("Expected 400 %s, got %d %+v", tt.code, status, apiErr)
+			}
+		})
+	}
+
+	if status, apiErr := register(app, `{"login": "john.doe-2", "password": "Ab1-`+strings.Repeat("x", 68)+`"}`); status != http.StatusOK {
+		t.Errorf("Expected 72-byte password to be accepted, got %d %+v", status, apiErr)
+	}
+	if status, apiErr := register(app, `not json`); status != http.StatusBadRequest || apiErr.Code != "invalid_request" {
+		t.Errorf("Expected invalid_request, got %d %+v", status, apiErr)
+	}
+}
+
+func TestRegisterHandler_ConfigurablePolicy(t *testing.T) {
+	app := New(WithStore(db.NewMemoryStore()), WithConfig(&Config{
+		JwtSecret:          "s",
+		JwtExpiration:      time.Minute,
+		LoginMinLength:     1,
+		PasswordMinLength:  4,
+		PasswordMinClasses: 1,
+	}))
+	if status, apiErr := register(app, `{"login": "x", "password": "abcd"}`); status != http.StatusOK {
+		t.Errorf("Expected relaxed policy to accept, got %d %+v", status, apiErr)
+	}
+}
+
+// failingStore отказывает при записи пользователей.
+type failingStore struct {
+	db.Store
+}
+
+func (failingStore) InsertUser(*db.User) (int64, error) {
+	return 0, errors.New("disk I/O error")
+}
+
+func TestRegisterHandler_StorageFailure(t *testing.T) {
+	app := New(WithStore(failingStore{db.NewMemoryStore()}), WithConfig(&Config{JwtSecret: "s", JwtExpiration: time.Minute}))
+	status, apiErr := register(app, `{"login": "john", "password": "Str0ng-pass"}`)
+	if status != http.StatusInternalServerError || apiErr.Code != "internal_error" {
+		t.Errorf("Expected 500 internal_error, got %d %+v", status, apiErr)
+	}
+	if strings.Contains(apiErr.Message, "disk") {
+		t.Errorf("Storage error details must not leak to clients: %q", apiErr.Message)
+	}
+}
@@ -0,0 +1,234 @@
+package application
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/internal/scheduler"
+	"github.com/saykoooo/calc_go/internal/tracing"
+)
+
+// getEnvWeights разбирает веса пользователей вида "alice=3,bob=2".
+func getEnvWeights(name string) map[string]int {
+	val := os.Getenv(name)
+	if val == "" {
+		return nil
+	}
+	weights := make(map[string]int)
+	for _, pair := range strings.Split(val, ",") {
+		user, w, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		num, err := strconv.Atoi(w)
+		if !ok || user == "" || err != nil || num <= 0 {
+			slog.Warn("Invalid value. Ignoring it", "name", name, "value", pair)
+			continue
+		}
+		weights[user] = num
+	}
+	return weights
+}
+
+// defaultLeaseTimeout - сколько сверх времени операции ждать результат от агента.
+const defaultLeaseTimeout = 30 * time.Second
+
+var priorities = map[string]scheduler.Priority{
+	db.PriorityLow:    scheduler.PriorityLow,
+	db.PriorityNormal: scheduler.PriorityNormal,
+	db.PriorityHigh:   scheduler.PriorityHigh,
+}
+
+// parseSchedule проверяет приоритет и срок из запроса на вычисление.
+// Срок хранится с точностью до секунды.
+func (a *Application) parseSchedule(w http.ResponseWriter, req *Request) (string, time.Time, bool) {
+	priority := req.Priority
+	if priority == "" {
+		priority = db.PriorityNormal
+	}
+	if _, ok := priorities[priority]; !ok {
+		writeError(w, http.StatusBadRequest, "invalid_priority", "priority must be low, normal or high")
+		return "", time.Time{}, false
+	}
+
+	var deadline time.Time
+	if req.Deadline != "" {
+		var err error
+		deadline, err = time.Parse(time.RFC3339, req.Deadline)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_deadline", "deadline must be an RFC 3339 timestamp")
+			return "", time.Time{}, false
+		}
+		deadline = deadline.Truncate(time.Second)
+		if !deadline.After(a.now()) {
+			writeError(w, http.StatusBadRequest, "invalid_deadline", "deadline is in the past")
+			return "", time.Time{}, false
+		}
+	}
+	return priority, deadline, true
+}
+
+// resetQueue очищает очередь задач; граф зависимостей будет заново
+// построен из хранилища при следующем обращении.
+func (a *Application) resetQueue() {
+	weights := a.config.SchedulerWeights
+	a.sched = scheduler.New(scheduler.Config{
+		Weight: func(user string) int { return weights[user] },
+	})
+	a.deps = newDepGraph(a.sched)
+	a.depsLoaded = false
+}
+
+// loadDeps строит граф зависимостей по невычисленным узлам хранилища.
+// Вызывается под a.mu.
+func (a *Application) loadDeps() error {
+	if a.depsLoaded {
+		return nil
+	}
+	pending, err := a.store.PendingNodes()
+	if err != nil {
+		return err
+	}
+	a.deps.add(pending)
+	a.depsLoaded = true
+	a.logger.Info("Loaded pending nodes", "pending", len(pending), "ready", a.sched.Len())
+	return nil
+}
+
+// nextTask выдаёт агенту следующий узел в порядке справедливой очереди.
+// Узлы, которые уже нельзя выдать (выражение удалено или просрочено),
+// пропускаются. Вместе с задачей возвращается контекст трассировки узла.
+func (a *Application) nextTask() (db.Task, tracing.SpanContext, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.loadDeps(); err != nil {
+		return db.Task{}, tracing.SpanContext{}, err
+	}
+	a.expireOverdue()
+	a.expireLeases()
+
+	for {
+		item, ok := a.sched.Pop()
+		if !ok {
+			return db.Task{}, tracing.SpanContext{}, db.ErrNotFound
+		}
+		if !item.Deadline.IsZero() && !a.now().Before(item.Deadline) {
+			a.sweepDeadlines()
+			continue
+		}
+		task, err := a.store.ClaimNode(item.NodeID)
+		if errors.Is(err, db.ErrNotFound) {
+			a.deps.remove(item.ExprID)
+			continue
+		}
+		if err != nil {
+			return task, tracing.SpanContext{}, err
+		}
+		opTime, ok := a.operationTime(task.Oper)
+		if !ok {
+			// Такой узел не вычислит ни один агент.
+			if _, err := a.store.FailNode(task.ID); err != nil {
+				return db.Task{}, tracing.SpanContext{}, err
+			}
+			a.deps.remove(item.ExprID)
+			a.metrics.finished.Inc("failed")
+			a.logger.Warn("Expression failed", logging.ExprID, item.ExprID, logging.NodeID, task.ID,
+				"error", "unknown operation "+task.Oper)
+			continue
+		}
+		leaseUntil := a.now().Add(opTime + a.config.LeaseTimeout)
+		a.watchLease(leaseUntil)
+		return task, a.deps.claim(task, a.now(), leaseUntil), nil
+	}
+}
+
+// operationTime возвращает время, которое агент тратит на операцию op.
+func (a *Application) operationTime(op string) (time.Duration, bool) {
+	switch op {
+	case "+":
+		return a.config.TimeAddition, true
+	case "-":
+		return a.config.TimeSubtraction, true
+	case "*":
+		return a.config.TimeMultiplication, true
+	case "/":
+		return a.config.TimeDivision, true
+	default:
+		return 0, false
+	}
+}
+
+// expireLeases возвращает в очередь выданные узлы, результат которых не
+// пришёл до конца аренды: агент упал, потерял связь или был остановлен.
+// Узлы просматриваются, только когда наступает ближайший известный конец
+// аренды. Вызывается под a.mu.
+func (a *Application) expireLeases() {
+	now := a.now()
+	if a.nextLease.IsZero() || now.Before(a.nextLease) {
+		return
+	}
+	a.nextLease = time.Time{}
+	for id, node := range a.deps.nodes {
+		if node.claimed.IsZero() {
+			continue
+		}
+		if now.Before(node.leaseUntil) {
+			a.watchLease(node.leaseUntil)
+			continue
+		}
+		if err := a.store.ReleaseNode(id); err != nil && !errors.Is(err, db.ErrNotFound) {
+			a.logger.Error("Failed to release node", logging.NodeID, id, "error", err)
+			// Повторить при следующей выдаче.
+			a.watchLease(now)
+			continue
+		}
+		a.logger.Warn("Task lease expired", logging.NodeID, id, logging.ExprID, node.item.ExprID,
+			"claimed", node.claimed)
+		a.deps.release(id)
+		a.metrics.leases.Inc()
+	}
+}
+
+// watchLease учитывает конец аренды выданного узла. Вызывается под a.mu.
+func (a *Application) watchLease(until time.Time) {
+	if a.nextLease.IsZero() || until.Before(a.nextLease) {
+		a.nextLease = until
+	}
+}
+
+// expireOverdue помечает "expired" выражения, срок которых наступил.
+// Хранилище опрашивается, только когда наступает ближайший известный
+// срок. Вызывается под a.mu.
+func (a *Application) expireOverdue() {
+	if a.nextDeadline.IsZero() || a.now().Before(a.nextDeadline) {
+		return
+	}
+	a.sweepDeadlines()
+}
+
+// sweepDeadlines завершает просроченные выражения, убирает их узлы из
+// очереди и запоминает следующий срок. Вызывается под a.mu.
+func (a *Application) sweepDeadlines() {
+	expired, next, err := a.store.ExpireExpressions(a.now())
+	if err != nil {
+		a.logger.Error("Failed to expire expressions", "error", err)
+		return
+	}
+	for _, id := range expired {
+		a.deps.remove(id)
+		a.metrics.finished.Inc(db.StatusExpired)
+		a.logger.Info("Expression expired", logging.ExprID, id)
+	}
+	a.nextDeadline = next
+}
+
+// watchDeadline учитывает срок нового выражения. Вызывается под a.mu.
+func (a *Application) watchDeadline(deadline time.Time) {
+	if !deadline.IsZero() && (a.nextDeadline.IsZero() || deadline.Before(a.nextDeadline)) {
+		a.nextDeadline = deadline
+	}
+}
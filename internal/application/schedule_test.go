@@ -0,0 +1,299 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/proto"
+)
+
+func createTestExpression(t *testing.T, app *Application, id, user, expression string) {
+	t.Helper()
+	root, nodes, err := calc.ParseExpression(expression)
+	if err != nil {
+		t.Fatalf("ParseExpression(%s): %v", expression, err)
+	}
+	for _, n := range nodes {
+		n.ExprID = id
+	}
+	expr := db.Expression{ExprID: id, Expr: expression, Username: user, Status: "processing", RootNodeID: root.ID}
+	if err := app.store.CreateExpression(expr, nodes); err != nil {
+		t.Fatalf("CreateExpression: %v", err)
+	}
+}
+
+// taskOwner возвращает владельца выражения, которому принадлежит выданный узел.
+func taskOwner(t *testing.T, app *Application, task *proto.TaskResponse) string {
+	t.Helper()
+	node, err := app.store.SelectNode(task.Id)
+	if err != nil {
+		t.Fatalf("SelectNode: %v", err)
+	}
+	return strings.SplitN(node.ExprID, "-", 2)[0]
+}
+
+func TestGetTask_FairAcrossUsers(t *testing.T) {
+	app := newTestApp(t)
+	srv := &grpcServer{app: app}
+	for i := 0; i < 10; i++ {
+		createTestExpression(t, app, fmt.Sprintf("heavy-%d", i), "heavy", "1+1")
+	}
+	createTestExpression(t, app, "light-0", "light", "2+2")
+	createTestExpression(t, app, "light-1", "light", "2+2")
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+		if err != nil {
+			t.Fatalf("GetTask: %v", err)
+		}
+		got = append(got, taskOwner(t, app, task))
+	}
+	if fmt.Sprint(got) != "[heavy light heavy light]" {
+		t.Errorf("Expected users to alternate despite backlog, got %v", got)
+	}
+}
+
+func TestGetTask_CriticalPathFirst(t *testing.T) {
+	app := newTestApp(t)
+	srv := &grpcServer{app: app}
+	createTestExpression(t, app, "cp", "u", "5*6+((1+2)*3)*4")
+
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if task.Operation != "+" || task.Arg1 != 1 || task.Arg2 != 2 {
+		t.Errorf("Expected the deepest node 1+2 first, got %v %v %v", task.Arg1, task.Operation, task.Arg2)
+	}
+}
+
+func TestGetTask_SkipsDeletedExpressions(t *testing.T) {
+	app := newTestApp(t)
+	srv := &grpcServer{app: app}
+	createTestExpression(t, app, "gone-0", "u", "1+1")
+	createTestExpression(t, app, "kept-0", "u", "2+2")
+
+	// Узел gone стоит в очереди планировщика, когда его выражение удаляется.
+	app.mu.Lock()
+	app.loadDeps()
+	app.mu.Unlock()
+	clearState(app, "gone-0")
+	if app.sched.Len() != 2 {
+		t.Fatalf("Expected both nodes queued, got %d", app.sched.Len())
+	}
+
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if owner := taskOwner(t, app, task); owner != "kept" {
+		t.Errorf("Expected task of the remaining expression, got %s", owner)
+	}
+	if _, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{}); err == nil {
+		t.Error("Expected no task left")
+	}
+	if _, ok := app.deps.exprs["gone-0"]; ok {
+		t.Error("Expected the deleted expression to leave the dependency graph")
+	}
+}
+
+func TestGetEnvWeights(t *testing.T) {
+	os.Setenv("TEST_WEIGHTS", "alice=3, bob=2,broken,eve=-1")
+	defer os.Unsetenv("TEST_WEIGHTS")
+
+	weights := getEnvWeights("TEST_WEIGHTS")
+	if len(weights) != 2 || weights["alice"] != 3 || weights["bob"] != 2 {
+		t.Errorf("Unexpected weights: %v", weights)
+	}
+}
+
+func TestCalcHandler_PriorityAndDeadline(t *testing.T) {
+	app, now := newClockedTestApp(t)
+	token := loginTestUser(t, app, "sched", "Sched-pass-1")["token"]
+
+	for body, code := range map[string]string{
+		`{"expression": "1+1", "priority": "urgent"}`:               "invalid_priority",
+		`{"expression": "1+1", "deadline": "tomorrow"}`:             "invalid_deadline",
+		`{"expression": "1+1", "deadline": "2000-01-01T00:00:00Z"}`: "invalid_deadline",
+	} {
+		w := doRequest(app, "POST", "/api/v1/calculate", token, body)
+		if w.Code != http.StatusBadRequest || quotaError(t, w.Body.String()).Code != code {
+			t.Errorf("%s: expected 400 %s, got %d %s", body, code, w.Code, w.Body.String())
+		}
+	}
+
+	deadline := now.Add(time.Minute).UTC()
+	body := `{"expression": "1+1", "priority": "high", "deadline": "` + deadline.Format(time.RFC3339) + `"}`
+	w := doRequest(app, "POST", "/api/v1/calculate", token, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	id := decodeTokens(w)["id"]
+
+	var resp struct {
+		Expression ExpressionStatus `json:"expression"`
+	}
+	json.Unmarshal(doRequest(app, "GET", "/api/v1/expressions/"+id, token, "").Body.Bytes(), &resp)
+	if resp.Expression.Priority != "high" || resp.Expression.Deadline == nil || !resp.Expression.Deadline.Equal(deadline) {
+		t.Errorf("Unexpected expression: %+v", resp.Expression)
+	}
+}
+
+func TestGetTask_HighPriorityFirst(t *testing.T) {
+	app := newTestApp(t)
+	srv := &grpcServer{app: app}
+	batch := loginTestUser(t, app, "batch", "Batch-pass-1")["token"]
+	ui := loginTestUser(t, app, "ui", "Ui-pass-123")["token"]
+	for i := 0; i < 5; i++ {
+		doRequest(app, "POST", "/api/v1/calculate", batch, `{"expression": "1+1", "priority": "low"}`)
+	}
+	doRequest(app, "POST", "/api/v1/calculate", ui, `{"expression": "2*3", "priority": "high"}`)
+
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if task.Operation != "*" {
+		t.Errorf("Expected the high priority expression first, got %v%s%v", task.Arg1, task.Operation, task.Arg2)
+	}
+}
+
+func TestGetTask_ExpiresMissedDeadlines(t *testing.T) {
+	app, now := newClockedTestApp(t)
+	srv := &grpcServer{app: app}
+	token := loginTestUser(t, app, "hurry", "Hurry-pass-1")["token"]
+
+	deadline := now.Add(10 * time.Second).Format(time.RFC3339)
+	w := doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "(1+2)*3", "deadline": "`+deadline+`"}`)
+	id := decodeTokens(w)["id"]
+	doRequest(app, "POST", "/api/v1/calculate", token, `{"expression": "4-1"}`)
+
+	if _, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{}); err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+
+	*now = now.Add(11 * time.Second)
+	expr, _ := app.store.SelectExpression(id)
+	if expr.Status != "processing" {
+		t.Fatalf("Expected expression to be processing before sweep, got %s", expr.Status)
+	}
+
+	var resp struct {
+		Expression ExpressionStatus `json:"expression"`
+	}
+	json.Unmarshal(doRequest(app, "GET", "/api/v1/expressions/"+id, token, "").Body.Bytes(), &resp)
+	if resp.Expression.Status != db.StatusExpired {
+		t.Errorf("Expected expired expression, got %s", resp.Expression.Status)
+	}
+	if app.sched.Len() != 1 || len(app.deps.nodes) != 1 {
+		t.Errorf("Expected only the other expression to stay queued, got %d queued", app.sched.Len())
+	}
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil || task.Operation != "-" {
+		t.Errorf("Expected the task without deadline, got %v %v", task, err)
+	}
+}
+
+func TestGetTask_RequeuesExpiredLeases(t *testing.T) {
+	app, now := newClockedTestApp(t)
+	srv := &grpcServer{app: app}
+	createTestExpression(t, app, "lost", "user", "1+1")
+
+	lost, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if _, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{}); err == nil {
+		t.Fatal("Expected no task while the lease is held")
+	}
+
+	*now = now.Add(app.config.LeaseTimeout + time.Second)
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask after lease expiry: %v", err)
+	}
+	if task.Id != lost.Id {
+		t.Errorf("Expected node %s to be handed out again, got %s", lost.Id, task.Id)
+	}
+	if body := scrapeMetrics(app); !strings.Contains(body, "calc_task_leases_expired_total 1\n") {
+		t.Errorf("Expected 1 expired lease in metrics:\n%s", body)
+	}
+
+	if _, err := srv.SubmitResult(context.Background(), &proto.ResultRequest{Id: task.Id, Result: 2}); err != nil {
+		t.Fatalf("SubmitResult: %v", err)
+	}
+	expr, _ := app.store.SelectExpression("lost")
+	if expr.Status != "done" || expr.Result != 2 {
+		t.Errorf("Expected done expression with result 2, got %s %v", expr.Status, expr.Result)
+	}
+}
+
+func TestSubmitResult_LateResultAfterRequeue(t *testing.T) {
+	app, now := newClockedTestApp(t)
+	srv := &grpcServer{app: app}
+	createTestExpression(t, app, "slow", "user", "1+1")
+
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	*now = now.Add(app.config.LeaseTimeout + time.Second)
+	app.mu.Lock()
+	app.expireLeases()
+	app.mu.Unlock()
+	if !app.sched.Contains(task.Id) {
+		t.Fatalf("Expected expired node %s to be queued again", task.Id)
+	}
+
+	// Агент всё же прислал результат после конца аренды.
+	if _, err := srv.SubmitResult(context.Background(), &proto.ResultRequest{Id: task.Id, Result: 2}); err != nil {
+		t.Fatalf("SubmitResult: %v", err)
+	}
+	if app.sched.Contains(task.Id) {
+		t.Error("Expected completed node to leave the queue")
+	}
+	expr, _ := app.store.SelectExpression("slow")
+	if expr.Status != "done" {
+		t.Errorf("Expected done expression, got %s", expr.Status)
+	}
+}
+
+func TestSubmitResult_ComputeErrorFailsExpression(t *testing.T) {
+	app := newTestApp(t)
+	srv := &grpcServer{app: app}
+	createTestExpression(t, app, "div", "user", "(1/0)+2")
+
+	task, err := srv.GetTask(context.Background(), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if _, err := srv.SubmitResult(context.Background(), &proto.ResultRequest{Id: task.Id, Error: "division by zero"}); err != nil {
+		t.Fatalf("SubmitResult: %v", err)
+	}
+	expr, _ := app.store.SelectExpression("div")
+	if expr.Status != "failed" {
+		t.Errorf("Expected failed expression, got %s", expr.Status)
+	}
+	if len(app.deps.nodes) != 0 || app.sched.Len() != 0 {
+		t.Errorf("Expected no nodes left, got %d tracked, %d queued", len(app.deps.nodes), app.sched.Len())
+	}
+	if body := scrapeMetrics(app); !strings.Contains(body, `calc_expressions_finished_total{status="failed"} 1`+"\n") {
+		t.Errorf("Expected 1 failed expression in metrics:\n%s", body)
+	}
+}
+
+func scrapeMetrics(app *Application) string {
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
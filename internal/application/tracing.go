@@ -0,0 +1,123 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/saykoooo/calc_go/internal/logging"
+	"github.com/saykoooo/calc_go/internal/tracing"
+)
+
+// RequestIDHeader - заголовок с идентификатором запроса.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength ограничивает идентификатор, принятый от клиента.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware принимает X-Request-ID клиента или создаёт новый и
+// возвращает его в ответе. Запрос продолжает трассу из заголовка
+// traceparent или начинает новую; в контекст кладутся спан запроса и
+// логгер с request_id и trace_id.
+func (a *Application) RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		parent, _ := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentKey))
+		span := tracing.SpanContext{TraceID: parent.TraceID, SpanID: tracing.NewSpanID()}
+		if span.TraceID == "" {
+			span.TraceID = tracing.NewTraceID()
+		}
+		ctx := tracing.ContextWithSpan(r.Context(), span)
+		ctx = logging.WithContext(ctx, a.logger.With(logging.RequestID, id, logging.TraceID, span.TraceID))
+		r = r.WithContext(ctx)
+
+		start := a.now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if !a.traces.Enabled() {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// Шаблон маршрута mux записывает в тот же запрос.
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		name := route
+		if !strings.Contains(route, " ") {
+			name = r.Method + " " + route
+		}
+		exported := tracing.Span{
+			SpanContext: span,
+			Parent:      parent.SpanID,
+			Name:        name,
+			Kind:        tracing.KindServer,
+			Start:       start,
+			End:         a.now(),
+			Attrs: map[string]any{
+				"http.request.method":       r.Method,
+				"http.route":                route,
+				"http.response.status_code": rec.status,
+				"url.path":                  r.URL.Path,
+				logging.RequestID:           id,
+			},
+		}
+		if rec.status >= http.StatusInternalServerError {
+			exported.Err = strconv.Itoa(rec.status) + " " + http.StatusText(rec.status)
+		}
+		if err := a.traces.Export(exported); err != nil {
+			a.requestLogger(r).Warn("Failed to export span", "error", err)
+		}
+	})
+}
+
+// validRequestID принимает непустые идентификаторы из латиницы, цифр и
+// знаков "-_.:", чтобы значение клиента не ломало логи.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// exportTaskSpan пишет спан узла от выдачи агенту до получения результата
+// или ошибки вычисления errMsg. Вызывается под a.mu до deps.complete.
+func (a *Application) exportTaskSpan(ctx context.Context, nodeID, errMsg string) {
+	node, ok := a.deps.nodes[nodeID]
+	if !a.traces.Enabled() || !ok || node.span == "" {
+		return
+	}
+	err := a.traces.Export(tracing.Span{
+		SpanContext: tracing.SpanContext{TraceID: node.trace.TraceID, SpanID: node.span},
+		Parent:      node.trace.SpanID,
+		Name:        "task " + node.operation,
+		Kind:        tracing.KindInternal,
+		Start:       node.claimed,
+		End:         a.now(),
+		Attrs: map[string]any{
+			logging.NodeID:  nodeID,
+			logging.ExprID:  node.item.ExprID,
+			logging.AgentID: agentID(ctx),
+			"operation":     node.operation,
+		},
+		Err: errMsg,
+	})
+	if err != nil {
+		a.logger.Warn("Failed to export span", "error", err)
+	}
+}
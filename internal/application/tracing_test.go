@@ -0,0 +1,146 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/db"
+	"github.com/saykoooo/calc_go/internal/tracing"
+	"github.com/saykoooo/calc_go/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// headerStream запоминает заголовки, которые обработчик gRPC отправил бы агенту.
+type headerStream struct {
+	header metadata.MD
+}
+
+func (s *headerStream) Method() string { return "/Orchestrator/GetTask" }
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *headerStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *headerStream) SetTrailer(metadata.MD) error { return nil }
+
+// exportedSpans разбирает вывод экспортёра: имя спана -> спан OTLP.
+func exportedSpans(t *testing.T, buf *bytes.Buffer) map[string]map[string]any {
+	t.Helper()
+	spans := make(map[string]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]any
+				}
+			}
+		}
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			t.Fatalf("Span line is not JSON: %q", line)
+		}
+		span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+		spans[span["name"].(string)] = span
+	}
+	return spans
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	app := newTestApp(t)
+	for _, tc := range []struct {
+		name, header string
+		echoed       bool
+	}{
+		{"client id", "req-42.a:b_c", true},
+		{"missing", "", false},
+		{"invalid", "bad id\nforged=1", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/unknown", nil)
+			if tc.header != "" {
+				req.Header.Set(RequestIDHeader, tc.header)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, req)
+			got := w.Header().Get(RequestIDHeader)
+			if tc.echoed && got != tc.header {
+				t.Errorf("Expected %q to be echoed, got %q", tc.header, got)
+			}
+			if !tc.echoed && (got == tc.header || !validRequestID(got)) {
+				t.Errorf("Expected a generated id, got %q", got)
+			}
+		})
+	}
+}
+
+func TestTracing_ExpressionToTask(t *testing.T) {
+	var spans bytes.Buffer
+	store := db.NewMemoryStore()
+	app := New(WithStore(store), WithTraceExporter(tracing.NewExporter("calc-test", &spans)),
+		WithConfig(&Config{JwtSecret: "s", JwtExpiration: time.Minute}))
+	tokens := loginTestUser(t, app, "alice", "Str0ng-passwd")
+
+	parent := tracing.SpanContext{TraceID: tracing.NewTraceID(), SpanID: tracing.NewSpanID()}
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1+2"}`))
+	req.Header.Set("Authorization", "Bearer "+tokens["token"])
+	req.Header.Set(tracing.TraceparentKey, parent.Traceparent())
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", w.Code)
+	}
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	expr, err := store.SelectExpression(resp["id"])
+	if err != nil {
+		t.Fatalf("SelectExpression: %v", err)
+	}
+	if expr.TraceID != parent.TraceID || expr.SpanID == parent.SpanID || expr.SpanID == "" {
+		t.Fatalf("Expected the expression to continue the trace: %+v", expr)
+	}
+
+	srv := &grpcServer{app: app}
+	stream := &headerStream{}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDKey, "agent-7"))
+	task, err := srv.GetTask(grpc.NewContextWithServerTransportStream(ctx, stream), &proto.GetTaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	vals := stream.header.Get(tracing.TraceparentKey)
+	if len(vals) != 1 {
+		t.Fatalf("Expected traceparent header, got %v", stream.header)
+	}
+	taskSpan, ok := tracing.ParseTraceparent(vals[0])
+	if !ok || taskSpan.TraceID != parent.TraceID {
+		t.Fatalf("Unexpected traceparent %q", vals[0])
+	}
+	if _, err := srv.SubmitResult(ctx, &proto.ResultRequest{Id: task.Id, Result: 3}); err != nil {
+		t.Fatalf("SubmitResult: %v", err)
+	}
+
+	exported := exportedSpans(t, &spans)
+	httpSpan := exported["POST /api/v1/calculate"]
+	if httpSpan == nil || httpSpan["traceId"] != parent.TraceID || httpSpan["parentSpanId"] != parent.SpanID ||
+		httpSpan["spanId"] != expr.SpanID {
+		t.Errorf("Unexpected HTTP span: %v", httpSpan)
+	}
+	if exported["POST /api/v1/login"] == nil {
+		t.Errorf("Expected a span for every request: %s", spans.String())
+	}
+	nodeSpan := exported["task +"]
+	if nodeSpan == nil || nodeSpan["traceId"] != parent.TraceID || nodeSpan["spanId"] != taskSpan.SpanID ||
+		nodeSpan["parentSpanId"] != expr.SpanID {
+		t.Errorf("Unexpected task span: %v", nodeSpan)
+	}
+}
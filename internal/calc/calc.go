@@ -25,6 +25,9 @@ type Node struct {
 	Operation string
 	Status    string
 	Result    float64
+	// Depth - число операций над узлом до корня. Узлы с большей глубиной
+	// лежат на более длинном (критическом) пути вычисления.
+	Depth int
 }
 
 var (
@@ -180,6 +183,20 @@ func evaluate(rp []Token) (*Node, []*Node, error) {
 		return nil, nil, fmt.Errorf("invalid expression")
 	}
 
+	// В обратной польской записи родитель идёт после детей,
+	// поэтому глубины считаются одним проходом с конца.
+	byID := make(map[string]*Node, len(allNodes))
+	for _, node := range allNodes {
+		byID[node.ID] = node
+	}
+	for i := len(allNodes) - 1; i >= 0; i-- {
+		node := allNodes[i]
+		if node.Type == "operation" {
+			byID[node.Left].Depth = node.Depth + 1
+			byID[node.Right].Depth = node.Depth + 1
+		}
+	}
+
 	return stack[0], allNodes, nil
 }
 
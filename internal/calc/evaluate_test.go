@@ -48,3 +48,23 @@ func TestEvaluate(t *testing.T) {
 		})
 	}
 }
+
+func TestEvaluate_Depth(t *testing.T) {
+	// 2+3*4: корень "+" на глубине 0, "*" и 2 - на 1, 3 и 4 - на 2.
+	_, nodes, err := evaluate([]Token{
+		{Type: "num", Num: 2},
+		{Type: "num", Num: 3},
+		{Type: "num", Num: 4},
+		{Type: "op", Value: "*"},
+		{Type: "op", Value: "+"},
+	})
+	if err != nil {
+		t.Fatalf("evaluate() error = %v", err)
+	}
+	want := []int{1, 2, 2, 1, 0}
+	for i, node := range nodes {
+		if node.Depth != want[i] {
+			t.Errorf("Node %d: expected depth %d, got %d", i, want[i], node.Depth)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ChangePassword меняет хеш пароля, объявляет недействительными токены,
+// выпущенные в секунду now и раньше, и отзывает все refresh токены пользователя.
+func (s *SQLiteStore) ChangePassword(name, hash string, now time.Time) error {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(s.ctx, "UPDATE users SET password=$1, tokens_not_before=$2 WHERE name=$3", hash, now.Unix(), name)
+	if err := affected(result, err); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(s.ctx, "UPDATE refresh_tokens SET revoked=1 WHERE username=$1", name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RenameUser меняет имя пользователя вместе со всеми его данными.
+func (s *SQLiteStore) RenameUser(name, newName string) error {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(s.ctx, "UPDATE users SET name=$1 WHERE name=$2", newName, name)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrUserExists
+	}
+	if err := affected(result, err); err != nil {
+		return err
+	}
+	for _, table := range []string{"expressions", "api_keys", "refresh_tokens"} {
+		if _, err := tx.ExecContext(s.ctx, "UPDATE "+table+" SET username=$1 WHERE username=$2", newName, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ExpressionStats возвращает число выражений пользователя по статусам.
+func (s *SQLiteStore) ExpressionStats(username string) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(s.ctx, "SELECT status, COUNT(*) FROM expressions WHERE username=$1 GROUP BY status", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int64)
+	for rows.Next() {
+		var (
+			status string
+			num    int64
+		)
+		if err := rows.Scan(&status, &num); err != nil {
+			return nil, err
+		}
+		stats[status] = num
+	}
+	return stats, rows.Err()
+}
+
+func (s *MemoryStore) ChangePassword(name, hash string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Password = hash
+	user.TokensNotBefore = time.Unix(now.Unix(), 0)
+	s.users[name] = user
+	for _, token := range s.refreshTokens {
+		if token.Username == name {
+			token.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RenameUser(name, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := s.users[newName]; ok {
+		return ErrUserExists
+	}
+	delete(s.users, name)
+	user.Name = newName
+	s.users[newName] = user
+
+	for _, expr := range s.exprs {
+		if expr.Username == name {
+			expr.Username = newName
+		}
+	}
+	for _, key := range s.apiKeys {
+		if key.Username == name {
+			key.Username = newName
+		}
+	}
+	for _, token := range s.refreshTokens {
+		if token.Username == name {
+			token.Username = newName
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ExpressionStats(username string) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]int64)
+	for _, expr := range s.exprs {
+		if expr.Username == username {
+			stats[expr.Status]++
+		}
+	}
+	return stats, nil
+}
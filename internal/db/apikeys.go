@@ -0,0 +1,171 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// APIKey - персональный ключ для машинных клиентов. Scopes ограничивают
+// набор операций, доступных по ключу.
+type APIKey struct {
+	ID         int64
+	Prefix     string
+	Hash       string
+	Username   string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+const apiKeyColumns = "id, prefix, key_hash, username, name, scopes, created_at, expires_at, last_used_at"
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var (
+		key                       APIKey
+		scopes                    string
+		created, expires, lastUse int64
+	)
+	err := row.Scan(&key.ID, &key.Prefix, &key.Hash, &key.Username, &key.Name, &scopes, &created, &expires, &lastUse)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	key.CreatedAt, key.ExpiresAt, key.LastUsedAt = timeOrZero(created), timeOrZero(expires), timeOrZero(lastUse)
+	return key, nil
+}
+
+func (s *SQLiteStore) InsertAPIKey(key APIKey) (int64, error) {
+	q := `INSERT INTO api_keys (prefix, key_hash, username, name, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	result, err := s.db.ExecContext(s.ctx, q, key.Prefix, key.Hash, key.Username, key.Name,
+		strings.Join(key.Scopes, ","), unixOrZero(key.CreatedAt), unixOrZero(key.ExpiresAt))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *SQLiteStore) SelectAPIKey(prefix string) (APIKey, error) {
+	row := s.db.QueryRowContext(s.ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix=$1", prefix)
+	key, err := scanAPIKey(row)
+	return key, notFound(err)
+}
+
+func (s *SQLiteStore) SelectAPIKeysByUser(username string) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(s.ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE username=$1 ORDER BY id", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteAPIKey(username string, id int64) error {
+	result, err := s.db.ExecContext(s.ctx, "DELETE FROM api_keys WHERE id=$1 AND username=$2", id, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) TouchAPIKey(id int64, now time.Time) error {
+	_, err := s.db.ExecContext(s.ctx, "UPDATE api_keys SET last_used_at=$1 WHERE id=$2", now.Unix(), id)
+	return err
+}
+
+func (s *MemoryStore) InsertAPIKey(key APIKey) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == key.Prefix {
+			return 0, errors.New("api key prefix already exists")
+		}
+	}
+	s.lastAPIKeyID++
+	key.ID = s.lastAPIKeyID
+	key.Scopes = slices.Clone(key.Scopes)
+	s.apiKeys = append(s.apiKeys, &key)
+	return key.ID, nil
+}
+
+func (s *MemoryStore) SelectAPIKey(prefix string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == prefix {
+			return *k, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (s *MemoryStore) SelectAPIKeysByUser(username string) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []APIKey
+	for _, k := range s.apiKeys {
+		if k.Username == username {
+			keys = append(keys, *k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) DeleteAPIKey(username string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.apiKeys)
+	s.apiKeys = slices.DeleteFunc(s.apiKeys, func(k *APIKey) bool { return k.ID == id && k.Username == username })
+	if len(s.apiKeys) == n {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MemoryStore) TouchAPIKey(id int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.ID == id {
+			k.LastUsedAt = now.Truncate(time.Second)
+		}
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ package db
 import (
 	"context"
 	"database/sql"
-	"log"
-	"sync"
+	"errors"
+	"log/slog"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/saykoooo/calc_go/internal/calc"
+	"github.com/saykoooo/calc_go/internal/logging"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,6 +18,11 @@ type User struct {
 	Name           string
 	Password       string
 	OriginPassword string
+	Role           string
+	Disabled       bool
+	// TokensNotBefore - токены, выпущенные в эту секунду или раньше,
+	// недействительны.
+	TokensNotBefore time.Time
 }
 
 type Task struct {
@@ -33,203 +40,182 @@ type Expression struct {
 	Status     string
 	RootNodeID string
 	Result     float64
+	// Priority - PriorityLow, PriorityNormal или PriorityHigh; пустой - PriorityNormal.
+	Priority string
+	// Deadline - срок вычисления; нулевой, если срока нет.
+	Deadline time.Time
+	// TraceID и SpanID - трасса и спан HTTP запроса, создавшего выражение;
+	// пустые, если трассировки не было.
+	TraceID string
+	SpanID  string
 }
 
-var (
-	ctx context.Context
-	db  *sql.DB
-	mu  sync.Mutex
-	nu  sync.Mutex
-	eu  sync.Mutex
-)
-
-func (u User) ComparePassword(u2 User) error {
-	err := compare(u2.Password, u.OriginPassword)
-	if err != nil {
-		log.Println("DB: Auth fail")
-		return err
-	}
-	log.Println("DB: Auth success")
-	return nil
+type SQLiteStore struct {
+	ctx    context.Context
+	db     *sql.DB
+	logger *slog.Logger
+	// failpoint вызывается между шагами транзакций; используется в тестах,
+	// чтобы сымитировать сбой посреди операции.
+	failpoint func(step string) error
 }
 
-func createTables(ctx context.Context, db *sql.DB) error {
-	const userTable = `
-	CREATE TABLE IF NOT EXISTS users(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT UNIQUE,
-		password TEXT
-	);
-	`
+// LogValue скрывает пароль и его хеш, если пользователь попал в лог целиком.
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("id", u.ID), slog.String("name", u.Name),
+		slog.String("role", u.Role), slog.Bool("disabled", u.Disabled))
+}
 
-	if _, err := db.ExecContext(ctx, userTable); err != nil {
-		return err
-	}
+func (u User) ComparePassword(u2 User) error {
+	return compare(u2.Password, u.OriginPassword)
+}
 
-	const nodeTable = `
-	CREATE TABLE IF NOT EXISTS nodes(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		node_id TEXT,
-		expr_id TEXT,
-		type TEXT,
-		l_id TEXT,
-		r_id TEXT,
-		oper TEXT,
-		status TEXT,
-		result REAL           
-	);
-	`
-	if _, err := db.ExecContext(ctx, nodeTable); err != nil {
-		return err
-	}
+// execer - общее для *sql.DB и *sql.Tx, чтобы запросы можно было
+// выполнять как отдельно, так и внутри транзакции.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
 
-	const expressionTable = `
-	CREATE TABLE IF NOT EXISTS expressions(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		expr TEXT,
-		expr_id TEXT,
-		username TEXT,
-		status TEXT,
-		root_node_id INTEGER,
-		result REAL           
-	);
-	`
-	if _, err := db.ExecContext(ctx, expressionTable); err != nil {
-		return err
+func (s *SQLiteStore) InsertExpression(expr Expression) (int64, error) {
+	id, err := s.insertExpression(s.db, expr)
+	if err != nil {
+		s.logger.Error("DB: Error inserting expression", logging.ExprID, expr.ExprID, "error", err)
+		return 0, err
 	}
-
-	return nil
+	return id, nil
 }
 
-func InsertExpression(expr Expression) (int64, error) {
+func (s *SQLiteStore) insertExpression(ex execer, expr Expression) (int64, error) {
 	var q = `
-	INSERT INTO expressions (expr_id, expr, username, status, root_node_id, result) values ($1, $2, $3, $4, $5, $6)
+	INSERT INTO expressions (expr_id, expr, username, status, root_node_id, result, priority, deadline, trace_id, span_id)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	`
-	eu.Lock()
-	defer eu.Unlock()
-	result, err := db.ExecContext(ctx, q, expr.ExprID, expr.Expr, expr.Username, expr.Status, expr.RootNodeID, expr.Result)
-	if err != nil {
-		log.Printf("DB: Error inserting expression %s: %s", expr.ExprID, err)
-		return 0, nil
-	}
-
-	id, err := result.LastInsertId()
+	result, err := ex.ExecContext(s.ctx, q, expr.ExprID, expr.Expr, expr.Username, expr.Status, expr.RootNodeID, expr.Result,
+		priorityOrDefault(expr.Priority), unixOrZero(expr.Deadline), expr.TraceID, expr.SpanID)
 	if err != nil {
 		return 0, err
 	}
-	return id, nil
+	return result.LastInsertId()
 }
 
-func SetExpressionStatus(expr_id string, status string) error {
+func scanExpression(row interface{ Scan(...any) error }) (Expression, error) {
+	var (
+		expr     Expression
+		deadline int64
+	)
+	err := row.Scan(&expr.ExprID, &expr.Expr, &expr.Username, &expr.Status, &expr.RootNodeID, &expr.Result, &expr.Priority, &deadline,
+		&expr.TraceID, &expr.SpanID)
+	expr.Deadline = timeOrZero(deadline)
+	return expr, err
+}
+
+func (s *SQLiteStore) SetExpressionStatus(expr_id string, status string) error {
 	q := "UPDATE expressions SET status=$1 WHERE expr_id=$2"
 
-	eu.Lock()
-	defer eu.Unlock()
-	result, err := db.ExecContext(ctx, q, status, expr_id)
+	result, err := s.db.ExecContext(s.ctx, q, status, expr_id)
 
 	if err != nil {
-		log.Println("DB: Error updating expression: ", err)
+		s.logger.Error("DB: Error updating expression", logging.ExprID, expr_id, "error", err)
 		return err
 	}
 	num, _ := result.RowsAffected()
-	log.Println("DB: Expression status updated: ", num)
+	s.logger.Debug("DB: Expression status updated", logging.ExprID, expr_id, "status", status, "rows", num)
 	return nil
 }
 
-func SelectExpression(expr_id string) (Expression, error) {
+func (s *SQLiteStore) SelectExpression(expr_id string) (Expression, error) {
 	var (
 		expr Expression
 		err  error
 	)
 
-	eu.Lock()
-	defer eu.Unlock()
 	var q = `
-	SELECT expr_id, expr, username, status, root_node_id, result
+	SELECT expr_id, expr, username, status, root_node_id, result, priority, deadline, trace_id, span_id
 	FROM expressions 
 	WHERE expr_id = $1
 	`
-	err = db.QueryRowContext(ctx, q, expr_id).Scan(&expr.ExprID, &expr.Expr, &expr.Username, &expr.Status, &expr.RootNodeID, &expr.Result)
+	expr, err = scanExpression(s.db.QueryRowContext(s.ctx, q, expr_id))
 	if err != nil {
-		log.Printf("DB: SelectExpression error: %v", err)
+		s.logger.Debug("DB: SelectExpression error", logging.ExprID, expr_id, "error", err)
 	}
-	return expr, err
+	return expr, notFound(err)
 }
 
-func SelectExpressionsByUser(username string) ([]Expression, error) {
+func (s *SQLiteStore) SelectExpressionsByUser(username string) ([]Expression, error) {
 	var (
 		expr []Expression
 		err  error
 	)
 
-	eu.Lock()
-	defer eu.Unlock()
 	var q = `
-	SELECT expr_id, expr, username, status, root_node_id, result
+	SELECT expr_id, expr, username, status, root_node_id, result, priority, deadline, trace_id, span_id
 	FROM expressions 
 	WHERE username = $1
 	`
-	rows, err := db.QueryContext(ctx, q, username)
+	rows, err := s.db.QueryContext(s.ctx, q, username)
 	if err != nil {
-		log.Printf("DB: SelectExpressionsByUser error: %v", err)
+		s.logger.Error("DB: SelectExpressionsByUser error", logging.User, username, "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var ex Expression
-		if err := rows.Scan(&ex.ExprID, &ex.Expr, &ex.Username, &ex.Status, &ex.RootNodeID, &ex.Result); err != nil {
-			log.Printf("DB: SelectExpressionsByUser::Scan error: %v", err)
+		ex, err := scanExpression(rows)
+		if err != nil {
+			s.logger.Error("DB: SelectExpressionsByUser scan error", logging.User, username, "error", err)
 			return expr, err
 		}
 		expr = append(expr, ex)
 	}
 	if err = rows.Err(); err != nil {
-		log.Printf("DB: SelectExpressionsByUser::Err error: %v", err)
+		s.logger.Error("DB: SelectExpressionsByUser rows error", logging.User, username, "error", err)
 		return expr, err
 	}
 	return expr, nil
 }
 
-func SetExpressionResult(expr_id string, payload float64) error {
+func (s *SQLiteStore) SetExpressionResult(expr_id string, payload float64) error {
 	q := `UPDATE expressions SET status="done", result=$1 WHERE expr_id=$2`
-	nu.Lock()
-	defer nu.Unlock()
-	result, err := db.ExecContext(ctx, q, payload, expr_id)
+	result, err := s.db.ExecContext(s.ctx, q, payload, expr_id)
 
 	if err != nil {
-		log.Println("DB: Error updating expression: ", err)
+		s.logger.Error("DB: Error updating expression", logging.ExprID, expr_id, "error", err)
 		return err
 	}
 	num, _ := result.RowsAffected()
-	log.Println("DB: Expression updated: ", num)
+	s.logger.Debug("DB: Expression updated", logging.ExprID, expr_id, "rows", num)
 	return nil
 }
 
-func DeleteExpression(expr_id string) error {
+func (s *SQLiteStore) DeleteExpression(expr_id string) error {
 	q := "DELETE FROM expressions WHERE	expr_id=$1"
 
-	eu.Lock()
-	defer eu.Unlock()
-	result, err := db.ExecContext(ctx, q, expr_id)
+	result, err := s.db.ExecContext(s.ctx, q, expr_id)
 
 	if err != nil {
-		log.Println("DB: Error deleting Expression: ", err)
+		s.logger.Error("DB: Error deleting expression", logging.ExprID, expr_id, "error", err)
 		return err
 	}
 	num, _ := result.RowsAffected()
-	log.Println("DB: Expression deleted: ", num)
+	s.logger.Debug("DB: Expression deleted", logging.ExprID, expr_id, "rows", num)
 	return nil
 }
 
-func InsertUser(user *User) (int64, error) {
+func (s *SQLiteStore) InsertUser(user *User) (int64, error) {
 	var q = `
-	INSERT INTO users (name, password) values ($1, $2)
+	INSERT INTO users (name, password, role, disabled) values ($1, $2, $3, $4)
 	`
-	mu.Lock()
-	defer mu.Unlock()
-	result, err := db.ExecContext(ctx, q, user.Name, user.Password)
+	role := user.Role
+	if role == "" {
+		role = RoleUser
+	}
+	result, err := s.db.ExecContext(s.ctx, q, user.Name, user.Password, role, user.Disabled)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return 0, ErrUserExists
+	}
 	if err != nil {
-		return 0, nil
+		s.logger.Error("DB: Error inserting user", logging.User, user.Name, "error", err)
+		return 0, err
 	}
 
 	id, err := result.LastInsertId()
@@ -239,144 +225,315 @@ func InsertUser(user *User) (int64, error) {
 	return id, nil
 }
 
-func DeleteUser(user string) error {
+// DeleteUser удаляет пользователя вместе со всеми его данными.
+func (s *SQLiteStore) DeleteUser(user string) error {
 	q := "DELETE FROM users WHERE	name=$1"
 
-	mu.Lock()
-	defer mu.Unlock()
-	result, err := db.ExecContext(ctx, q, user)
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	result, err := tx.ExecContext(s.ctx, q, user)
+	if err == nil {
+		err = s.deleteUserData(tx, user)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		log.Println("DB: Error deleting user: ", err)
+		s.logger.Error("DB: Error deleting user", logging.User, user, "error", err)
 		return err
 	}
 	num, _ := result.RowsAffected()
-	log.Println("DB: User deleted: ", num)
+	s.logger.Debug("DB: User deleted", logging.User, user, "rows", num)
 	return nil
 }
 
-func InsertNodes(nodes []*calc.Node) (int64, error) {
-	q := "INSERT INTO nodes(node_id,	expr_id, type, l_id, r_id,	oper, status, result) VALUES "
+func (s *SQLiteStore) InsertNodes(nodes []*calc.Node) (int64, error) {
+	num, err := s.insertNodes(s.db, nodes)
+	if err != nil {
+		s.logger.Error("DB: Error inserting nodes", "error", err)
+		return 0, err
+	}
+	return num, nil
+}
+
+func (s *SQLiteStore) insertNodes(ex execer, nodes []*calc.Node) (int64, error) {
+	if len(nodes) == 0 {
+		return 0, nil
+	}
+	q := "INSERT INTO nodes(node_id,	expr_id, type, l_id, r_id,	oper, status, result, depth, parent_id, arg1, arg2, waiting) VALUES "
 	vals := []interface{}{}
 
+	ops := batchOperands(nodes)
 	for _, row := range nodes {
-		q += "(?, ?, ?, ?, ?, ?, ?, ?),"
-		vals = append(vals, row.ID, row.ExprID, row.Type, row.Left, row.Right, row.Operation, row.Status, row.Result)
+		o := ops[row.ID]
+		q += "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?),"
+		vals = append(vals, row.ID, row.ExprID, row.Type, row.Left, row.Right, row.Operation, row.Status, row.Result, row.Depth,
+			o.parent, o.args[0], o.args[1], o.waiting)
 	}
 	q = q[0 : len(q)-1]
-	stmt, _ := db.Prepare(q)
-
-	nu.Lock()
-	defer nu.Unlock()
-	result, err := stmt.ExecContext(ctx, vals...)
 
+	result, err := ex.ExecContext(s.ctx, q, vals...)
 	if err != nil {
-		log.Println("DB: Error inserting nodes: ", err)
 		return 0, err
 	}
-
 	return result.RowsAffected()
 }
 
-func DeleteNodes(expr_id string) error {
+// CreateExpression сохраняет выражение и все его узлы в одной транзакции.
+func (s *SQLiteStore) CreateExpression(expr Expression, nodes []*calc.Node) error {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := s.insertExpression(tx, expr); err != nil {
+		s.logger.Error("DB: Error inserting expression", logging.ExprID, expr.ExprID, "error", err)
+		return err
+	}
+	if err := s.fail("create_expression"); err != nil {
+		return err
+	}
+	if _, err := s.insertNodes(tx, nodes); err != nil {
+		s.logger.Error("DB: Error inserting nodes", logging.ExprID, expr.ExprID, "error", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// CompleteNode записывает результат узла в него и в операнды родителя.
+// Сам узел и его операнды больше не нужны и удаляются. Если узел корневой,
+// в той же транзакции выражение помечается выполненным, а его узлы удаляются.
+func (s *SQLiteStore) CompleteNode(node_id string, payload float64) (bool, error) {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	parent, operandIDs, err := s.setResult(tx, node_id, payload)
+	if err != nil {
+		return false, err
+	}
+	if err := s.fail("node_result"); err != nil {
+		return false, err
+	}
+
+	var expr_id, root_id string
+	q := `
+	SELECT E.expr_id, E.root_node_id
+	FROM nodes AS N
+	JOIN expressions AS E ON E.expr_id = N.expr_id
+	WHERE N.node_id = $1
+	`
+	if err := tx.QueryRowContext(s.ctx, q, node_id).Scan(&expr_id, &root_id); err != nil {
+		s.logger.Error("DB: CompleteNode error", logging.NodeID, node_id, "error", err)
+		return false, notFound(err)
+	}
+
+	finished := root_id == node_id
+	if finished {
+		_, err = tx.ExecContext(s.ctx, `UPDATE expressions SET status="done", result=$1 WHERE expr_id=$2`, payload, expr_id)
+		if err != nil {
+			s.logger.Error("DB: Error updating expression", logging.ExprID, expr_id, "error", err)
+			return false, err
+		}
+		if err := s.fail("expression_result"); err != nil {
+			return false, err
+		}
+		if _, err = tx.ExecContext(s.ctx, "DELETE FROM nodes WHERE expr_id=$1", expr_id); err != nil {
+			s.logger.Error("DB: Error deleting nodes", logging.ExprID, expr_id, "error", err)
+			return false, err
+		}
+	} else if parent != "" {
+		q = "DELETE FROM nodes WHERE node_id IN ($1, $2, $3)"
+		if _, err = tx.ExecContext(s.ctx, q, node_id, operandIDs[0], operandIDs[1]); err != nil {
+			s.logger.Error("DB: Error deleting nodes", logging.NodeID, node_id, "error", err)
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return finished, nil
+}
+
+// setResult отмечает узел вычисленным и, если результат новый, записывает
+// его в операнды родителя. Возвращает родителя и операнды узла.
+func (s *SQLiteStore) setResult(tx *sql.Tx, node_id string, payload float64) (string, [2]string, error) {
+	var (
+		parent, status string
+		operandIDs     [2]string
+	)
+	q := "SELECT parent_id, l_id, r_id, status FROM nodes WHERE node_id=$1"
+	err := tx.QueryRowContext(s.ctx, q, node_id).Scan(&parent, &operandIDs[0], &operandIDs[1], &status)
+	if err != nil {
+		return "", operandIDs, notFound(err)
+	}
+
+	if _, err := tx.ExecContext(s.ctx, `UPDATE nodes SET status="done", result=$1 WHERE node_id=$2`, payload, node_id); err != nil {
+		s.logger.Error("DB: Error updating node", logging.NodeID, node_id, "error", err)
+		return "", operandIDs, err
+	}
+	if parent == "" || status == "done" {
+		return parent, operandIDs, nil
+	}
+	q = `
+	UPDATE nodes SET
+		arg1 = CASE WHEN l_id = $1 THEN $2 ELSE arg1 END,
+		arg2 = CASE WHEN r_id = $1 THEN $2 ELSE arg2 END,
+		waiting = waiting - (l_id = $1) - (r_id = $1)
+	WHERE node_id = $3
+	`
+	if _, err := tx.ExecContext(s.ctx, q, node_id, payload, parent); err != nil {
+		s.logger.Error("DB: Error updating parent node", logging.NodeID, parent, "error", err)
+		return "", operandIDs, err
+	}
+	return parent, operandIDs, nil
+}
+
+// fail вызывает точку отказа, установленную в тестах.
+func (s *SQLiteStore) fail(step string) error {
+	if s.failpoint == nil {
+		return nil
+	}
+	return s.failpoint(step)
+}
+
+func (s *SQLiteStore) DeleteNodes(expr_id string) error {
 	q := "DELETE FROM nodes WHERE	expr_id=$1"
 
-	nu.Lock()
-	defer nu.Unlock()
-	result, err := db.ExecContext(ctx, q, expr_id)
+	result, err := s.db.ExecContext(s.ctx, q, expr_id)
 
 	if err != nil {
-		log.Println("DB: Error deleting nodes: ", err)
+		s.logger.Error("DB: Error deleting nodes", logging.ExprID, expr_id, "error", err)
 		return err
 	}
 	num, _ := result.RowsAffected()
-	log.Println("DB: Nodes deleted: ", num)
+	s.logger.Debug("DB: Nodes deleted", logging.ExprID, expr_id, "rows", num)
 	return nil
 }
 
-func SelectNode(id string) (calc.Node, error) {
+func (s *SQLiteStore) SelectNode(id string) (calc.Node, error) {
 	var (
 		node calc.Node
 		err  error
 	)
 
-	nu.Lock()
-	defer nu.Unlock()
 	var q = `
-	SELECT node_id, expr_id, type, l_id, r_id, oper, status, result 
+	SELECT node_id, expr_id, type, l_id, r_id, oper, status, result, depth
 	FROM nodes 
 	WHERE node_id = $1
 	`
-	err = db.QueryRowContext(ctx, q, id).Scan(&node.ID, &node.ExprID, &node.Type, &node.Left,
-		&node.Right, &node.Operation, &node.Status, &node.Result)
+	err = s.db.QueryRowContext(s.ctx, q, id).Scan(&node.ID, &node.ExprID, &node.Type, &node.Left,
+		&node.Right, &node.Operation, &node.Status, &node.Result, &node.Depth)
 	if err != nil {
-		log.Printf("DB: SelectNode error: %v", err)
+		s.logger.Debug("DB: SelectNode error", logging.NodeID, id, "error", err)
 	}
-	return node, err
+	return node, notFound(err)
 }
 
-func SelectNodeAsTask() (Task, error) {
+// readyTaskSelect выбирает узлы, оба операнда которых уже записаны в них.
+const readyTaskSelect = `
+	SELECT N.node_id, N.expr_id, N.oper, N.arg1, N.arg2
+	FROM nodes AS N
+	WHERE N.type = "operation" AND N.status = "pending" AND N.waiting = 0
+	`
+
+const readyTaskQuery = readyTaskSelect + "LIMIT 1"
+
+func (s *SQLiteStore) SelectNodeAsTask() (Task, error) {
 	var (
 		task Task
 		err  error
 	)
 
-	nu.Lock()
-	defer nu.Unlock()
+	err = s.db.QueryRowContext(s.ctx, readyTaskQuery).Scan(&task.ID, &task.ExprID, &task.Oper, &task.Arg1, &task.Arg2)
+	return task, notFound(err)
+}
 
-	var q = `
-	SELECT N.node_id, N.expr_id, N.oper, L.result AS arg1, R.result AS arg2 
-	FROM nodes AS N
-	JOIN nodes AS L ON N.l_id = L.node_id
-	JOIN nodes as R ON N.r_id = R.node_id
-	WHERE N.type = "operation" AND N.status = "pending" AND L.status = "done" AND R.status = "done"
-	LIMIT 1
-	`
-	err = db.QueryRowContext(ctx, q).Scan(&task.ID, &task.ExprID, &task.Oper, &task.Arg1, &task.Arg2)
-	return task, err
+func (s *SQLiteStore) ClaimTask() (Task, error) {
+	return s.claim(readyTaskQuery)
+}
+
+// claim выбирает готовый узел запросом q и в той же транзакции
+// переводит его в "in_progress".
+func (s *SQLiteStore) claim(q string, args ...any) (Task, error) {
+	var task Task
+
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return task, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(s.ctx, q, args...).Scan(&task.ID, &task.ExprID, &task.Oper, &task.Arg1, &task.Arg2)
+	if err != nil {
+		return Task{}, notFound(err)
+	}
+	q = `UPDATE nodes SET status="in_progress" WHERE node_id=$1 AND status="pending"`
+	if _, err = tx.ExecContext(s.ctx, q, task.ID); err != nil {
+		s.logger.Error("DB: Error claiming node", logging.NodeID, task.ID, "error", err)
+		return Task{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Task{}, err
+	}
+	return task, nil
 }
 
-func SetNodeStatus(node_id string, status string) (int64, error) {
+func (s *SQLiteStore) SetNodeStatus(node_id string, status string) (int64, error) {
 	q := "UPDATE nodes SET status=$1 WHERE node_id=$2"
 
-	nu.Lock()
-	defer nu.Unlock()
-	result, err := db.ExecContext(ctx, q, status, node_id)
+	result, err := s.db.ExecContext(s.ctx, q, status, node_id)
 
 	if err != nil {
-		log.Println("DB: Error updating node: ", err)
+		s.logger.Error("DB: Error updating node", logging.NodeID, node_id, "error", err)
 		return 0, err
 	}
 	return result.RowsAffected()
 }
 
-func SetNodeResult(node_id string, payload float64) error {
-	q := `UPDATE nodes SET status="done", result=$1 WHERE node_id=$2`
-	nu.Lock()
-	defer nu.Unlock()
-	result, err := db.ExecContext(ctx, q, payload, node_id)
-
+// SetNodeResult записывает результат узла в него и в операнды родителя.
+// В отличие от CompleteNode узел не удаляется.
+func (s *SQLiteStore) SetNodeResult(node_id string, payload float64) error {
+	tx, err := s.db.BeginTx(s.ctx, nil)
 	if err != nil {
-		log.Println("DB: Error updating node: ", err)
 		return err
 	}
-	num, _ := result.RowsAffected()
-	log.Println("DB: Node updated: ", num)
+	defer tx.Rollback()
+
+	if _, _, err := s.setResult(tx, node_id, payload); errors.Is(err, ErrNotFound) {
+		s.logger.Debug("DB: Node updated", logging.NodeID, node_id, "rows", 0)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.logger.Debug("DB: Node updated", logging.NodeID, node_id, "rows", 1)
 	return nil
 }
 
-func SelectUser(name string) (User, error) {
+func (s *SQLiteStore) SelectUser(name string) (User, error) {
 	var (
 		user User
 		err  error
 	)
 
-	mu.Lock()
-	defer mu.Unlock()
-
-	var q = "SELECT id, name, password FROM users WHERE name=$1"
-	err = db.QueryRowContext(ctx, q, name).Scan(&user.ID, &user.Name, &user.Password)
-	return user, err
+	var (
+		q         = "SELECT id, name, password, role, disabled, tokens_not_before FROM users WHERE name=$1"
+		notBefore int64
+	)
+	err = s.db.QueryRowContext(s.ctx, q, name).Scan(&user.ID, &user.Name, &user.Password, &user.Role, &user.Disabled, &notBefore)
+	user.TokensNotBefore = timeOrZero(notBefore)
+	return user, notFound(err)
 }
 
 func GenerateHash(s string) (string, error) {
@@ -395,27 +552,73 @@ func compare(hash string, s string) error {
 	return bcrypt.CompareHashAndPassword(existing, incoming)
 }
 
-func Init(db_file string) error {
-	ctx = context.TODO()
-	var err error
+// notFound приводит sql.ErrNoRows к общей для всех хранилищ ошибке.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DefaultFile - файл базы оркестратора по умолчанию.
+const DefaultFile = "data/store.db"
+
+// Option задаёт необязательный параметр SQLiteStore.
+type Option func(*SQLiteStore)
 
-	db, err = sql.Open("sqlite3", db_file)
+// WithLogger задаёт логгер хранилища; по-умолчанию slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *SQLiteStore) { s.logger = logger }
+}
+
+// OpenSQLite открывает базу и применяет к ней недостающие миграции.
+func OpenSQLite(db_file string, opts ...Option) (*SQLiteStore, error) {
+	s, err := ConnectSQLite(db_file, opts...)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if _, err = s.Migrate(); err != nil {
+		s.db.Close()
+		return nil, err
 	}
+	return s, nil
+}
+
+// ConnectSQLite открывает базу без применения миграций.
+func ConnectSQLite(db_file string, opts ...Option) (*SQLiteStore, error) {
+	s := &SQLiteStore{ctx: context.TODO(), logger: slog.Default()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	var err error
 
-	err = db.PingContext(ctx)
+	s.db, err = sql.Open("sqlite3", db_file)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	// Одно соединение: иначе каждая сессия ":memory:" получит свою базу,
+	// к тому же так SQLite сама упорядочивает запись без отдельных мьютексов.
+	s.db.SetMaxOpenConns(1)
 
-	if err = createTables(ctx, db); err != nil {
-		return err
+	err = s.db.PingContext(s.ctx)
+	if err != nil {
+		s.db.Close()
+		return nil, err
 	}
+	return s, nil
+}
 
-	return nil
+// Ping проверяет, что соединение с базой данных работает.
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Stats возвращает статистику пула соединений, в том числе ожидание
+// единственного соединения.
+func (s *SQLiteStore) Stats() sql.DBStats {
+	return s.db.Stats()
 }
 
-func Stop() {
-	db.Close()
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
 }
@@ -1,33 +1,19 @@
 package db
 
 import (
-	"context"
-	"database/sql"
 	"fmt"
 	"os"
 	"testing"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/saykoooo/calc_go/internal/calc"
 )
 
-var testDB *sql.DB
+var store *SQLiteStore
 
 func setupTestDB() error {
 	var err error
-	testDB, err = sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		return err
-	}
-
-	ctx = context.TODO()
-	db = testDB
-
-	err = createTables(ctx, testDB)
-	if err != nil {
-		return err
-	}
-	return nil
+	store, err = OpenSQLite(":memory:")
+	return err
 }
 
 func TestMain(m *testing.M) {
@@ -37,7 +23,7 @@ func TestMain(m *testing.M) {
 
 	code := m.Run()
 
-	db.Close()
+	store.Close()
 	os.Exit(code)
 }
 
@@ -53,7 +39,7 @@ func TestUserOperations(t *testing.T) {
 	}
 	user.Password = hash
 
-	id, err := InsertUser(user)
+	id, err := store.InsertUser(user)
 	if err != nil {
 		t.Fatal("Failed to insert user:", err)
 	}
@@ -62,7 +48,7 @@ func TestUserOperations(t *testing.T) {
 		t.Error("Expected positive user ID")
 	}
 
-	storedUser, err := SelectUser(user.Name)
+	storedUser, err := store.SelectUser(user.Name)
 	fmt.Println("storedUser:", storedUser)
 	if err != nil {
 		t.Fatal("Failed to select user:", err)
@@ -87,7 +73,7 @@ func TestExpressionOperations(t *testing.T) {
 		Result:     0,
 	}
 
-	id, err := InsertExpression(expr)
+	id, err := store.InsertExpression(expr)
 	if err != nil {
 		t.Fatal("Failed to insert expression:", err)
 	}
@@ -96,7 +82,7 @@ func TestExpressionOperations(t *testing.T) {
 		t.Error("Expected positive expression ID")
 	}
 
-	storedExpr, err := SelectExpression(expr.ExprID)
+	storedExpr, err := store.SelectExpression(expr.ExprID)
 	if err != nil {
 		t.Fatal("Failed to select expression:", err)
 	}
@@ -105,17 +91,17 @@ func TestExpressionOperations(t *testing.T) {
 		t.Errorf("Expected username %s, got %s", expr.Username, storedExpr.Username)
 	}
 
-	err = SetExpressionStatus(expr.ExprID, "done")
+	err = store.SetExpressionStatus(expr.ExprID, "done")
 	if err != nil {
 		t.Error("Failed to set expression status:", err)
 	}
 
-	err = SetExpressionResult(expr.ExprID, 42.5)
+	err = store.SetExpressionResult(expr.ExprID, 42.5)
 	if err != nil {
 		t.Error("Failed to set expression result:", err)
 	}
 
-	updatedExpr, err := SelectExpression(expr.ExprID)
+	updatedExpr, err := store.SelectExpression(expr.ExprID)
 	if err != nil {
 		t.Fatal("Failed to select updated expression:", err)
 	}
@@ -149,7 +135,7 @@ func TestNodeOperations(t *testing.T) {
 		},
 	}
 
-	count, err := InsertNodes(nodes)
+	count, err := store.InsertNodes(nodes)
 	if err != nil {
 		t.Fatal("Failed to insert nodes:", err)
 	}
@@ -158,7 +144,7 @@ func TestNodeOperations(t *testing.T) {
 		t.Errorf("Expected %d nodes inserted, got %d", len(nodes), count)
 	}
 
-	storedNode, err := SelectNode("node1")
+	storedNode, err := store.SelectNode("node1")
 	if err != nil {
 		t.Fatal("Failed to select node:", err)
 	}
@@ -167,23 +153,23 @@ func TestNodeOperations(t *testing.T) {
 		t.Errorf("Expected result 2, got %f", storedNode.Result)
 	}
 
-	_, err = SetNodeStatus("node2", "in_progress")
+	_, err = store.SetNodeStatus("node2", "in_progress")
 	if err != nil {
 		t.Error("Failed to set node status:", err)
 	}
 
-	err = SetNodeResult("node2", 4)
+	err = store.SetNodeResult("node2", 4)
 	if err != nil {
 		t.Error("Failed to set node result:", err)
 	}
 
-	task, err := SelectNodeAsTask()
+	task, err := store.SelectNodeAsTask()
 	if err == nil {
 		t.Error("Expected no available tasks")
 		fmt.Println("Got task:", task)
 	}
 
-	err = DeleteNodes("expr1")
+	err = store.DeleteNodes("expr1")
 	if err != nil {
 		t.Error("Failed to delete nodes:", err)
 	}
@@ -191,13 +177,13 @@ func TestNodeOperations(t *testing.T) {
 
 func TestErrorHandling(t *testing.T) {
 	// 404 expr
-	_, err := SelectExpression("nonexistent")
+	_, err := store.SelectExpression("nonexistent")
 	if err == nil {
 		t.Error("Expected error for nonexistent expression")
 	}
 
 	// 404 node
-	num, err := SetNodeStatus("nonexistent", "done")
+	num, err := store.SetNodeStatus("nonexistent", "done")
 	if err != nil && num != 0 {
 		t.Error("Expected error for nonexistent node")
 	}
@@ -210,9 +196,22 @@ func TestErrorHandling(t *testing.T) {
 	hash, _ := GenerateHash(user.OriginPassword)
 	user.Password = hash
 
-	_, err = InsertUser(user)   // Первый раз
-	num, err = InsertUser(user) // Второй раз
+	_, err = store.InsertUser(user)   // Первый раз
+	num, err = store.InsertUser(user) // Второй раз
 	if err == nil && num != 0 {
 		t.Error("Expected error for duplicate user")
 	}
 }
+
+func TestInsertExpressionError(t *testing.T) {
+	closed, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	closed.Close()
+
+	id, err := closed.InsertExpression(Expression{ExprID: "lost", Username: "u", Status: "processing"})
+	if err == nil || id != 0 {
+		t.Errorf("Expected error from closed database, got id %d, err %v", id, err)
+	}
+}
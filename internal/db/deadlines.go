@@ -0,0 +1,102 @@
+package db
+
+import (
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/logging"
+)
+
+// Приоритеты выражений.
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// StatusExpired - выражение не вычислено к сроку.
+const StatusExpired = "expired"
+
+func priorityOrDefault(priority string) string {
+	if priority == "" {
+		return PriorityNormal
+	}
+	return priority
+}
+
+// ExpireExpressions помечает "expired" выражения в обработке, срок которых
+// наступил к now, и удаляет их узлы. Возвращает их идентификаторы и
+// ближайший срок среди оставшихся (нулевой, если сроков больше нет).
+func (s *SQLiteStore) ExpireExpressions(now time.Time) ([]string, time.Time, error) {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	q := `SELECT expr_id FROM expressions WHERE status="processing" AND deadline > 0 AND deadline <= $1`
+	rows, err := tx.QueryContext(s.ctx, q, now.Unix())
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var expired []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, time.Time{}, err
+		}
+		expired = append(expired, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	for _, id := range expired {
+		if _, err := tx.ExecContext(s.ctx, "UPDATE expressions SET status=$1 WHERE expr_id=$2", StatusExpired, id); err != nil {
+			s.logger.Error("DB: Error expiring expression", logging.ExprID, id, "error", err)
+			return nil, time.Time{}, err
+		}
+		if _, err := tx.ExecContext(s.ctx, "DELETE FROM nodes WHERE expr_id=$1", id); err != nil {
+			s.logger.Error("DB: Error deleting nodes", logging.ExprID, id, "error", err)
+			return nil, time.Time{}, err
+		}
+	}
+
+	var next int64
+	q = `SELECT COALESCE(MIN(deadline), 0) FROM expressions WHERE status="processing" AND deadline > 0`
+	if err := tx.QueryRowContext(s.ctx, q).Scan(&next); err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(expired) > 0 {
+		s.logger.Debug("DB: Expressions expired", "count", len(expired))
+	}
+	return expired, timeOrZero(next), nil
+}
+
+func (s *MemoryStore) ExpireExpressions(now time.Time) ([]string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		expired []string
+		next    time.Time
+	)
+	for _, id := range s.exprOrder {
+		expr := s.exprs[id]
+		if expr.Status != "processing" || expr.Deadline.IsZero() {
+			continue
+		}
+		if !expr.Deadline.After(now) {
+			expr.Status = StatusExpired
+			s.deleteNodes(id)
+			expired = append(expired, id)
+		} else if next.IsZero() || expr.Deadline.Before(next) {
+			next = expr.Deadline
+		}
+	}
+	return expired, next, nil
+}
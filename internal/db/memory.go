@@ -0,0 +1,370 @@
+package db
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+)
+
+// MemoryStore - хранилище в памяти для тестов и временных развёртываний.
+// Повторяет поведение SQLiteStore, но ничего не сохраняет между запусками.
+type MemoryStore struct {
+	mu         sync.Mutex
+	lastUserID int64
+	lastExprID int64
+	users      map[string]User
+	exprs      map[string]*Expression
+	exprOrder  []string
+	nodes      map[string]*calc.Node
+	// nodeOrder - ID узлов в порядке добавления; nodeElems и exprNodes
+	// позволяют удалять узлы, не просматривая весь список.
+	nodeOrder *list.List
+	nodeElems map[string]*list.Element
+	exprNodes map[string]map[string]struct{}
+	operands  map[string]*operands
+
+	refreshTokens map[string]*RefreshToken
+	revokedTokens map[string]time.Time
+
+	lastAPIKeyID int64
+	apiKeys      []*APIKey
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[string]User),
+		exprs: make(map[string]*Expression),
+		nodes: make(map[string]*calc.Node),
+
+		nodeOrder: list.New(),
+		nodeElems: make(map[string]*list.Element),
+		exprNodes: make(map[string]map[string]struct{}),
+		operands:  make(map[string]*operands),
+
+		refreshTokens: make(map[string]*RefreshToken),
+		revokedTokens: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) InsertUser(user *User) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.Name]; ok {
+		return 0, ErrUserExists
+	}
+	s.lastUserID++
+	role := user.Role
+	if role == "" {
+		role = RoleUser
+	}
+	s.users[user.Name] = User{ID: s.lastUserID, Name: user.Name, Password: user.Password, Role: role, Disabled: user.Disabled}
+	return s.lastUserID, nil
+}
+
+func (s *MemoryStore) SelectUser(name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) DeleteUser(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, name)
+	s.deleteUserData(name)
+	return nil
+}
+
+func (s *MemoryStore) CreateExpression(expr Expression, nodes []*calc.Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertExpression(expr)
+	s.insertNodes(nodes)
+	return nil
+}
+
+func (s *MemoryStore) CompleteNode(node_id string, payload float64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[node_id]
+	if !ok {
+		return false, ErrNotFound
+	}
+	expr, ok := s.exprs[node.ExprID]
+	if !ok {
+		return false, ErrNotFound
+	}
+
+	parent := s.setResult(node, payload)
+	if expr.RootNodeID != node_id {
+		if parent != "" {
+			s.deleteNodeIDs(node_id, node.Left, node.Right)
+		}
+		return false, nil
+	}
+	expr.Status = "done"
+	expr.Result = payload
+	s.deleteNodes(expr.ExprID)
+	return true, nil
+}
+
+func (s *MemoryStore) InsertExpression(expr Expression) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertExpression(expr), nil
+}
+
+func (s *MemoryStore) insertExpression(expr Expression) int64 {
+	expr.Priority = priorityOrDefault(expr.Priority)
+	if !expr.Deadline.IsZero() {
+		expr.Deadline = timeOrZero(expr.Deadline.Unix())
+	}
+	if _, ok := s.exprs[expr.ExprID]; !ok {
+		s.exprOrder = append(s.exprOrder, expr.ExprID)
+	}
+	s.exprs[expr.ExprID] = &expr
+	s.lastExprID++
+	return s.lastExprID
+}
+
+func (s *MemoryStore) SelectExpression(expr_id string) (Expression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expr, ok := s.exprs[expr_id]
+	if !ok {
+		return Expression{}, ErrNotFound
+	}
+	return *expr, nil
+}
+
+func (s *MemoryStore) SelectExpressionsByUser(username string) ([]Expression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var exprs []Expression
+	for _, id := range s.exprOrder {
+		if expr := s.exprs[id]; expr.Username == username {
+			exprs = append(exprs, *expr)
+		}
+	}
+	return exprs, nil
+}
+
+func (s *MemoryStore) SetExpressionStatus(expr_id string, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expr, ok := s.exprs[expr_id]; ok {
+		expr.Status = status
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetExpressionResult(expr_id string, payload float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expr, ok := s.exprs[expr_id]; ok {
+		expr.Status = "done"
+		expr.Result = payload
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpression(expr_id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.exprs[expr_id]; ok {
+		delete(s.exprs, expr_id)
+		s.exprOrder = slices.DeleteFunc(s.exprOrder, func(id string) bool { return id == expr_id })
+	}
+	return nil
+}
+
+// deleteExpression удаляет выражение вместе с его узлами.
+func (s *MemoryStore) deleteExpression(expr_id string) {
+	s.deleteNodes(expr_id)
+	delete(s.exprs, expr_id)
+	s.exprOrder = slices.DeleteFunc(s.exprOrder, func(id string) bool { return id == expr_id })
+}
+
+func (s *MemoryStore) InsertNodes(nodes []*calc.Node) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertNodes(nodes), nil
+}
+
+func (s *MemoryStore) insertNodes(nodes []*calc.Node) int64 {
+	ops := batchOperands(nodes)
+	for _, node := range nodes {
+		n := *node
+		if old, ok := s.nodes[n.ID]; ok {
+			delete(s.exprNodes[old.ExprID], n.ID)
+		} else {
+			s.nodeElems[n.ID] = s.nodeOrder.PushBack(n.ID)
+		}
+		if s.exprNodes[n.ExprID] == nil {
+			s.exprNodes[n.ExprID] = make(map[string]struct{})
+		}
+		s.exprNodes[n.ExprID][n.ID] = struct{}{}
+		s.nodes[n.ID] = &n
+		s.operands[n.ID] = ops[n.ID]
+	}
+	return int64(len(nodes))
+}
+
+func (s *MemoryStore) SelectNode(id string) (calc.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[id]
+	if !ok {
+		return calc.Node{}, ErrNotFound
+	}
+	return *node, nil
+}
+
+func (s *MemoryStore) SetNodeStatus(node_id string, status string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[node_id]
+	if !ok {
+		return 0, nil
+	}
+	node.Status = status
+	return 1, nil
+}
+
+func (s *MemoryStore) SetNodeResult(node_id string, payload float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if node, ok := s.nodes[node_id]; ok {
+		s.setResult(node, payload)
+	}
+	return nil
+}
+
+// setResult отмечает узел вычисленным и, если результат новый, записывает
+// его в операнды родителя. Возвращает родителя узла.
+func (s *MemoryStore) setResult(node *calc.Node, payload float64) string {
+	computed := node.Status == "done"
+	node.Status = "done"
+	node.Result = payload
+
+	o := s.operands[node.ID]
+	parent, ok := s.nodes[o.parent]
+	if computed || !ok {
+		return o.parent
+	}
+	po := s.operands[parent.ID]
+	for i, child := range []string{parent.Left, parent.Right} {
+		if child == node.ID {
+			po.args[i] = payload
+			po.waiting--
+		}
+	}
+	return o.parent
+}
+
+func (s *MemoryStore) DeleteNodes(expr_id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteNodes(expr_id)
+	return nil
+}
+
+func (s *MemoryStore) deleteNodes(expr_id string) {
+	for id := range s.exprNodes[expr_id] {
+		s.deleteNodeIDs(id)
+	}
+}
+
+// deleteNodeIDs удаляет узлы ids, которые есть в хранилище.
+func (s *MemoryStore) deleteNodeIDs(ids ...string) {
+	for _, id := range ids {
+		node, ok := s.nodes[id]
+		if !ok {
+			continue
+		}
+		delete(s.nodes, id)
+		delete(s.operands, id)
+		s.nodeOrder.Remove(s.nodeElems[id])
+		delete(s.nodeElems, id)
+		if exprNodes := s.exprNodes[node.ExprID]; len(exprNodes) > 1 {
+			delete(exprNodes, id)
+		} else {
+			delete(s.exprNodes, node.ExprID)
+		}
+	}
+}
+
+func (s *MemoryStore) SelectNodeAsTask() (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := s.readyNode()
+	if node == nil {
+		return Task{}, ErrNotFound
+	}
+	return s.taskFromNode(node), nil
+}
+
+func (s *MemoryStore) ClaimTask() (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := s.readyNode()
+	if node == nil {
+		return Task{}, ErrNotFound
+	}
+	node.Status = "in_progress"
+	return s.taskFromNode(node), nil
+}
+
+func (s *MemoryStore) readyNode() *calc.Node {
+	for e := s.nodeOrder.Front(); e != nil; e = e.Next() {
+		if node := s.nodes[e.Value.(string)]; s.isReady(node) {
+			return node
+		}
+	}
+	return nil
+}
+
+// isReady сообщает, что узел ждёт вычисления и оба его операнда известны.
+func (s *MemoryStore) isReady(node *calc.Node) bool {
+	return node.Type == "operation" && node.Status == "pending" && s.operands[node.ID].waiting == 0
+}
+
+func (s *MemoryStore) taskFromNode(node *calc.Node) Task {
+	o := s.operands[node.ID]
+	return Task{
+		ID:     node.ID,
+		ExprID: node.ExprID,
+		Oper:   node.Operation,
+		Arg1:   o.args[0],
+		Arg2:   o.args[1],
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration - один шаг схемы. Файлы называются NNNN_описание.sql
+// и применяются по возрастанию номера.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationState показывает, применена ли миграция к базе.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		num, _, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+		body, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+func (s *SQLiteStore) ensureMigrationsTable() error {
+	const q = `
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER PRIMARY KEY,
+		name TEXT,
+		applied_at TEXT
+	);
+	`
+	_, err := s.db.ExecContext(s.ctx, q)
+	return err
+}
+
+// MigrationStatus возвращает все известные миграции с отметкой о применении.
+func (s *SQLiteStore) MigrationStatus() ([]MigrationState, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(s.ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version int
+			at      string
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version], _ = time.Parse(time.RFC3339, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		states = append(states, MigrationState{Migration: m, Applied: ok, AppliedAt: at})
+	}
+	return states, nil
+}
+
+// SchemaVersion возвращает номер последней применённой миграции.
+func (s *SQLiteStore) SchemaVersion() (int, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err := s.db.QueryRowContext(s.ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// Migrate применяет все ещё не применённые миграции, каждую в своей транзакции.
+func (s *SQLiteStore) Migrate() ([]Migration, error) {
+	states, err := s.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, st := range states {
+		if st.Applied {
+			continue
+		}
+		if err := s.applyMigration(st.Migration); err != nil {
+			return done, fmt.Errorf("migration %s: %w", st.Name, err)
+		}
+		s.logger.Info("DB: Applied migration", "migration", st.Name)
+		done = append(done, st.Migration)
+	}
+	return done, nil
+}
+
+func (s *SQLiteStore) applyMigration(m Migration) error {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(s.ctx, m.SQL); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(s.ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+		m.Version, m.Name, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
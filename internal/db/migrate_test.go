@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d (%s)", i, i+1, m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrate_FreshDatabase(t *testing.T) {
+	s, err := ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("ConnectSQLite: %v", err)
+	}
+	defer s.Close()
+
+	applied, err := s.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	all, _ := loadMigrations()
+	if len(applied) != len(all) {
+		t.Errorf("Expected %d applied migrations, got %d", len(all), len(applied))
+	}
+
+	applied, err = s.Migrate()
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Second Migrate must be a no-op, got %d applied, err %v", len(applied), err)
+	}
+
+	version, err := s.SchemaVersion()
+	if err != nil || version != all[len(all)-1].Version {
+		t.Errorf("Expected schema version %d, got %d (err %v)", all[len(all)-1].Version, version, err)
+	}
+
+	states, _ := s.MigrationStatus()
+	for _, st := range states {
+		if !st.Applied || st.AppliedAt.IsZero() {
+			t.Errorf("Expected %s to be applied, got %+v", st.Name, st)
+		}
+	}
+}
+
+func TestMigrate_LegacyDatabase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.db")
+
+	// База в том виде, в каком её создавал createTables до появления миграций.
+	legacy, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE users(id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE, password TEXT);
+	CREATE TABLE nodes(id INTEGER PRIMARY KEY AUTOINCREMENT, node_id TEXT, expr_id TEXT, type TEXT,
+		l_id TEXT, r_id TEXT, oper TEXT, status TEXT, result REAL);
+	CREATE TABLE expressions(id INTEGER PRIMARY KEY AUTOINCREMENT, expr TEXT, expr_id TEXT,
+		username TEXT, status TEXT, root_node_id INTEGER, result REAL);
+	INSERT INTO users (name, password) VALUES ('old', 'hash');
+	`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	s, err := ConnectSQLite(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	states, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	for _, st := range states {
+		if st.Applied {
+			t.Errorf("Expected %s to be pending on legacy database", st.Name)
+		}
+	}
+
+	if _, err := s.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if user, err := s.SelectUser("old"); err != nil || user.Password != "hash" {
+		t.Errorf("Legacy data lost after migration: %+v %v", user, err)
+	}
+
+	for _, idx := range []string{"idx_nodes_node_id", "idx_nodes_expr_id", "idx_expressions_expr_id", "idx_expressions_username"} {
+		var name string
+		err := s.db.QueryRow("SELECT name FROM sqlite_master WHERE type='index' AND name=$1", idx).Scan(&name)
+		if err != nil {
+			t.Errorf("Index %s not created: %v", idx, err)
+		}
+	}
+}
+
+func TestMigrate_NodeOperandsBackfilled(t *testing.T) {
+	s, err := ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Схема до 0009: операнды читались из строк дочерних узлов.
+	s.ensureMigrationsTable()
+	migrations, _ := loadMigrations()
+	for _, m := range migrations {
+		if m.Version >= 9 {
+			break
+		}
+		if err := s.applyMigration(m); err != nil {
+			t.Fatalf("applyMigration(%s): %v", m.Name, err)
+		}
+	}
+	_, err = s.db.Exec(`
+	INSERT INTO nodes (node_id, expr_id, type, l_id, r_id, oper, status, result) VALUES
+		('n1', 'x', 'number', '', '', '', 'done', 2),
+		('n2', 'x', 'number', '', '', '', 'done', 3),
+		('add', 'x', 'operation', 'n1', 'n2', '+', 'done', 5),
+		('n3', 'x', 'number', '', '', '', 'done', 4),
+		('mul', 'x', 'operation', 'add', 'n3', '*', 'pending', 0),
+		('m1', 'y', 'number', '', '', '', 'done', 1),
+		('m2', 'y', 'number', '', '', '', 'done', 1),
+		('sub', 'y', 'operation', 'm1', 'm2', '-', 'in_progress', 0),
+		('m3', 'y', 'number', '', '', '', 'done', 7),
+		('div', 'y', 'operation', 'sub', 'm3', '/', 'pending', 0);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert legacy nodes: %v", err)
+	}
+
+	if _, err := s.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	task, err := s.ClaimTask()
+	if err != nil || task.ID != "mul" || task.Arg1 != 5 || task.Arg2 != 4 {
+		t.Fatalf("Expected mul with backfilled operands, got %+v %v", task, err)
+	}
+	if _, err := s.ClaimTask(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected div to wait for sub, got %v", err)
+	}
+
+	s.SetNodeResult("sub", 0)
+	task, err = s.ClaimTask()
+	if err != nil || task.ID != "div" || task.Arg1 != 0 || task.Arg2 != 7 {
+		t.Errorf("Expected div after sub completed, got %+v %v", task, err)
+	}
+}
@@ -0,0 +1,28 @@
+-- Исходная схема. IF NOT EXISTS позволяет подхватить базы, созданные до миграций.
+CREATE TABLE IF NOT EXISTS users(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE,
+	password TEXT
+);
+
+CREATE TABLE IF NOT EXISTS nodes(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	node_id TEXT,
+	expr_id TEXT,
+	type TEXT,
+	l_id TEXT,
+	r_id TEXT,
+	oper TEXT,
+	status TEXT,
+	result REAL
+);
+
+CREATE TABLE IF NOT EXISTS expressions(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	expr TEXT,
+	expr_id TEXT,
+	username TEXT,
+	status TEXT,
+	root_node_id INTEGER,
+	result REAL
+);
@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS idx_nodes_node_id ON nodes(node_id);
+CREATE INDEX IF NOT EXISTS idx_nodes_expr_id ON nodes(expr_id);
+CREATE INDEX IF NOT EXISTS idx_expressions_expr_id ON expressions(expr_id);
+CREATE INDEX IF NOT EXISTS idx_expressions_username ON expressions(username);
@@ -0,0 +1,18 @@
+-- Одноразовые refresh токены. Хранится только SHA-256 от токена.
+-- Все токены одной цепочки ротации имеют общий family_id.
+CREATE TABLE refresh_tokens(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token_hash TEXT NOT NULL UNIQUE,
+	family_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used INTEGER NOT NULL DEFAULT 0,
+	revoked INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+
+-- Отозванные access токены (jti) до истечения их срока действия.
+CREATE TABLE revoked_tokens(
+	jti TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,15 @@
+-- Персональные API ключи. Ключ имеет вид calc_<prefix>_<secret>:
+-- по prefix запись находится, а хранится только SHA-256 от всего ключа.
+-- Нулевые expires_at и last_used_at означают "без срока" и "не использовался".
+CREATE TABLE api_keys(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	prefix TEXT NOT NULL UNIQUE,
+	key_hash TEXT NOT NULL,
+	username TEXT NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	scopes TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0,
+	last_used_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_api_keys_username ON api_keys(username);
@@ -0,0 +1,3 @@
+-- Токены, выпущенные раньше этого момента (unix), недействительны.
+-- Сдвигается при смене пароля.
+ALTER TABLE users ADD COLUMN tokens_not_before INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Глубина узла (число операций до корня) для планировщика.
+ALTER TABLE nodes ADD COLUMN depth INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- Приоритет выражения и срок (unix), после которого оно помечается "expired".
+ALTER TABLE expressions ADD COLUMN priority TEXT NOT NULL DEFAULT 'normal';
+ALTER TABLE expressions ADD COLUMN deadline INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_expressions_deadline ON expressions(status, deadline);
@@ -0,0 +1,17 @@
+-- Результат узла записывается в операнды родителя, поэтому задача
+-- строится по одной строке, а вычисленные узлы можно удалять сразу.
+-- waiting - число ещё не вычисленных операндов.
+ALTER TABLE nodes ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE nodes ADD COLUMN arg1 REAL NOT NULL DEFAULT 0;
+ALTER TABLE nodes ADD COLUMN arg2 REAL NOT NULL DEFAULT 0;
+ALTER TABLE nodes ADD COLUMN waiting INTEGER NOT NULL DEFAULT 0;
+
+UPDATE nodes SET parent_id = P.node_id FROM nodes AS P WHERE P.type = 'operation' AND P.l_id = nodes.node_id;
+UPDATE nodes SET parent_id = P.node_id FROM nodes AS P WHERE P.type = 'operation' AND P.r_id = nodes.node_id;
+
+UPDATE nodes SET
+	arg1 = COALESCE((SELECT L.result FROM nodes AS L WHERE L.node_id = nodes.l_id AND L.status = 'done'), 0),
+	arg2 = COALESCE((SELECT R.result FROM nodes AS R WHERE R.node_id = nodes.r_id AND R.status = 'done'), 0),
+	waiting = NOT EXISTS (SELECT 1 FROM nodes AS L WHERE L.node_id = nodes.l_id AND L.status = 'done')
+		+ NOT EXISTS (SELECT 1 FROM nodes AS R WHERE R.node_id = nodes.r_id AND R.status = 'done')
+WHERE type = 'operation';
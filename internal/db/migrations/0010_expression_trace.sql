@@ -0,0 +1,3 @@
+-- Контекст трассировки запроса, создавшего выражение: trace ID и спан запроса (hex).
+ALTER TABLE expressions ADD COLUMN trace_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE expressions ADD COLUMN span_id TEXT NOT NULL DEFAULT '';
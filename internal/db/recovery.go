@@ -0,0 +1,127 @@
+package db
+
+// RecoveryReport описывает, что было исправлено при восстановлении после перезапуска.
+type RecoveryReport struct {
+	NodesReset int64
+	Finished   []string
+	Failed     []string
+}
+
+// Recover согласует состояние после аварийной остановки оркестратора:
+// узлы "in_progress" возвращаются в "pending", выражения с вычисленным
+// корнем получают результат, а выражения без узлов помечаются "failed".
+func (s *SQLiteStore) Recover() (RecoveryReport, error) {
+	var report RecoveryReport
+
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(s.ctx, `UPDATE nodes SET status="pending" WHERE status="in_progress"`)
+	if err != nil {
+		return report, err
+	}
+	report.NodesReset, _ = result.RowsAffected()
+
+	q := `
+	SELECT E.expr_id, N.result
+	FROM expressions AS E
+	JOIN nodes AS N ON N.node_id = E.root_node_id AND N.expr_id = E.expr_id
+	WHERE E.status = "processing" AND N.status = "done"
+	`
+	rows, err := tx.QueryContext(s.ctx, q)
+	if err != nil {
+		return report, err
+	}
+	results := make(map[string]float64)
+	for rows.Next() {
+		var (
+			id  string
+			res float64
+		)
+		if err := rows.Scan(&id, &res); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.Finished = append(report.Finished, id)
+		results[id] = res
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	for _, id := range report.Finished {
+		if _, err := tx.ExecContext(s.ctx, `UPDATE expressions SET status="done", result=$1 WHERE expr_id=$2`, results[id], id); err != nil {
+			return report, err
+		}
+		if _, err := tx.ExecContext(s.ctx, "DELETE FROM nodes WHERE expr_id=$1", id); err != nil {
+			return report, err
+		}
+	}
+
+	q = `
+	SELECT expr_id FROM expressions AS E
+	WHERE E.status = "processing" AND NOT EXISTS (SELECT 1 FROM nodes AS N WHERE N.expr_id = E.expr_id)
+	`
+	rows, err = tx.QueryContext(s.ctx, q)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.Failed = append(report.Failed, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	for _, id := range report.Failed {
+		if _, err := tx.ExecContext(s.ctx, `UPDATE expressions SET status="failed" WHERE expr_id=$1`, id); err != nil {
+			return report, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return RecoveryReport{}, err
+	}
+	return report, nil
+}
+
+func (s *MemoryStore) Recover() (RecoveryReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var report RecoveryReport
+	hasNodes := make(map[string]bool)
+	for e := s.nodeOrder.Front(); e != nil; e = e.Next() {
+		node := s.nodes[e.Value.(string)]
+		if node.Status == "in_progress" {
+			node.Status = "pending"
+			report.NodesReset++
+		}
+		hasNodes[node.ExprID] = true
+	}
+
+	for _, id := range s.exprOrder {
+		expr := s.exprs[id]
+		if expr.Status != "processing" {
+			continue
+		}
+		if root, ok := s.nodes[expr.RootNodeID]; ok && root.ExprID == id && root.Status == "done" {
+			expr.Status = "done"
+			expr.Result = root.Result
+			s.deleteNodes(id)
+			report.Finished = append(report.Finished, id)
+		} else if !hasNodes[id] {
+			expr.Status = "failed"
+			report.Failed = append(report.Failed, id)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+)
+
+var (
+	// ErrNotFound возвращается, когда запись отсутствует в хранилище.
+	ErrNotFound = errors.New("not found")
+	// ErrUserExists возвращает InsertUser, если имя уже занято.
+	ErrUserExists = errors.New("user already exists")
+)
+
+// Store - хранилище оркестратора: пользователи, выражения и узлы
+// вычислений. Реализации должны быть безопасны для конкурентного
+// использования.
+type Store interface {
+	// InsertUser возвращает ErrUserExists, если имя уже занято.
+	InsertUser(user *User) (int64, error)
+	SelectUser(name string) (User, error)
+	// SelectUsers возвращает всех пользователей без хешей паролей.
+	SelectUsers() ([]User, error)
+	SetUserRole(name, role string) error
+	SetUserDisabled(name string, disabled bool) error
+	// DeleteUser удаляет пользователя, его выражения, узлы, API ключи и refresh токены.
+	DeleteUser(name string) error
+	// ChangePassword меняет пароль и отзывает токены, выпущенные не позже now.
+	ChangePassword(name, hash string, now time.Time) error
+	// RenameUser переименовывает пользователя; ErrUserExists, если имя занято.
+	RenameUser(name, newName string) error
+	ExpressionStats(username string) (map[string]int64, error)
+
+	// CreateExpression атомарно сохраняет выражение вместе с его узлами.
+	CreateExpression(expr Expression, nodes []*calc.Node) error
+	// CompleteNode атомарно записывает результат узла и, если это корень,
+	// результат выражения. Возвращает true, если выражение вычислено.
+	CompleteNode(node_id string, payload float64) (bool, error)
+
+	InsertExpression(expr Expression) (int64, error)
+	SelectExpression(expr_id string) (Expression, error)
+	SelectExpressionsByUser(username string) ([]Expression, error)
+	SetExpressionStatus(expr_id string, status string) error
+	SetExpressionResult(expr_id string, payload float64) error
+	DeleteExpression(expr_id string) error
+	// ExpireExpressions помечает "expired" выражения с наступившим сроком,
+	// удаляет их узлы и возвращает ближайший из оставшихся сроков.
+	ExpireExpressions(now time.Time) ([]string, time.Time, error)
+	// PurgeExpressions удаляет завершённые выражения username (всех, если пусто).
+	PurgeExpressions(username string) (int64, error)
+
+	InsertNodes(nodes []*calc.Node) (int64, error)
+	SelectNode(id string) (calc.Node, error)
+	SetNodeStatus(node_id string, status string) (int64, error)
+	SetNodeResult(node_id string, payload float64) error
+	DeleteNodes(expr_id string) error
+
+	// SelectNodeAsTask возвращает готовый к вычислению узел, не меняя его статус.
+	SelectNodeAsTask() (Task, error)
+	// ClaimTask атомарно выбирает готовый узел и переводит его в "in_progress",
+	// так что один узел не выдаётся двум агентам.
+	ClaimTask() (Task, error)
+	// PendingNodes возвращает все невычисленные операции в порядке создания.
+	PendingNodes() ([]PendingNode, error)
+	// ClaimNode атомарно переводит готовый узел в "in_progress";
+	// ErrNotFound, если узел уже выдан, удалён или ещё не готов.
+	ClaimNode(node_id string) (Task, error)
+	// ReleaseNode возвращает выданный узел в очередь, например когда агент
+	// не прислал результат вовремя; ErrNotFound, если узел не выдан.
+	ReleaseNode(node_id string) error
+	// FailNode завершает выражение узла со статусом "failed", например при
+	// ошибке вычисления, и возвращает его идентификатор.
+	FailNode(node_id string) (string, error)
+
+	InsertRefreshToken(token RefreshToken) error
+	// RotateRefreshToken атомарно обменивает токен hash на next. Повторное
+	// использование токена отзывает всю цепочку и возвращает ErrTokenReused.
+	RotateRefreshToken(hash string, next RefreshToken, now time.Time) (RefreshToken, error)
+	RevokeRefreshFamily(family_id string) error
+	RevokeToken(jti string, expiresAt time.Time, now time.Time) error
+	IsTokenRevoked(jti string) (bool, error)
+
+	InsertAPIKey(key APIKey) (int64, error)
+	// SelectAPIKey ищет ключ по его открытому префиксу.
+	SelectAPIKey(prefix string) (APIKey, error)
+	SelectAPIKeysByUser(username string) ([]APIKey, error)
+	// DeleteAPIKey удаляет ключ id, только если он принадлежит username.
+	DeleteAPIKey(username string, id int64) error
+	TouchAPIKey(id int64, now time.Time) error
+
+	// Recover приводит хранилище в согласованное состояние после перезапуска.
+	Recover() (RecoveryReport, error)
+
+	Close() error
+}
+
+var (
+	_ Store = (*SQLiteStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
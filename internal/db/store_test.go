@@ -0,0 +1,815 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+)
+
+// Общий набор тестов, который должна проходить каждая реализация Store.
+
+func newSQLiteTestStore(t *testing.T) Store {
+	s, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite store: %v", err)
+	}
+	return s
+}
+
+func newMemoryTestStore(t *testing.T) Store {
+	return NewMemoryStore()
+}
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	runStoreConformance(t, newSQLiteTestStore)
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	runStoreConformance(t, newMemoryTestStore)
+}
+
+// Удаление узлов не должно оставлять следов в индексах порядка.
+func TestMemoryStore_NodeIndexes(t *testing.T) {
+	s := NewMemoryStore()
+	for _, expr := range []string{"a", "b"} {
+		_, nodes, _ := calc.ParseExpression("(1+2)*(3+4)")
+		for _, n := range nodes {
+			n.ExprID = expr
+		}
+		s.InsertNodes(nodes)
+	}
+	total := len(s.nodes)
+
+	s.DeleteNodes("a")
+	if s.nodeOrder.Len() != total/2 || len(s.nodeElems) != total/2 || len(s.exprNodes) != 1 {
+		t.Fatalf("Expected only nodes of b to stay indexed, got %d in order, %d elems, %d exprs",
+			s.nodeOrder.Len(), len(s.nodeElems), len(s.exprNodes))
+	}
+	for e := s.nodeOrder.Front(); e != nil; e = e.Next() {
+		if s.nodes[e.Value.(string)].ExprID != "b" {
+			t.Fatalf("Unexpected node %v left in order", e.Value)
+		}
+	}
+	s.DeleteNodes("b")
+	if s.nodeOrder.Len() != 0 || len(s.nodeElems) != 0 || len(s.exprNodes) != 0 {
+		t.Error("Expected empty indexes")
+	}
+}
+
+func runStoreConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"Users", testStoreUsers},
+		{"Expressions", testStoreExpressions},
+		{"Nodes", testStoreNodes},
+		{"TaskClaiming", testStoreTaskClaiming},
+		{"ReleaseAndFail", testStoreReleaseAndFail},
+		{"PendingNodes", testStorePendingNodes},
+		{"OperandPushing", testStoreOperandPushing},
+		{"Deadlines", testStoreDeadlines},
+		{"ExpressionLifecycle", testStoreExpressionLifecycle},
+		{"ConcurrentClaims", testStoreConcurrentClaims},
+		{"Recover", testStoreRecover},
+		{"RefreshTokens", testStoreRefreshTokens},
+		{"RevokedTokens", testStoreRevokedTokens},
+		{"APIKeys", testStoreAPIKeys},
+		{"UserAdministration", testStoreUserAdministration},
+		{"PurgeExpressions", testStorePurgeExpressions},
+		{"AccountManagement", testStoreAccountManagement},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			defer s.Close()
+			tt.fn(t, s)
+		})
+	}
+}
+
+func testStoreUsers(t *testing.T, s Store) {
+	id, err := s.InsertUser(&User{Name: "alice", Password: "hash"})
+	if err != nil || id <= 0 {
+		t.Fatalf("InsertUser: id=%d err=%v", id, err)
+	}
+
+	dup, err := s.InsertUser(&User{Name: "alice", Password: "other"})
+	if !errors.Is(err, ErrUserExists) || dup != 0 {
+		t.Errorf("duplicate InsertUser: expected (0, ErrUserExists), got (%d, %v)", dup, err)
+	}
+
+	user, err := s.SelectUser("alice")
+	if err != nil {
+		t.Fatalf("SelectUser: %v", err)
+	}
+	if user.ID != id || user.Password != "hash" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	if err := s.DeleteUser("alice"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.SelectUser("alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func testStoreExpressions(t *testing.T, s Store) {
+	for i, user := range []string{"bob", "carol", "bob"} {
+		_, err := s.InsertExpression(Expression{
+			ExprID:     fmt.Sprintf("e%d", i),
+			Expr:       "1+1",
+			Username:   user,
+			Status:     "processing",
+			RootNodeID: "root",
+		})
+		if err != nil {
+			t.Fatalf("InsertExpression: %v", err)
+		}
+	}
+
+	exprs, err := s.SelectExpressionsByUser("bob")
+	if err != nil {
+		t.Fatalf("SelectExpressionsByUser: %v", err)
+	}
+	if len(exprs) != 2 || exprs[0].ExprID != "e0" || exprs[1].ExprID != "e2" {
+		t.Errorf("unexpected expressions for bob: %+v", exprs)
+	}
+
+	if err := s.SetExpressionStatus("e1", "failed"); err != nil {
+		t.Fatalf("SetExpressionStatus: %v", err)
+	}
+	if err := s.SetExpressionResult("e0", 2); err != nil {
+		t.Fatalf("SetExpressionResult: %v", err)
+	}
+
+	e0, _ := s.SelectExpression("e0")
+	if e0.Status != "done" || e0.Result != 2 || e0.Username != "bob" || e0.Expr != "1+1" {
+		t.Errorf("unexpected e0: %+v", e0)
+	}
+	e1, _ := s.SelectExpression("e1")
+	if e1.Status != "failed" {
+		t.Errorf("expected e1 failed, got %+v", e1)
+	}
+
+	if err := s.DeleteExpression("e0"); err != nil {
+		t.Fatalf("DeleteExpression: %v", err)
+	}
+	if _, err := s.SelectExpression("e0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	exprs, _ = s.SelectExpressionsByUser("bob")
+	if len(exprs) != 1 {
+		t.Errorf("expected one expression for bob, got %d", len(exprs))
+	}
+}
+
+// (2+3)*4
+func conformanceNodes(exprID string) []*calc.Node {
+	p := exprID + "-"
+	return []*calc.Node{
+		{ID: p + "n1", ExprID: exprID, Type: "number", Status: "done", Result: 2, Depth: 2},
+		{ID: p + "n2", ExprID: exprID, Type: "number", Status: "done", Result: 3, Depth: 2},
+		{ID: p + "add", ExprID: exprID, Type: "operation", Operation: "+", Left: p + "n1", Right: p + "n2", Status: "pending", Depth: 1},
+		{ID: p + "n3", ExprID: exprID, Type: "number", Status: "done", Result: 4, Depth: 1},
+		{ID: p + "mul", ExprID: exprID, Type: "operation", Operation: "*", Left: p + "add", Right: p + "n3", Status: "pending"},
+	}
+}
+
+func testStoreNodes(t *testing.T, s Store) {
+	num, err := s.InsertNodes(conformanceNodes("x"))
+	if err != nil || num != 5 {
+		t.Fatalf("InsertNodes: num=%d err=%v", num, err)
+	}
+	s.InsertNodes(conformanceNodes("y"))
+
+	node, err := s.SelectNode("x-add")
+	if err != nil {
+		t.Fatalf("SelectNode: %v", err)
+	}
+	if node.Left != "x-n1" || node.Right != "x-n2" || node.Operation != "+" || node.Status != "pending" {
+		t.Errorf("unexpected node: %+v", node)
+	}
+
+	if n, err := s.SetNodeStatus("x-add", "in_progress"); err != nil || n != 1 {
+		t.Errorf("SetNodeStatus: n=%d err=%v", n, err)
+	}
+	if n, err := s.SetNodeStatus("missing", "done"); err != nil || n != 0 {
+		t.Errorf("SetNodeStatus on missing node: n=%d err=%v", n, err)
+	}
+	if err := s.SetNodeResult("x-add", 5); err != nil {
+		t.Fatalf("SetNodeResult: %v", err)
+	}
+	node, _ = s.SelectNode("x-add")
+	if node.Status != "done" || node.Result != 5 {
+		t.Errorf("unexpected node after result: %+v", node)
+	}
+
+	if err := s.DeleteNodes("x"); err != nil {
+		t.Fatalf("DeleteNodes: %v", err)
+	}
+	if _, err := s.SelectNode("x-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := s.SelectNode("y-add"); err != nil {
+		t.Errorf("nodes of other expression must survive: %v", err)
+	}
+}
+
+func testStoreTaskClaiming(t *testing.T, s Store) {
+	if _, err := s.ClaimTask(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound on empty store, got %v", err)
+	}
+	s.InsertNodes(conformanceNodes("x"))
+
+	peek, err := s.SelectNodeAsTask()
+	if err != nil || peek.ID != "x-add" {
+		t.Fatalf("SelectNodeAsTask: %+v %v", peek, err)
+	}
+
+	task, err := s.ClaimTask()
+	if err != nil {
+		t.Fatalf("ClaimTask: %v", err)
+	}
+	if task.ID != "x-add" || task.ExprID != "x" || task.Oper != "+" || task.Arg1 != 2 || task.Arg2 != 3 {
+		t.Errorf("unexpected task: %+v", task)
+	}
+	node, _ := s.SelectNode("x-add")
+	if node.Status != "in_progress" {
+		t.Errorf("expected claimed node in_progress, got %s", node.Status)
+	}
+
+	if _, err := s.ClaimTask(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected no task while dependency is in progress, got %v", err)
+	}
+
+	s.SetNodeResult("x-add", 5)
+	task, err = s.ClaimTask()
+	if err != nil {
+		t.Fatalf("ClaimTask: %v", err)
+	}
+	if task.ID != "x-mul" || task.Arg1 != 5 || task.Arg2 != 4 {
+		t.Errorf("unexpected task: %+v", task)
+	}
+}
+
+func testStoreReleaseAndFail(t *testing.T, s Store) {
+	s.CreateExpression(Expression{ExprID: "x", Username: "alice", Status: "processing", RootNodeID: "x-mul"}, conformanceNodes("x"))
+	s.CreateExpression(Expression{ExprID: "y", Username: "alice", Status: "processing", RootNodeID: "y-mul"}, conformanceNodes("y"))
+
+	if err := s.ReleaseNode("x-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a node that is not claimed, got %v", err)
+	}
+	if _, err := s.ClaimNode("x-add"); err != nil {
+		t.Fatalf("ClaimNode: %v", err)
+	}
+	if err := s.ReleaseNode("x-add"); err != nil {
+		t.Fatalf("ReleaseNode: %v", err)
+	}
+	if node, _ := s.SelectNode("x-add"); node.Status != "pending" {
+		t.Errorf("expected released node pending, got %s", node.Status)
+	}
+	if _, err := s.ClaimNode("x-add"); err != nil {
+		t.Errorf("expected released node to be claimable again: %v", err)
+	}
+
+	exprID, err := s.FailNode("x-add")
+	if err != nil || exprID != "x" {
+		t.Fatalf("FailNode: %q %v", exprID, err)
+	}
+	if expr, _ := s.SelectExpression("x"); expr.Status != "failed" {
+		t.Errorf("expected failed expression, got %s", expr.Status)
+	}
+	if _, err := s.SelectNode("x-mul"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected nodes of the failed expression to be deleted, got %v", err)
+	}
+	if _, err := s.FailNode("x-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a failed expression, got %v", err)
+	}
+	if expr, _ := s.SelectExpression("y"); expr.Status != "processing" {
+		t.Errorf("other expression must stay processing, got %s", expr.Status)
+	}
+}
+
+func testStorePendingNodes(t *testing.T, s Store) {
+	s.CreateExpression(Expression{ExprID: "x", Username: "alice", Status: "processing", RootNodeID: "x-mul"}, conformanceNodes("x"))
+	deadline := time.Unix(1700000000, 0)
+	s.CreateExpression(Expression{ExprID: "y", Username: "bob", Status: "processing", RootNodeID: "y-mul",
+		Priority: PriorityHigh, Deadline: deadline, TraceID: "trace-y", SpanID: "span-y"}, conformanceNodes("y"))
+
+	pending, err := s.PendingNodes()
+	if err != nil {
+		t.Fatalf("PendingNodes: %v", err)
+	}
+	x := ReadyTask{ExprID: "x", Username: "alice", Priority: PriorityNormal}
+	y := ReadyTask{ExprID: "y", Username: "bob", Priority: PriorityHigh, Deadline: deadline, TraceID: "trace-y", SpanID: "span-y"}
+	node := func(task ReadyTask, id string, depth int, left, right string, waiting int) PendingNode {
+		task.NodeID, task.Depth = id, depth
+		return PendingNode{ReadyTask: task, Left: left, Right: right, Status: "pending", Waiting: waiting}
+	}
+	want := []PendingNode{
+		node(x, "x-add", 1, "x-n1", "x-n2", 0),
+		node(x, "x-mul", 0, "x-add", "x-n3", 1),
+		node(y, "y-add", 1, "y-n1", "y-n2", 0),
+		node(y, "y-mul", 0, "y-add", "y-n3", 1),
+	}
+	if fmt.Sprint(pending) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, pending)
+	}
+
+	if e, _ := s.SelectExpression("y"); e.TraceID != "trace-y" || e.SpanID != "span-y" {
+		t.Errorf("expected trace context of the expression, got %+v", e)
+	}
+
+	if _, err := s.ClaimNode("y-mul"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for node with pending operands, got %v", err)
+	}
+	task, err := s.ClaimNode("y-add")
+	if err != nil || task.ID != "y-add" || task.Arg1 != 2 || task.Arg2 != 3 {
+		t.Fatalf("ClaimNode: %+v %v", task, err)
+	}
+	if _, err := s.ClaimNode("y-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for claimed node, got %v", err)
+	}
+	if _, err := s.ClaimNode("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for missing node, got %v", err)
+	}
+
+	s.CompleteNode("y-add", 5)
+	pending, _ = s.PendingNodes()
+	if len(pending) != 3 || pending[2].NodeID != "y-mul" || pending[2].Waiting != 0 {
+		t.Errorf("expected y-mul without waiting operands, got %v", pending)
+	}
+}
+
+func testStoreOperandPushing(t *testing.T, s Store) {
+	s.CreateExpression(Expression{ExprID: "x", Username: "u", Status: "processing", RootNodeID: "x-mul"}, conformanceNodes("x"))
+	s.ClaimNode("x-add")
+	if finished, err := s.CompleteNode("x-add", 5); err != nil || finished {
+		t.Fatalf("CompleteNode(x-add): finished=%v err=%v", finished, err)
+	}
+
+	// Результат записан в родителя, поэтому узел и его операнды удалены.
+	for _, id := range []string{"x-add", "x-n1", "x-n2"} {
+		if _, err := s.SelectNode(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected %s to be deleted, got %v", id, err)
+		}
+	}
+	if _, err := s.SelectNode("x-n3"); err != nil {
+		t.Errorf("Operand of pending node must stay: %v", err)
+	}
+	task, err := s.ClaimNode("x-mul")
+	if err != nil || task.Arg1 != 5 || task.Arg2 != 4 {
+		t.Fatalf("ClaimNode(x-mul): %+v %v", task, err)
+	}
+
+	// Повторный результат уже удалённого узла не учитывается.
+	if _, err := s.CompleteNode("x-add", 6); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for completed node, got %v", err)
+	}
+	if finished, err := s.CompleteNode("x-mul", 20); err != nil || !finished {
+		t.Errorf("CompleteNode(x-mul): finished=%v err=%v", finished, err)
+	}
+}
+
+func testStoreDeadlines(t *testing.T, s Store) {
+	now := time.Unix(1700000000, 0)
+	newExpr := func(id string, deadline time.Time) {
+		expr := Expression{ExprID: id, Username: "u", Status: "processing", RootNodeID: id + "-mul", Deadline: deadline}
+		if err := s.CreateExpression(expr, conformanceNodes(id)); err != nil {
+			t.Fatalf("CreateExpression(%s): %v", id, err)
+		}
+	}
+	newExpr("late", now.Add(-time.Second))
+	newExpr("due", now)
+	newExpr("later", now.Add(time.Hour))
+	newExpr("soon", now.Add(time.Minute))
+	newExpr("never", time.Time{})
+	s.InsertExpression(Expression{ExprID: "finished", Username: "u", Status: "done", Deadline: now.Add(-time.Hour)})
+
+	if e, _ := s.SelectExpression("soon"); e.Priority != PriorityNormal || !e.Deadline.Equal(now.Add(time.Minute)) {
+		t.Errorf("unexpected priority or deadline: %+v", e)
+	}
+
+	expired, next, err := s.ExpireExpressions(now)
+	if err != nil {
+		t.Fatalf("ExpireExpressions: %v", err)
+	}
+	if fmt.Sprint(expired) != "[late due]" {
+		t.Errorf("expected late and due expired, got %v", expired)
+	}
+	if !next.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected next deadline in a minute, got %v", next)
+	}
+	if e, _ := s.SelectExpression("late"); e.Status != StatusExpired {
+		t.Errorf("expected expired status, got %s", e.Status)
+	}
+	if _, err := s.SelectNode("late-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("nodes of expired expression must be deleted, got %v", err)
+	}
+	if e, _ := s.SelectExpression("finished"); e.Status != "done" {
+		t.Errorf("finished expression must not expire, got %s", e.Status)
+	}
+	if _, err := s.SelectNode("never-add"); err != nil {
+		t.Errorf("expression without deadline must keep its nodes: %v", err)
+	}
+
+	expired, next, _ = s.ExpireExpressions(now.Add(2 * time.Hour))
+	if len(expired) != 2 || !next.IsZero() {
+		t.Errorf("expected the rest expired and no next deadline, got %v %v", expired, next)
+	}
+}
+
+func testStoreConcurrentClaims(t *testing.T, s Store) {
+	const exprs = 20
+	for i := 0; i < exprs; i++ {
+		s.InsertNodes(conformanceNodes(fmt.Sprintf("c%d", i)))
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[string]int)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, err := s.ClaimTask()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				claimed[task.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != exprs {
+		t.Errorf("expected %d claimed tasks, got %d", exprs, len(claimed))
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("task %s claimed %d times", id, n)
+		}
+	}
+}
+
+func testStoreExpressionLifecycle(t *testing.T, s Store) {
+	expr := Expression{ExprID: "x", Expr: "(2+3)*4", Username: "dave", Status: "processing", RootNodeID: "x-mul"}
+	if err := s.CreateExpression(expr, conformanceNodes("x")); err != nil {
+		t.Fatalf("CreateExpression: %v", err)
+	}
+	if _, err := s.SelectNode("x-n3"); err != nil {
+		t.Fatalf("nodes were not created: %v", err)
+	}
+
+	if _, err := s.CompleteNode("missing", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for missing node, got %v", err)
+	}
+
+	finished, err := s.CompleteNode("x-add", 5)
+	if err != nil || finished {
+		t.Fatalf("CompleteNode(x-add): finished=%v err=%v", finished, err)
+	}
+	if e, _ := s.SelectExpression("x"); e.Status != "processing" {
+		t.Errorf("expression must still be processing, got %s", e.Status)
+	}
+
+	finished, err = s.CompleteNode("x-mul", 20)
+	if err != nil || !finished {
+		t.Fatalf("CompleteNode(x-mul): finished=%v err=%v", finished, err)
+	}
+	e, _ := s.SelectExpression("x")
+	if e.Status != "done" || e.Result != 20 {
+		t.Errorf("unexpected expression after root completed: %+v", e)
+	}
+	if _, err := s.SelectNode("x-n1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("nodes must be deleted after root completed, got %v", err)
+	}
+}
+
+func testStoreRecover(t *testing.T, s Store) {
+	newExpr := func(id, root string) {
+		err := s.CreateExpression(Expression{ExprID: id, Username: "u", Status: "processing", RootNodeID: id + "-" + root}, conformanceNodes(id))
+		if err != nil {
+			t.Fatalf("CreateExpression(%s): %v", id, err)
+		}
+	}
+
+	// Агент взял узел и не вернул результат.
+	newExpr("stale", "mul")
+	s.ClaimTask()
+	// Корень вычислен, но статус выражения не обновлён.
+	newExpr("rootdone", "add")
+	s.SetNodeResult("rootdone-add", 5)
+	// Узлов не осталось вовсе.
+	s.InsertExpression(Expression{ExprID: "orphan", Username: "u", Status: "processing", RootNodeID: "gone"})
+	// Завершённые выражения не трогаем.
+	s.InsertExpression(Expression{ExprID: "finished", Username: "u", Status: "done", RootNodeID: "gone", Result: 1})
+
+	report, err := s.Recover()
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if report.NodesReset != 1 {
+		t.Errorf("Expected 1 node reset, got %d", report.NodesReset)
+	}
+	if len(report.Finished) != 1 || report.Finished[0] != "rootdone" {
+		t.Errorf("Expected rootdone finished, got %v", report.Finished)
+	}
+	if len(report.Failed) != 1 || report.Failed[0] != "orphan" {
+		t.Errorf("Expected orphan failed, got %v", report.Failed)
+	}
+
+	if node, _ := s.SelectNode("stale-add"); node.Status != "pending" {
+		t.Errorf("Expected stale node to be pending, got %s", node.Status)
+	}
+	if e, _ := s.SelectExpression("rootdone"); e.Status != "done" || e.Result != 5 {
+		t.Errorf("Unexpected rootdone: %+v", e)
+	}
+	if _, err := s.SelectNode("rootdone-n1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected nodes of finished expression to be deleted, got %v", err)
+	}
+	if e, _ := s.SelectExpression("orphan"); e.Status != "failed" {
+		t.Errorf("Expected orphan to be failed, got %s", e.Status)
+	}
+	if e, _ := s.SelectExpression("finished"); e.Status != "done" {
+		t.Errorf("Finished expression must stay done, got %s", e.Status)
+	}
+
+	report, _ = s.Recover()
+	if report.NodesReset != 0 || len(report.Finished) != 0 || len(report.Failed) != 0 {
+		t.Errorf("Second Recover must be a no-op, got %+v", report)
+	}
+}
+
+func testStoreRefreshTokens(t *testing.T, s Store) {
+	now := time.Unix(1_700_000_000, 0)
+	exp := now.Add(time.Hour)
+	if err := s.InsertRefreshToken(RefreshToken{Hash: "h1", FamilyID: "f", Username: "erin", ExpiresAt: exp}); err != nil {
+		t.Fatalf("InsertRefreshToken: %v", err)
+	}
+	s.InsertRefreshToken(RefreshToken{Hash: "other", FamilyID: "g", Username: "erin", ExpiresAt: exp})
+
+	if _, err := s.RotateRefreshToken("missing", RefreshToken{Hash: "x", ExpiresAt: exp}, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	used, err := s.RotateRefreshToken("h1", RefreshToken{Hash: "h2", ExpiresAt: exp}, now)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if used.Username != "erin" || used.FamilyID != "f" {
+		t.Errorf("Unexpected rotated token: %+v", used)
+	}
+
+	// h1 уже использован: вся цепочка f отзывается.
+	if _, err := s.RotateRefreshToken("h1", RefreshToken{Hash: "h3", ExpiresAt: exp}, now); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("Expected ErrTokenReused, got %v", err)
+	}
+	if _, err := s.RotateRefreshToken("h2", RefreshToken{Hash: "h4", ExpiresAt: exp}, now); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected family to be revoked, got %v", err)
+	}
+	if _, err := s.RotateRefreshToken("other", RefreshToken{Hash: "o2", ExpiresAt: exp}, now); err != nil {
+		t.Errorf("Other family must not be affected: %v", err)
+	}
+
+	if _, err := s.RotateRefreshToken("o2", RefreshToken{Hash: "o3", ExpiresAt: exp}, exp); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+
+	s.RevokeRefreshFamily("g")
+	if _, err := s.RotateRefreshToken("o2", RefreshToken{Hash: "o4", ExpiresAt: exp}, now); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected ErrTokenRevoked after RevokeRefreshFamily, got %v", err)
+	}
+
+	// Истёкшие токены удаляются при следующей ротации.
+	later := exp.Add(time.Minute)
+	s.InsertRefreshToken(RefreshToken{Hash: "fresh", FamilyID: "k", Username: "erin", ExpiresAt: later.Add(time.Hour)})
+	if _, err := s.RotateRefreshToken("fresh", RefreshToken{Hash: "fresh2", ExpiresAt: later.Add(time.Hour)}, later); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if _, err := s.RotateRefreshToken("o2", RefreshToken{Hash: "o5", ExpiresAt: exp}, later); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected expired token to be purged, got %v", err)
+	}
+}
+
+func testStoreRevokedTokens(t *testing.T, s Store) {
+	now := time.Unix(1_700_000_000, 0)
+	if revoked, err := s.IsTokenRevoked("j1"); err != nil || revoked {
+		t.Fatalf("IsTokenRevoked on empty store: %v %v", revoked, err)
+	}
+	s.RevokeToken("j1", now.Add(time.Minute), now)
+	if revoked, _ := s.IsTokenRevoked("j1"); !revoked {
+		t.Error("Expected j1 to be revoked")
+	}
+
+	// Истёкшие записи удаляются при следующем отзыве.
+	s.RevokeToken("j2", now.Add(time.Hour), now.Add(2*time.Minute))
+	if revoked, _ := s.IsTokenRevoked("j1"); revoked {
+		t.Error("Expected expired j1 to be purged")
+	}
+	if revoked, _ := s.IsTokenRevoked("j2"); !revoked {
+		t.Error("Expected j2 to be revoked")
+	}
+}
+
+func testStoreAPIKeys(t *testing.T, s Store) {
+	now := time.Unix(1_700_000_000, 0)
+	key := APIKey{Prefix: "p1", Hash: "h1", Username: "frank", Name: "ci",
+		Scopes: []string{"calculate", "read"}, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	id, err := s.InsertAPIKey(key)
+	if err != nil || id == 0 {
+		t.Fatalf("InsertAPIKey: %d %v", id, err)
+	}
+	if _, err := s.InsertAPIKey(APIKey{Prefix: "p1", Hash: "h2", Username: "frank", Scopes: []string{"read"}}); err == nil {
+		t.Error("Expected error on duplicate prefix")
+	}
+	s.InsertAPIKey(APIKey{Prefix: "p2", Hash: "h2", Username: "frank", Scopes: []string{"read"}, CreatedAt: now})
+	s.InsertAPIKey(APIKey{Prefix: "p3", Hash: "h3", Username: "grace", Scopes: []string{"read"}, CreatedAt: now})
+
+	got, err := s.SelectAPIKey("p1")
+	if err != nil {
+		t.Fatalf("SelectAPIKey: %v", err)
+	}
+	if got.ID != id || got.Hash != "h1" || got.Username != "frank" || got.Name != "ci" ||
+		strings.Join(got.Scopes, ",") != "calculate,read" ||
+		!got.CreatedAt.Equal(now) || !got.ExpiresAt.Equal(now.Add(time.Hour)) || !got.LastUsedAt.IsZero() {
+		t.Errorf("Unexpected key: %+v", got)
+	}
+	if _, err := s.SelectAPIKey("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	keys, _ := s.SelectAPIKeysByUser("frank")
+	if len(keys) != 2 || keys[0].Prefix != "p1" || keys[1].Prefix != "p2" || !keys[1].ExpiresAt.IsZero() {
+		t.Errorf("Unexpected keys of frank: %+v", keys)
+	}
+
+	if err := s.TouchAPIKey(id, now.Add(time.Minute)); err != nil {
+		t.Fatalf("TouchAPIKey: %v", err)
+	}
+	got, _ = s.SelectAPIKey("p1")
+	if !got.LastUsedAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected last used time to be updated, got %v", got.LastUsedAt)
+	}
+
+	// Чужой ключ удалить нельзя.
+	if err := s.DeleteAPIKey("grace", id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when deleting another user's key, got %v", err)
+	}
+	if err := s.DeleteAPIKey("frank", id); err != nil {
+		t.Fatalf("DeleteAPIKey: %v", err)
+	}
+	if _, err := s.SelectAPIKey("p1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleted key to be gone, got %v", err)
+	}
+}
+
+func testStoreUserAdministration(t *testing.T, s Store) {
+	s.InsertUser(&User{Name: "root", Password: "hash", Role: RoleAdmin})
+	s.InsertUser(&User{Name: "henry", Password: "hash"})
+
+	users, err := s.SelectUsers()
+	if err != nil || len(users) != 2 {
+		t.Fatalf("SelectUsers: %v %+v", err, users)
+	}
+	if users[0].Name != "root" || users[0].Role != RoleAdmin || users[1].Role != RoleUser || users[1].Password != "" {
+		t.Errorf("Unexpected users: %+v", users)
+	}
+
+	if err := s.SetUserDisabled("henry", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if err := s.SetUserRole("henry", RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	if user, _ := s.SelectUser("henry"); !user.Disabled || user.Role != RoleAdmin {
+		t.Errorf("Expected henry to be a disabled admin, got %+v", user)
+	}
+	if err := s.SetUserDisabled("nobody", true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown user, got %v", err)
+	}
+	if err := s.SetUserRole("nobody", RoleAdmin); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown user, got %v", err)
+	}
+
+	// Удаление пользователя удаляет и все его данные.
+	s.CreateExpression(Expression{ExprID: "h", Username: "henry", Status: "processing", RootNodeID: "h-mul"}, conformanceNodes("h"))
+	s.CreateExpression(Expression{ExprID: "r", Username: "root", Status: "processing", RootNodeID: "r-mul"}, conformanceNodes("r"))
+	s.InsertAPIKey(APIKey{Prefix: "hp", Hash: "hh", Username: "henry", Scopes: []string{"read"}})
+	exp := time.Unix(1_700_000_000, 0)
+	s.InsertRefreshToken(RefreshToken{Hash: "hr", FamilyID: "hf", Username: "henry", ExpiresAt: exp})
+
+	if err := s.DeleteUser("henry"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.SelectExpression("h"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected expression of deleted user to be gone, got %v", err)
+	}
+	if _, err := s.SelectNode("h-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected nodes of deleted user to be gone, got %v", err)
+	}
+	if _, err := s.SelectAPIKey("hp"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected api key of deleted user to be gone, got %v", err)
+	}
+	if _, err := s.RotateRefreshToken("hr", RefreshToken{Hash: "hr2", ExpiresAt: exp}, exp.Add(-time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected refresh token of deleted user to be gone, got %v", err)
+	}
+	if _, err := s.SelectNode("r-add"); err != nil {
+		t.Errorf("Expected other user's nodes to stay, got %v", err)
+	}
+}
+
+func testStorePurgeExpressions(t *testing.T, s Store) {
+	for _, e := range []struct{ id, user, status string }{
+		{"p1", "ivan", "done"},
+		{"p2", "ivan", "processing"},
+		{"p3", "judy", "failed"},
+		{"p4", "ivan", "failed"},
+	} {
+		s.CreateExpression(Expression{ExprID: e.id, Username: e.user, Status: e.status, RootNodeID: e.id + "-mul"}, conformanceNodes(e.id))
+	}
+
+	num, err := s.PurgeExpressions("ivan")
+	if err != nil || num != 2 {
+		t.Fatalf("PurgeExpressions(ivan): %d %v", num, err)
+	}
+	if _, err := s.SelectExpression("p2"); err != nil {
+		t.Errorf("Expected processing expression to stay, got %v", err)
+	}
+	if _, err := s.SelectNode("p1-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected nodes of purged expression to be gone, got %v", err)
+	}
+	if _, err := s.SelectExpression("p3"); err != nil {
+		t.Errorf("Expected other user's expression to stay, got %v", err)
+	}
+
+	if num, _ := s.PurgeExpressions(""); num != 1 {
+		t.Errorf("Expected one more expression to be purged, got %d", num)
+	}
+}
+
+func testStoreAccountManagement(t *testing.T, s Store) {
+	now := time.Unix(1_700_000_000, 0)
+	s.InsertUser(&User{Name: "kate", Password: "old"})
+	s.InsertUser(&User{Name: "leo", Password: "hash"})
+	s.InsertRefreshToken(RefreshToken{Hash: "k1", FamilyID: "kf", Username: "kate", ExpiresAt: now.Add(time.Hour)})
+
+	if err := s.ChangePassword("kate", "new", now); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	user, _ := s.SelectUser("kate")
+	if user.Password != "new" || !user.TokensNotBefore.Equal(now) {
+		t.Errorf("Unexpected user after password change: %+v", user)
+	}
+	if _, err := s.RotateRefreshToken("k1", RefreshToken{Hash: "k2", ExpiresAt: now.Add(time.Hour)}, now); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected refresh tokens to be revoked, got %v", err)
+	}
+	if err := s.ChangePassword("nobody", "x", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	for i, status := range []string{"done", "done", "processing"} {
+		id := fmt.Sprintf("k%d", i)
+		s.CreateExpression(Expression{ExprID: id, Username: "kate", Status: status, RootNodeID: id + "-mul"}, conformanceNodes(id))
+	}
+	s.InsertAPIKey(APIKey{Prefix: "kp", Hash: "kh", Username: "kate", Scopes: []string{"read"}})
+
+	stats, err := s.ExpressionStats("kate")
+	if err != nil || stats["done"] != 2 || stats["processing"] != 1 || len(stats) != 2 {
+		t.Errorf("ExpressionStats: %v %v", stats, err)
+	}
+
+	if err := s.RenameUser("kate", "leo"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if err := s.RenameUser("ghost", "casper"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := s.RenameUser("kate", "katherine"); err != nil {
+		t.Fatalf("RenameUser: %v", err)
+	}
+	if _, err := s.SelectUser("kate"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected old name to be free, got %v", err)
+	}
+	if user, _ := s.SelectUser("katherine"); user.Password != "new" {
+		t.Errorf("Expected renamed user to keep the password, got %+v", user)
+	}
+	if exprs, _ := s.SelectExpressionsByUser("katherine"); len(exprs) != 3 {
+		t.Errorf("Expected expressions to follow the rename, got %d", len(exprs))
+	}
+	if key, _ := s.SelectAPIKey("kp"); key.Username != "katherine" {
+		t.Errorf("Expected api key to follow the rename, got %+v", key)
+	}
+}
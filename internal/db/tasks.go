@@ -0,0 +1,226 @@
+package db
+
+import (
+	"time"
+
+	"github.com/saykoooo/calc_go/internal/calc"
+	"github.com/saykoooo/calc_go/internal/logging"
+)
+
+// ReadyTask - готовый к вычислению узел вместе с данными, нужными
+// планировщику: владельцем, приоритетом и сроком выражения и глубиной узла.
+type ReadyTask struct {
+	NodeID   string
+	ExprID   string
+	Username string
+	Depth    int
+	Priority string
+	Deadline time.Time
+	// TraceID и SpanID - контекст трассировки выражения, см. Expression.
+	TraceID string
+	SpanID  string
+}
+
+// PendingNode - ещё не вычисленная операция. По ним оркестратор строит
+// в памяти граф зависимостей.
+type PendingNode struct {
+	ReadyTask
+	Left   string
+	Right  string
+	Status string
+	// Waiting - число ещё не вычисленных операндов.
+	Waiting int
+}
+
+// operands - родитель узла и значения его операндов, известные к этому
+// моменту. Вычисленный узел записывает результат в операнды родителя.
+type operands struct {
+	parent string
+	args   [2]float64
+	// waiting - число ещё не вычисленных операндов.
+	waiting int
+}
+
+// batchOperands находит родителей узлов пакета и уже известные операнды.
+// Операнд, которого нет в пакете, считается невычисленным.
+func batchOperands(nodes []*calc.Node) map[string]*operands {
+	byID := make(map[string]*calc.Node, len(nodes))
+	ops := make(map[string]*operands, len(nodes))
+	for _, n := range nodes {
+		byID[n.ID] = n
+		ops[n.ID] = &operands{}
+	}
+	for _, n := range nodes {
+		if n.Type != "operation" {
+			continue
+		}
+		for i, child := range []string{n.Left, n.Right} {
+			c, ok := byID[child]
+			if !ok {
+				ops[n.ID].waiting++
+				continue
+			}
+			ops[child].parent = n.ID
+			if c.Status == "done" {
+				ops[n.ID].args[i] = c.Result
+			} else {
+				ops[n.ID].waiting++
+			}
+		}
+	}
+	return ops
+}
+
+// PendingNodes возвращает все невычисленные операции в порядке их создания.
+func (s *SQLiteStore) PendingNodes() ([]PendingNode, error) {
+	q := `
+	SELECT N.node_id, N.expr_id, COALESCE(E.username, ""), N.depth,
+		COALESCE(E.priority, "normal"), COALESCE(E.deadline, 0),
+		COALESCE(E.trace_id, ""), COALESCE(E.span_id, ""),
+		N.l_id, N.r_id, N.status, N.waiting
+	FROM nodes AS N
+	LEFT JOIN expressions AS E ON E.expr_id = N.expr_id
+	WHERE N.type = "operation" AND N.status != "done"
+	ORDER BY N.id
+	`
+	rows, err := s.db.QueryContext(s.ctx, q)
+	if err != nil {
+		s.logger.Error("DB: PendingNodes error", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []PendingNode
+	for rows.Next() {
+		var (
+			node     PendingNode
+			deadline int64
+		)
+		err := rows.Scan(&node.NodeID, &node.ExprID, &node.Username, &node.Depth, &node.Priority, &deadline,
+			&node.TraceID, &node.SpanID, &node.Left, &node.Right, &node.Status, &node.Waiting)
+		if err != nil {
+			return nil, err
+		}
+		node.Deadline = timeOrZero(deadline)
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// ClaimNode атомарно переводит готовый узел node_id в "in_progress".
+// Возвращает ErrNotFound, если узел уже выдан, удалён или ещё не готов.
+func (s *SQLiteStore) ClaimNode(node_id string) (Task, error) {
+	return s.claim(readyTaskSelect+"AND N.node_id = $1", node_id)
+}
+
+func (s *MemoryStore) PendingNodes() ([]PendingNode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nodes []PendingNode
+	for e := s.nodeOrder.Front(); e != nil; e = e.Next() {
+		node := s.nodes[e.Value.(string)]
+		if node.Type != "operation" || node.Status == "done" {
+			continue
+		}
+		pending := PendingNode{
+			ReadyTask: ReadyTask{NodeID: node.ID, ExprID: node.ExprID, Depth: node.Depth, Priority: PriorityNormal},
+			Left:      node.Left,
+			Right:     node.Right,
+			Status:    node.Status,
+			Waiting:   s.operands[node.ID].waiting,
+		}
+		if expr, ok := s.exprs[node.ExprID]; ok {
+			pending.Username, pending.Priority, pending.Deadline = expr.Username, expr.Priority, expr.Deadline
+			pending.TraceID, pending.SpanID = expr.TraceID, expr.SpanID
+		}
+		nodes = append(nodes, pending)
+	}
+	return nodes, nil
+}
+
+func (s *MemoryStore) ClaimNode(node_id string) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[node_id]
+	if !ok || !s.isReady(node) {
+		return Task{}, ErrNotFound
+	}
+	node.Status = "in_progress"
+	return s.taskFromNode(node), nil
+}
+
+// ReleaseNode возвращает выданный узел в "pending", чтобы его можно было
+// выдать снова. Возвращает ErrNotFound, если узел не "in_progress".
+func (s *SQLiteStore) ReleaseNode(node_id string) error {
+	result, err := s.db.ExecContext(s.ctx, `UPDATE nodes SET status="pending" WHERE node_id=$1 AND status="in_progress"`, node_id)
+	if err != nil {
+		s.logger.Error("DB: Error releasing node", logging.NodeID, node_id, "error", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FailNode завершает выражение узла со статусом "failed" и удаляет его
+// узлы. Возвращает выражение; ErrNotFound, если узла или выражения в
+// обработке уже нет.
+func (s *SQLiteStore) FailNode(node_id string) (string, error) {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var expr_id string
+	q := `
+	SELECT E.expr_id
+	FROM nodes AS N
+	JOIN expressions AS E ON E.expr_id = N.expr_id
+	WHERE N.node_id = $1 AND E.status = "processing"
+	`
+	if err := tx.QueryRowContext(s.ctx, q, node_id).Scan(&expr_id); err != nil {
+		return "", notFound(err)
+	}
+	if _, err := tx.ExecContext(s.ctx, `UPDATE expressions SET status="failed" WHERE expr_id=$1`, expr_id); err != nil {
+		s.logger.Error("DB: Error failing expression", logging.ExprID, expr_id, "error", err)
+		return "", err
+	}
+	if _, err := tx.ExecContext(s.ctx, "DELETE FROM nodes WHERE expr_id=$1", expr_id); err != nil {
+		s.logger.Error("DB: Error deleting nodes", logging.ExprID, expr_id, "error", err)
+		return "", err
+	}
+	return expr_id, tx.Commit()
+}
+
+func (s *MemoryStore) ReleaseNode(node_id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[node_id]
+	if !ok || node.Status != "in_progress" {
+		return ErrNotFound
+	}
+	node.Status = "pending"
+	return nil
+}
+
+func (s *MemoryStore) FailNode(node_id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[node_id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	expr, ok := s.exprs[node.ExprID]
+	if !ok || expr.Status != "processing" {
+		return "", ErrNotFound
+	}
+	expr.Status = "failed"
+	s.deleteNodes(expr.ExprID)
+	return expr.ExprID, nil
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTokenReused - повторное предъявление уже использованного refresh токена.
+	// Вся цепочка токенов при этом отзывается.
+	ErrTokenReused  = errors.New("refresh token reused")
+	ErrTokenRevoked = errors.New("refresh token revoked")
+	ErrTokenExpired = errors.New("refresh token expired")
+)
+
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	Username  string
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
+
+func (s *SQLiteStore) InsertRefreshToken(token RefreshToken) error {
+	q := "INSERT INTO refresh_tokens (token_hash, family_id, username, expires_at) VALUES ($1, $2, $3, $4)"
+	_, err := s.db.ExecContext(s.ctx, q, token.Hash, token.FamilyID, token.Username, token.ExpiresAt.Unix())
+	return err
+}
+
+// RotateRefreshToken помечает токен hash использованным и в той же
+// транзакции сохраняет следующий токен той же цепочки. Возвращает
+// использованный токен. Заодно удаляются токены, срок которых истёк.
+func (s *SQLiteStore) RotateRefreshToken(hash string, next RefreshToken, now time.Time) (RefreshToken, error) {
+	var (
+		token     RefreshToken
+		expiresAt int64
+	)
+
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return token, err
+	}
+	defer tx.Rollback()
+
+	q := "SELECT token_hash, family_id, username, expires_at, used, revoked FROM refresh_tokens WHERE token_hash=$1"
+	err = tx.QueryRowContext(s.ctx, q, hash).Scan(&token.Hash, &token.FamilyID, &token.Username, &expiresAt, &token.Used, &token.Revoked)
+	if err != nil {
+		return RefreshToken{}, notFound(err)
+	}
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+
+	switch {
+	case token.Revoked:
+		return token, ErrTokenRevoked
+	case token.Used:
+		if _, err := tx.ExecContext(s.ctx, "UPDATE refresh_tokens SET revoked=1 WHERE family_id=$1", token.FamilyID); err != nil {
+			return token, err
+		}
+		if err := tx.Commit(); err != nil {
+			return token, err
+		}
+		return token, ErrTokenReused
+	case !now.Before(token.ExpiresAt):
+		return token, ErrTokenExpired
+	}
+
+	if _, err := tx.ExecContext(s.ctx, "UPDATE refresh_tokens SET used=1 WHERE token_hash=$1", hash); err != nil {
+		return token, err
+	}
+	if _, err := tx.ExecContext(s.ctx, "DELETE FROM refresh_tokens WHERE expires_at<=$1", now.Unix()); err != nil {
+		return token, err
+	}
+	next.FamilyID, next.Username = token.FamilyID, token.Username
+	q = "INSERT INTO refresh_tokens (token_hash, family_id, username, expires_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.ExecContext(s.ctx, q, next.Hash, next.FamilyID, next.Username, next.ExpiresAt.Unix()); err != nil {
+		return token, err
+	}
+	return token, tx.Commit()
+}
+
+func (s *SQLiteStore) RevokeRefreshFamily(family_id string) error {
+	_, err := s.db.ExecContext(s.ctx, "UPDATE refresh_tokens SET revoked=1 WHERE family_id=$1", family_id)
+	return err
+}
+
+// RevokeToken вносит jti access токена в список отозванных до expiresAt.
+// Заодно удаляются записи, срок которых уже истёк.
+func (s *SQLiteStore) RevokeToken(jti string, expiresAt time.Time, now time.Time) error {
+	if _, err := s.db.ExecContext(s.ctx, "DELETE FROM revoked_tokens WHERE expires_at<=$1", now.Unix()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(s.ctx, "INSERT OR REPLACE INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)", jti, expiresAt.Unix())
+	return err
+}
+
+func (s *SQLiteStore) IsTokenRevoked(jti string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(s.ctx, "SELECT COUNT(*) FROM revoked_tokens WHERE jti=$1", jti).Scan(&n)
+	return n > 0, err
+}
+
+func (s *MemoryStore) InsertRefreshToken(token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.refreshTokens[token.Hash]; ok {
+		return errors.New("refresh token already exists")
+	}
+	s.refreshTokens[token.Hash] = &token
+	return nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(hash string, next RefreshToken, now time.Time) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[hash]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	switch {
+	case token.Revoked:
+		return *token, ErrTokenRevoked
+	case token.Used:
+		s.revokeFamily(token.FamilyID)
+		return *token, ErrTokenReused
+	case !now.Before(token.ExpiresAt):
+		return *token, ErrTokenExpired
+	}
+
+	token.Used = true
+	for h, t := range s.refreshTokens {
+		if !now.Before(t.ExpiresAt) {
+			delete(s.refreshTokens, h)
+		}
+	}
+	next.FamilyID, next.Username = token.FamilyID, token.Username
+	s.refreshTokens[next.Hash] = &next
+	return *token, nil
+}
+
+func (s *MemoryStore) RevokeRefreshFamily(family_id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeFamily(family_id)
+	return nil
+}
+
+func (s *MemoryStore) revokeFamily(family_id string) {
+	for _, token := range s.refreshTokens {
+		if token.FamilyID == family_id {
+			token.Revoked = true
+		}
+	}
+}
+
+func (s *MemoryStore) RevokeToken(jti string, expiresAt time.Time, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, exp := range s.revokedTokens {
+		if !exp.After(now) {
+			delete(s.revokedTokens, id)
+		}
+	}
+	s.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsTokenRevoked(jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revokedTokens[jti]
+	return ok, nil
+}
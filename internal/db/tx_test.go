@@ -0,0 +1,98 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+var errCrash = errors.New("injected crash")
+
+// openCrashStore открывает файловую базу и настраивает сбой на шаге step.
+func openCrashStore(t *testing.T, file, step string) *SQLiteStore {
+	s, err := OpenSQLite(file)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	s.failpoint = func(at string) error {
+		if at == step {
+			return errCrash
+		}
+		return nil
+	}
+	return s
+}
+
+// reopen закрывает хранилище и открывает ту же базу заново, как после перезапуска.
+func reopen(t *testing.T, s *SQLiteStore, file string) *SQLiteStore {
+	s.Close()
+	s, err := OpenSQLite(file)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestCreateExpression_CrashLeavesNoOrphans(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.db")
+	s := openCrashStore(t, file, "create_expression")
+
+	expr := Expression{ExprID: "x", Username: "u", Status: "processing", RootNodeID: "x-mul"}
+	if err := s.CreateExpression(expr, conformanceNodes("x")); !errors.Is(err, errCrash) {
+		t.Fatalf("expected injected crash, got %v", err)
+	}
+
+	s = reopen(t, s, file)
+	if _, err := s.SelectExpression("x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("orphan expression left after crash: %v", err)
+	}
+	if _, err := s.SelectNode("x-add"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("orphan node left after crash: %v", err)
+	}
+}
+
+func TestCompleteNode_CrashRollsBack(t *testing.T) {
+	for _, step := range []string{"node_result", "expression_result"} {
+		t.Run(step, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "store.db")
+			s := openCrashStore(t, file, step)
+
+			nodes := conformanceNodes("x")[:3]
+			expr := Expression{ExprID: "x", Username: "u", Status: "processing", RootNodeID: "x-add"}
+			if err := s.CreateExpression(expr, nodes); err != nil {
+				t.Fatalf("CreateExpression: %v", err)
+			}
+			if _, err := s.ClaimTask(); err != nil {
+				t.Fatalf("ClaimTask: %v", err)
+			}
+
+			if _, err := s.CompleteNode("x-add", 5); !errors.Is(err, errCrash) {
+				t.Fatalf("expected injected crash, got %v", err)
+			}
+
+			s = reopen(t, s, file)
+			node, err := s.SelectNode("x-add")
+			if err != nil {
+				t.Fatalf("root node lost after crash: %v", err)
+			}
+			if node.Status != "in_progress" {
+				t.Errorf("expected node to stay in_progress, got %s", node.Status)
+			}
+			e, _ := s.SelectExpression("x")
+			if e.Status != "processing" {
+				t.Errorf("expected expression to stay processing, got %s", e.Status)
+			}
+
+			// Повтор после перезапуска доводит выражение до конца.
+			finished, err := s.CompleteNode("x-add", 5)
+			if err != nil || !finished {
+				t.Fatalf("retry CompleteNode: finished=%v err=%v", finished, err)
+			}
+			e, _ = s.SelectExpression("x")
+			if e.Status != "done" || e.Result != 5 {
+				t.Errorf("unexpected expression after retry: %+v", e)
+			}
+		})
+	}
+}
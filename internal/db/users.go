@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"slices"
+	"sort"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+func (s *SQLiteStore) SelectUsers() ([]User, error) {
+	rows, err := s.db.QueryContext(s.ctx, "SELECT id, name, role, disabled FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Role, &user.Disabled); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *SQLiteStore) SetUserRole(name, role string) error {
+	result, err := s.db.ExecContext(s.ctx, "UPDATE users SET role=$1 WHERE name=$2", role, name)
+	return affected(result, err)
+}
+
+func (s *SQLiteStore) SetUserDisabled(name string, disabled bool) error {
+	result, err := s.db.ExecContext(s.ctx, "UPDATE users SET disabled=$1 WHERE name=$2", disabled, name)
+	return affected(result, err)
+}
+
+// affected возвращает ErrNotFound, если запрос не изменил ни одной строки.
+func affected(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deleteUserData удаляет выражения пользователя вместе с узлами,
+// его API ключи и refresh токены.
+func (s *SQLiteStore) deleteUserData(tx *sql.Tx, name string) error {
+	queries := []string{
+		"DELETE FROM nodes WHERE expr_id IN (SELECT expr_id FROM expressions WHERE username=$1)",
+		"DELETE FROM expressions WHERE username=$1",
+		"DELETE FROM api_keys WHERE username=$1",
+		"DELETE FROM refresh_tokens WHERE username=$1",
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(s.ctx, q, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeExpressions удаляет завершённые выражения пользователя username
+// (всех пользователей, если username пуст) вместе с узлами.
+// Выражения в обработке не трогаются.
+func (s *SQLiteStore) PurgeExpressions(username string) (int64, error) {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const match = `status != "processing" AND ($1 = "" OR username = $1)`
+	_, err = tx.ExecContext(s.ctx, "DELETE FROM nodes WHERE expr_id IN (SELECT expr_id FROM expressions WHERE "+match+")", username)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(s.ctx, "DELETE FROM expressions WHERE "+match, username)
+	if err != nil {
+		return 0, err
+	}
+	num, _ := result.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.logger.Debug("DB: Expressions purged", "count", num)
+	return num, nil
+}
+
+func (s *MemoryStore) SelectUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		user.Password = ""
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *MemoryStore) SetUserRole(name, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Role = role
+	s.users[name] = user
+	return nil
+}
+
+func (s *MemoryStore) SetUserDisabled(name string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Disabled = disabled
+	s.users[name] = user
+	return nil
+}
+
+func (s *MemoryStore) deleteUserData(name string) {
+	for _, id := range slices.Clone(s.exprOrder) {
+		if s.exprs[id].Username == name {
+			s.deleteExpression(id)
+		}
+	}
+	s.apiKeys = slices.DeleteFunc(s.apiKeys, func(k *APIKey) bool { return k.Username == name })
+	for hash, token := range s.refreshTokens {
+		if token.Username == name {
+			delete(s.refreshTokens, hash)
+		}
+	}
+}
+
+func (s *MemoryStore) PurgeExpressions(username string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var num int64
+	for _, id := range slices.Clone(s.exprOrder) {
+		expr := s.exprs[id]
+		if expr.Status != "processing" && (username == "" || expr.Username == username) {
+			s.deleteExpression(id)
+			num++
+		}
+	}
+	return num, nil
+}
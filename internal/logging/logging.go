@@ -0,0 +1,72 @@
+// Package logging настраивает log/slog для оркестратора и агента.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Ключи атрибутов, общие для оркестратора, агента и хранилища.
+const (
+	ExprID    = "expr_id"
+	NodeID    = "node_id"
+	User      = "user"
+	AgentID   = "agent_id"
+	RequestID = "request_id"
+	TraceID   = "trace_id"
+)
+
+// New создаёт логгер, который пишет в w в формате format ("text" или
+// "json") сообщения не ниже уровня level ("debug", "info", "warn", "error").
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// FromEnv создаёт логгер в stderr по LOG_FORMAT и LOG_LEVEL. При неверных
+// значениях используется текстовый формат и уровень info.
+func FromEnv() *slog.Logger {
+	logger, err := New(os.Stderr, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		logger, _ = New(os.Stderr, "text", "info")
+		logger.Warn("Invalid logging settings, using text output at info level", "error", err)
+	}
+	return logger
+}
+
+// Discard возвращает логгер, который ничего не пишет.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+type loggerKey struct{}
+
+// WithContext сохраняет в ctx логгер запроса.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext возвращает логгер запроса или fallback, если его нет.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
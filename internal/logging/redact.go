@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted заменяет в логах скрытые значения.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys - части имён атрибутов, значения которых не пишутся никогда.
+var sensitiveKeys = []string{"password", "token", "secret", "bearer", "authorization", "cookie"}
+
+// jwtPattern находит JWT в строках, например в тексте ошибки.
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+type redactHandler struct {
+	next    slog.Handler
+	secrets []*regexp.Regexp
+}
+
+// Redact оборачивает next так, что значения атрибутов с именами вроде
+// password или token заменяются на Redacted, а JWT и строки, подходящие
+// под secrets, вырезаются из сообщений, строк и текстов ошибок.
+func Redact(next slog.Handler, secrets ...*regexp.Regexp) slog.Handler {
+	return &redactHandler{next: next, secrets: append([]*regexp.Regexp{jwtPattern}, secrets...)}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.scrub(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &redactHandler{next: h.next.WithAttrs(h.attrs(attrs)), secrets: h.secrets}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name), secrets: h.secrets}
+}
+
+func (h *redactHandler) attrs(attrs []slog.Attr) []slog.Attr {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = h.attr(a)
+	}
+	return clean
+}
+
+func (h *redactHandler) attr(a slog.Attr) slog.Attr {
+	if sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.scrub(v.String()))
+	case slog.KindGroup:
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(h.attrs(v.Group())...)}
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, h.scrub(err.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+func (h *redactHandler) scrub(s string) string {
+	for _, re := range h.secrets {
+		s = re.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeys {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
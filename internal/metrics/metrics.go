@@ -0,0 +1,289 @@
+// Package metrics - счётчики, показатели и гистограммы с метками,
+// которые отдаются в текстовом формате Prometheus без внешних зависимостей.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets - границы гистограммы по-умолчанию, в секундах.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry хранит метрики и выводит их в порядке регистрации.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// NewCounter регистрирует счётчик с метками labels.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{values: newValues(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// NewGauge регистрирует показатель с метками labels.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{values: newValues(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogram регистрирует гистограмму с возрастающими границами buckets;
+// nil - DefaultBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// Write выводит все метрики в текстовом формате Prometheus.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler отдаёт метрики по HTTP.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) writeHeader(w io.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.typ)
+}
+
+// key проверяет число значений меток и склеивает их в ключ серии.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// values - серии счётчика или показателя.
+type values struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+}
+
+func newValues(name, help, typ string, labels []string) *values {
+	return &values{desc: desc{name: name, help: help, typ: typ, labels: labels}, series: make(map[string]*series)}
+}
+
+func (v *values) update(labels []string, fn func(float64) float64) {
+	key := v.key(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labels...)}
+		v.series[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+// Value возвращает значение серии; 0, если её ещё нет.
+func (v *values) Value(labels ...string) float64 {
+	key := v.key(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *values) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w)
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labels, ""), formatFloat(s.value))
+	}
+}
+
+// Counter - монотонно растущий счётчик.
+type Counter struct {
+	*values
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add увеличивает счётчик на delta >= 0.
+func (c *Counter) Add(delta float64, labels ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.name))
+	}
+	c.update(labels, func(v float64) float64 { return v + delta })
+}
+
+// Gauge - значение, которое может как расти, так и уменьшаться.
+type Gauge struct {
+	*values
+}
+
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.update(labels, func(float64) float64 { return value })
+}
+
+func (g *Gauge) Add(delta float64, labels ...string) {
+	g.update(labels, func(v float64) float64 { return v + delta })
+}
+
+// Histogram считает наблюдения по интервалам.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	// counts[i] - число наблюдений в интервале (buckets[i-1], buckets[i]].
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count возвращает число наблюдений серии.
+func (h *Histogram) Count(labels ...string) uint64 {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels, ""), s.count)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels выводит метки серии; le - граница интервала гистограммы.
+func formatLabels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	if le != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `le="%s"`, le)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
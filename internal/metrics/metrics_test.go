@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_TextFormat(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests served.", "route", "code")
+	g := r.NewGauge("queue_depth", "Queued items.")
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+
+	c.Inc("/b", "200")
+	c.Add(2, "/a", "500")
+	c.Inc("/b", "200")
+	g.Set(7)
+	g.Add(-2)
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(3)
+
+	var b strings.Builder
+	r.Write(&b)
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a",code="500"} 2
+requests_total{route="/b",code="200"} 2
+# HELP queue_depth Queued items.
+# TYPE queue_depth gauge
+queue_depth 5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.15
+latency_seconds_count 3
+`
+	if b.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+	if c.Value("/b", "200") != 2 || h.Count() != 3 {
+		t.Errorf("Unexpected values: %v %v", c.Value("/b", "200"), h.Count())
+	}
+}
+
+func TestRegistry_EscapesLabels(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("c", "Line one\nline two.", "v").Inc("a\"b\\c\nd")
+
+	var b strings.Builder
+	r.Write(&b)
+	if !strings.Contains(b.String(), `# HELP c Line one\nline two.`) {
+		t.Errorf("Help is not escaped: %s", b.String())
+	}
+	if !strings.Contains(b.String(), `c{v="a\"b\\c\nd"} 1`) {
+		t.Errorf("Label value is not escaped: %s", b.String())
+	}
+}
+
+func TestRegistry_LabelCountMismatchPanics(t *testing.T) {
+	c := NewRegistry().NewCounter("c", "c", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on wrong number of label values")
+		}
+	}()
+	c.Inc("only-one")
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("hits_total", "Hits.").Inc()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "hits_total 1\n") {
+		t.Errorf("Unexpected body: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,420 @@
+package scheduler
+
+import (
+	"container/heap"
+	"slices"
+	"time"
+)
+
+// DefaultMaxBoost - на сколько позиций по-умолчанию узел критического пути
+// может обогнать более ранние узлы того же пользователя.
+const DefaultMaxBoost = 8
+
+// DefaultStarvationLimit - сколько узлов по-умолчанию может быть выдано
+// подряд из более высокого приоритета, пока ждёт более низкий.
+const DefaultStarvationLimit = 16
+
+// Priority - приоритет выражения. Нулевое значение - обычный приоритет.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// levels - число приоритетов; индекс уровня - Priority-PriorityLow.
+const levels = int(PriorityHigh-PriorityLow) + 1
+
+// quantum - виртуальное время одного обслуживания пользователя с весом 1.
+// Делится нацело на веса 1..16, поэтому теги считаются без погрешности.
+const quantum = 720720
+
+// Item - готовый к вычислению узел.
+type Item struct {
+	NodeID string
+	ExprID string
+	User   string
+	// Depth - число операций над узлом до корня. Чем глубже узел,
+	// тем длиннее цепочка, которую он задерживает.
+	Depth    int
+	Priority Priority
+	// Deadline - срок выражения; нулевой, если срока нет.
+	Deadline time.Time
+}
+
+type Config struct {
+	// Weight возвращает вес пользователя: при постоянной нагрузке доли
+	// выданных задач пропорциональны весам. nil или вес <= 0 означает 1.
+	Weight func(user string) int
+	// MaxBoost ограничивает обгон по критическому пути; 0 - DefaultMaxBoost,
+	// меньше 0 - узлы пользователя выдаются строго по очереди.
+	MaxBoost int
+	// StarvationLimit - 0 означает DefaultStarvationLimit.
+	StarvationLimit int
+}
+
+// Scheduler решает, какой готовый узел отдать агенту следующим.
+//
+// Сначала выдаются узлы с более высоким приоритетом. Чтобы низкий
+// приоритет не голодал, после StarvationLimit выдач подряд в обход
+// ожидающего уровня один узел выдаётся с него.
+//
+// Внутри приоритета между пользователями используется справедливая
+// очередь с виртуальным временем (start-time fair queuing): каждый
+// пользователь получает долю, пропорциональную весу, независимо от размера
+// его очереди, а вернувшийся после простоя пользователь не получает
+// накопленного "кредита".
+//
+// Узлы со сроком идут внутри приоритета по отдельной полосе: первым
+// выдаётся узел с ближайшим сроком, чей бы он ни был (earliest deadline
+// first). Выдача по сроку засчитывается пользователю в справедливой
+// очереди, а после StarvationLimit таких выдач подряд один узел выдаётся
+// по справедливой очереди, так что сроки не останавливают остальных.
+//
+// Внутри пользователя сначала идут узлы выражений с ближайшим сроком,
+// затем без срока. Дальше узлы идут в порядке поступления, но узел
+// глубиной d может обогнать не более min(d, MaxBoost) более ранних узлов.
+// Поэтому критический путь сокращается, а ожидание любого узла ограничено
+// (узлы со сроком обгоняют остальные только до истечения срока).
+//
+// Scheduler не безопасен для конкурентного использования.
+type Scheduler struct {
+	weight          func(user string) int
+	maxBoost        int
+	starvationLimit int
+
+	classes [levels]*class
+	skipped [levels]int
+	items   map[string]*entry
+	pushed  int64
+}
+
+// class - справедливая очередь пользователей одного приоритета.
+type class struct {
+	users  map[string]*userQueue
+	active userHeap
+	vtime  int64
+	ticket int64
+	// deadlines - узлы со сроком всех пользователей, ближайший срок первым.
+	deadlines deadlineHeap
+	// urgent - сколько узлов подряд выдано по сроку.
+	urgent int
+}
+
+type userQueue struct {
+	name    string
+	entries entryHeap
+	seq     int64
+	// start - виртуальное время следующего обслуживания, finish - конец последнего.
+	start  int64
+	finish int64
+	ticket int64
+	index  int
+}
+
+type entry struct {
+	item  Item
+	key   int64
+	seq   int64
+	class *class
+	queue *userQueue
+	index int
+	// pushed - порядок поступления среди всех узлов, для равных сроков.
+	pushed int64
+	// urgentIndex - место в class.deadlines, -1 для узлов без срока.
+	urgentIndex int
+}
+
+func New(config Config) *Scheduler {
+	s := &Scheduler{
+		weight:          config.Weight,
+		maxBoost:        config.MaxBoost,
+		starvationLimit: config.StarvationLimit,
+		items:           make(map[string]*entry),
+	}
+	for i := range s.classes {
+		s.classes[i] = &class{users: make(map[string]*userQueue)}
+	}
+	if s.maxBoost == 0 {
+		s.maxBoost = DefaultMaxBoost
+	}
+	if s.maxBoost < 0 {
+		s.maxBoost = 0
+	}
+	if s.starvationLimit <= 0 {
+		s.starvationLimit = DefaultStarvationLimit
+	}
+	return s
+}
+
+func level(p Priority) int {
+	return int(min(max(p, PriorityLow), PriorityHigh) - PriorityLow)
+}
+
+// Push добавляет узел в очередь. Повторное добавление узла игнорируется.
+func (s *Scheduler) Push(item Item) {
+	if _, ok := s.items[item.NodeID]; ok {
+		return
+	}
+	c := s.classes[level(item.Priority)]
+	q, ok := c.users[item.User]
+	if !ok {
+		q = &userQueue{name: item.User, index: -1}
+		c.users[item.User] = q
+	}
+	if len(q.entries) == 0 {
+		c.activate(q)
+	}
+
+	q.seq++
+	s.pushed++
+	e := &entry{item: item, seq: q.seq, class: c, queue: q, pushed: s.pushed, urgentIndex: -1}
+	e.key = q.seq - int64(min(max(item.Depth, 0), s.maxBoost))
+	heap.Push(&q.entries, e)
+	if !item.Deadline.IsZero() {
+		heap.Push(&c.deadlines, e)
+	}
+	s.items[item.NodeID] = e
+}
+
+// Pop возвращает следующий узел; false, если очередь пуста.
+func (s *Scheduler) Pop() (Item, bool) {
+	chosen := -1
+	for i := levels - 1; i >= 0; i-- {
+		if len(s.classes[i].active) == 0 {
+			continue
+		}
+		// Самый высокий ожидающий уровень, либо уровень, который
+		// пропускали слишком долго.
+		if chosen < 0 || s.skipped[i] >= s.starvationLimit {
+			chosen = i
+			if s.skipped[i] >= s.starvationLimit {
+				break
+			}
+		}
+	}
+	if chosen < 0 {
+		return Item{}, false
+	}
+	s.skipped[chosen] = 0
+	for i := 0; i < chosen; i++ {
+		if len(s.classes[i].active) > 0 {
+			s.skipped[i]++
+		}
+	}
+
+	c := s.classes[chosen]
+	if len(c.deadlines) > 0 && c.urgent < s.starvationLimit {
+		c.urgent++
+		e := c.deadlines[0]
+		// Выдача вне очереди оплачивается так же, как обычная: следующее
+		// обслуживание пользователя сдвигается на его квант.
+		q := e.queue
+		q.start += quantum / int64(s.weightOf(q.name))
+		q.finish = q.start
+		heap.Fix(&c.active, q.index)
+		s.remove(e)
+		return e.item, true
+	}
+	c.urgent = 0
+
+	q := c.active[0]
+	e := heap.Pop(&q.entries).(*entry)
+	if e.urgentIndex >= 0 {
+		heap.Remove(&c.deadlines, e.urgentIndex)
+	}
+	delete(s.items, e.item.NodeID)
+
+	c.vtime = q.start
+	q.finish = q.start + quantum/int64(s.weightOf(q.name))
+	if len(q.entries) == 0 {
+		heap.Remove(&c.active, q.index)
+		c.forget(q)
+	} else {
+		q.start = q.finish
+		c.ticket++
+		q.ticket = c.ticket
+		heap.Fix(&c.active, q.index)
+	}
+	return e.item, true
+}
+
+// RemoveNode удаляет узел nodeID, если он стоит в очереди.
+func (s *Scheduler) RemoveNode(nodeID string) {
+	if e, ok := s.items[nodeID]; ok {
+		s.remove(e)
+	}
+}
+
+func (s *Scheduler) remove(e *entry) {
+	c, q := e.class, e.queue
+	heap.Remove(&q.entries, e.index)
+	if e.urgentIndex >= 0 {
+		heap.Remove(&c.deadlines, e.urgentIndex)
+	}
+	delete(s.items, e.item.NodeID)
+	if len(q.entries) == 0 {
+		heap.Remove(&c.active, q.index)
+		c.forget(q)
+	}
+}
+
+// Contains сообщает, стоит ли узел в очереди.
+func (s *Scheduler) Contains(nodeID string) bool {
+	_, ok := s.items[nodeID]
+	return ok
+}
+
+// Len возвращает число узлов в очереди.
+func (s *Scheduler) Len() int {
+	return len(s.items)
+}
+
+// Snapshot возвращает узлы очереди для диагностики: по убыванию
+// приоритета, внутри приоритета - по именам пользователей, а узлы одного
+// пользователя - в порядке их выдачи.
+func (s *Scheduler) Snapshot() []Item {
+	items := make([]Item, 0, len(s.items))
+	for i := levels - 1; i >= 0; i-- {
+		c := s.classes[i]
+		users := make([]string, 0, len(c.users))
+		for name, q := range c.users {
+			if len(q.entries) > 0 {
+				users = append(users, name)
+			}
+		}
+		slices.Sort(users)
+		for _, name := range users {
+			// Сортируется копия без Swap, чтобы не сбить индексы кучи.
+			entries := slices.Clone(c.users[name].entries)
+			slices.SortFunc(entries, func(a, b *entry) int {
+				if a.before(b) {
+					return -1
+				}
+				return 1
+			})
+			for _, e := range entries {
+				items = append(items, e.item)
+			}
+		}
+	}
+	return items
+}
+
+// activate ставит простаивавшего пользователя в очередь. Начало его
+// обслуживания не раньше текущего виртуального времени, так что простой
+// не даёт права на внеочередную выдачу.
+func (c *class) activate(q *userQueue) {
+	q.start = max(c.vtime, q.finish)
+	c.ticket++
+	q.ticket = c.ticket
+	heap.Push(&c.active, q)
+}
+
+// forget удаляет опустевшую очередь пользователя, если её теги уже ничего
+// не решают: при следующей активации начало всё равно будет не раньше vtime.
+func (c *class) forget(q *userQueue) {
+	if q.finish <= c.vtime {
+		delete(c.users, q.name)
+	}
+}
+
+func (s *Scheduler) weightOf(user string) int {
+	if s.weight == nil {
+		return 1
+	}
+	return max(s.weight(user), 1)
+}
+
+type entryHeap []*entry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+
+// before сообщает, выдаётся ли e раньше o того же пользователя.
+func (e *entry) before(o *entry) bool {
+	if de, do := e.item.Deadline, o.item.Deadline; !de.Equal(do) {
+		// Нулевой срок означает его отсутствие: такие узлы идут последними.
+		return do.IsZero() || !de.IsZero() && de.Before(do)
+	}
+	if e.key != o.key {
+		return e.key < o.key
+	}
+	return e.seq < o.seq
+}
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *entryHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *entryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// deadlineHeap упорядочивает узлы со сроком всех пользователей класса.
+type deadlineHeap []*entry
+
+func (h deadlineHeap) Len() int { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool {
+	if di, dj := h[i].item.Deadline, h[j].item.Deadline; !di.Equal(dj) {
+		return di.Before(dj)
+	}
+	return h[i].pushed < h[j].pushed
+}
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].urgentIndex = i
+	h[j].urgentIndex = j
+}
+func (h *deadlineHeap) Push(x any) {
+	e := x.(*entry)
+	e.urgentIndex = len(*h)
+	*h = append(*h, e)
+}
+func (h *deadlineHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.urgentIndex = -1
+	return e
+}
+
+type userHeap []*userQueue
+
+func (h userHeap) Len() int { return len(h) }
+func (h userHeap) Less(i, j int) bool {
+	if h[i].start != h[j].start {
+		return h[i].start < h[j].start
+	}
+	return h[i].ticket < h[j].ticket
+}
+func (h userHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *userHeap) Push(x any) {
+	q := x.(*userQueue)
+	q.index = len(*h)
+	*h = append(*h, q)
+}
+func (h *userHeap) Pop() any {
+	old := *h
+	q := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	q.index = -1
+	return q
+}
@@ -0,0 +1,278 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func push(s *Scheduler, user string, n, depth int) {
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("%s-%d-%d", user, depth, s.Len())
+		s.Push(Item{NodeID: id, ExprID: user, User: user, Depth: depth})
+	}
+}
+
+// served возвращает пользователей первых n выданных узлов.
+func served(s *Scheduler, n int) []string {
+	var users []string
+	for i := 0; i < n; i++ {
+		item, ok := s.Pop()
+		if !ok {
+			break
+		}
+		users = append(users, item.User)
+	}
+	return users
+}
+
+func count(users []string, user string) int {
+	n := 0
+	for _, u := range users {
+		if u == user {
+			n++
+		}
+	}
+	return n
+}
+
+func TestScheduler_SharesEquallyRegardlessOfBacklog(t *testing.T) {
+	s := New(Config{})
+	push(s, "heavy", 1000, 0)
+	push(s, "light", 10, 0)
+
+	got := served(s, 20)
+	if n := count(got, "light"); n != 10 {
+		t.Fatalf("Expected light user to get half of the first 20 tasks, got %d: %v", n, got)
+	}
+	for i := 0; i < len(got); i += 2 {
+		if got[i] == got[i+1] {
+			t.Fatalf("Expected users to alternate, got %v", got)
+		}
+	}
+}
+
+func TestScheduler_RespectsWeights(t *testing.T) {
+	weights := map[string]int{"gold": 3}
+	s := New(Config{Weight: func(user string) int { return weights[user] }})
+	push(s, "gold", 100, 0)
+	push(s, "free", 100, 0)
+
+	got := served(s, 40)
+	if n := count(got, "gold"); n != 30 {
+		t.Errorf("Expected gold to get 30 of 40 tasks, got %d: %v", n, got)
+	}
+}
+
+func TestScheduler_IdleUserGetsNoCredit(t *testing.T) {
+	s := New(Config{})
+	push(s, "a", 200, 0)
+	served(s, 100)
+
+	// b простаивал, пока обслуживали a, но не должен получить 100 задач подряд.
+	push(s, "b", 100, 0)
+	got := served(s, 20)
+	if n := count(got, "b"); n < 9 || n > 11 {
+		t.Errorf("Expected b to get about half of the tasks after joining, got %d: %v", n, got)
+	}
+}
+
+func TestScheduler_PrefersCriticalPath(t *testing.T) {
+	s := New(Config{})
+	s.Push(Item{NodeID: "shallow", User: "u", Depth: 0})
+	s.Push(Item{NodeID: "deep", User: "u", Depth: 5})
+	s.Push(Item{NodeID: "middle", User: "u", Depth: 3})
+
+	var order []string
+	for s.Len() > 0 {
+		item, _ := s.Pop()
+		order = append(order, item.NodeID)
+	}
+	if fmt.Sprint(order) != "[deep middle shallow]" {
+		t.Errorf("Expected deeper nodes first, got %v", order)
+	}
+}
+
+func TestScheduler_BoundedWait(t *testing.T) {
+	const boost = 4
+	s := New(Config{MaxBoost: boost})
+	s.Push(Item{NodeID: "victim", User: "u", Depth: 0})
+	for i := 0; i < 3; i++ {
+		s.Push(Item{NodeID: fmt.Sprintf("other-%d", i), User: "other"})
+	}
+
+	// Пользователь u непрерывно добавляет узлы с критического пути,
+	// other тоже постоянно занят. Узел victim всё равно должен быть выдан.
+	for pops := 1; pops <= 100; pops++ {
+		s.Push(Item{NodeID: fmt.Sprintf("deep-%d", pops), User: "u", Depth: 100})
+		s.Push(Item{NodeID: fmt.Sprintf("other-x%d", pops), User: "other"})
+		item, _ := s.Pop()
+		if item.NodeID == "victim" {
+			// Не более boost обгонов своего пользователя, и столько же
+			// выдач other между соседними выдачами u.
+			if limit := 2*boost + 1; pops > limit {
+				t.Errorf("victim waited %d pops, expected at most %d", pops, limit)
+			}
+			return
+		}
+	}
+	t.Fatal("victim was starved")
+}
+
+func TestScheduler_RemoveAndContains(t *testing.T) {
+	s := New(Config{})
+	s.Push(Item{NodeID: "a1", ExprID: "a", User: "u"})
+	s.Push(Item{NodeID: "a1", ExprID: "a", User: "u"})
+	s.Push(Item{NodeID: "b1", ExprID: "b", User: "u"})
+	s.Push(Item{NodeID: "a2", ExprID: "a", User: "v"})
+	if s.Len() != 3 || !s.Contains("a1") {
+		t.Fatalf("Expected 3 queued items with a1, got %d", s.Len())
+	}
+
+	s.RemoveNode("a1")
+	s.RemoveNode("a2")
+	if s.Contains("a1") || s.Contains("a2") || s.Len() != 1 {
+		t.Fatalf("Expected only b1 after RemoveNode, got %d items", s.Len())
+	}
+	s.Push(Item{NodeID: "b2", ExprID: "b", User: "v"})
+	s.RemoveNode("b2")
+	s.RemoveNode("missing")
+	if item, ok := s.Pop(); !ok || item.NodeID != "b1" {
+		t.Errorf("Expected b1, got %+v %v", item, ok)
+	}
+	if _, ok := s.Pop(); ok {
+		t.Error("Expected empty scheduler")
+	}
+}
+
+func TestScheduler_PriorityOrder(t *testing.T) {
+	s := New(Config{})
+	s.Push(Item{NodeID: "low", User: "batch", Priority: PriorityLow})
+	s.Push(Item{NodeID: "normal", User: "batch"})
+	s.Push(Item{NodeID: "high", User: "ui", Priority: PriorityHigh})
+
+	var order []string
+	for s.Len() > 0 {
+		item, _ := s.Pop()
+		order = append(order, item.NodeID)
+	}
+	if fmt.Sprint(order) != "[high normal low]" {
+		t.Errorf("Expected order by priority, got %v", order)
+	}
+}
+
+func TestScheduler_Snapshot(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := New(Config{})
+	s.Push(Item{NodeID: "v1", User: "v"})
+	s.Push(Item{NodeID: "u1", User: "u"})
+	s.Push(Item{NodeID: "u2", User: "u", Deadline: now.Add(time.Minute)})
+	s.Push(Item{NodeID: "u3", User: "u", Depth: 100})
+	s.Push(Item{NodeID: "low", User: "a", Priority: PriorityLow})
+	s.Push(Item{NodeID: "high", User: "z", Priority: PriorityHigh})
+
+	var ids []string
+	for _, item := range s.Snapshot() {
+		ids = append(ids, item.NodeID)
+	}
+	if fmt.Sprint(ids) != "[high u2 u3 u1 v1 low]" {
+		t.Errorf("Unexpected snapshot order: %v", ids)
+	}
+	// Снимок не меняет очередь.
+	if item, _ := s.Pop(); item.NodeID != "high" || s.Len() != 5 {
+		t.Errorf("Snapshot changed the queue: %+v, %d left", item, s.Len())
+	}
+	s.RemoveNode("u3")
+	var rest []string
+	for s.Len() > 0 {
+		item, _ := s.Pop()
+		rest = append(rest, item.NodeID)
+	}
+	if len(rest) != 4 || count(rest, "u3") != 0 {
+		t.Errorf("Unexpected items after RemoveNode: %v", rest)
+	}
+}
+
+func TestScheduler_EarliestDeadlineFirst(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := New(Config{})
+	s.Push(Item{NodeID: "none", User: "u"})
+	s.Push(Item{NodeID: "late", User: "u", Deadline: now.Add(time.Hour)})
+	s.Push(Item{NodeID: "soon", User: "u", Deadline: now.Add(time.Minute)})
+	s.Push(Item{NodeID: "deep", User: "u", Depth: 100})
+
+	var order []string
+	for s.Len() > 0 {
+		item, _ := s.Pop()
+		order = append(order, item.NodeID)
+	}
+	if fmt.Sprint(order) != "[soon late deep none]" {
+		t.Errorf("Expected nodes with closer deadlines first, got %v", order)
+	}
+}
+
+func TestScheduler_DeadlineAcrossUsers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := New(Config{})
+	push(s, "busy", 10, 0)
+	s.Push(Item{NodeID: "late", User: "a", Deadline: now.Add(time.Hour)})
+	s.Push(Item{NodeID: "soon", User: "b", Deadline: now.Add(time.Minute)})
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		item, _ := s.Pop()
+		order = append(order, item.NodeID)
+	}
+	if fmt.Sprint(order[:2]) != "[soon late]" {
+		t.Errorf("Expected nodes with deadlines ahead of other users, closer first, got %v", order)
+	}
+
+	// Выдача по сроку засчитывается пользователю: b снова в очереди,
+	// но busy, не получавший ничего, идёт раньше.
+	push(s, "b", 2, 0)
+	got := served(s, 3)
+	if got[0] != "busy" || count(got, "b") != 1 {
+		t.Errorf("Expected deadline service to be charged to the user, got %v", got)
+	}
+}
+
+func TestScheduler_DeadlineLaneBounded(t *testing.T) {
+	const limit = 4
+	now := time.Unix(1700000000, 0)
+	s := New(Config{StarvationLimit: limit})
+	s.Push(Item{NodeID: "plain", User: "other"})
+	for i := 0; i < 100; i++ {
+		s.Push(Item{NodeID: fmt.Sprint("urgent-", i), User: "hurry", Deadline: now.Add(time.Duration(i) * time.Second)})
+	}
+
+	for pops := 1; pops <= 100; pops++ {
+		if item, _ := s.Pop(); item.NodeID == "plain" {
+			if pops > limit+1 {
+				t.Errorf("plain waited %d pops, expected at most %d", pops, limit+1)
+			}
+			return
+		}
+	}
+	t.Fatal("node without deadline was starved")
+}
+
+func TestScheduler_LowPriorityNotStarved(t *testing.T) {
+	const limit = 5
+	s := New(Config{StarvationLimit: limit})
+	s.Push(Item{NodeID: "low", User: "batch", Priority: PriorityLow})
+	s.Push(Item{NodeID: "normal", User: "batch"})
+
+	// Высокий приоритет поступает непрерывно.
+	for pops := 1; pops <= 100; pops++ {
+		s.Push(Item{NodeID: fmt.Sprintf("high-%d", pops), User: "ui", Priority: PriorityHigh})
+		item, _ := s.Pop()
+		if item.NodeID == "low" {
+			// Сначала дожидается normal, затем low.
+			if bound := 2*limit + 2; pops > bound {
+				t.Errorf("low waited %d pops, expected at most %d", pops, bound)
+			}
+			return
+		}
+	}
+	t.Fatal("low priority was starved")
+}
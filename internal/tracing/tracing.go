@@ -0,0 +1,266 @@
+// Package tracing связывает HTTP запрос, узлы выражения и вызовы агентов
+// общим trace ID в формате W3C Trace Context и при необходимости пишет
+// спаны в формате OTLP/JSON, который читает, например, приёмник
+// otlpjsonfile коллектора OpenTelemetry.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentKey - имя заголовка HTTP и ключ метаданных gRPC с контекстом трассировки.
+const TraceparentKey = "traceparent"
+
+// SpanContext - идентификаторы трассы и спана в hex.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// IsValid сообщает, заданы ли оба идентификатора.
+func (sc SpanContext) IsValid() bool {
+	return validID(sc.TraceID, 32) && validID(sc.SpanID, 16)
+}
+
+// Traceparent возвращает контекст в виде заголовка traceparent.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID + "-" + sc.SpanID + "-01"
+}
+
+// ParseTraceparent разбирает заголовок traceparent версии 00.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{TraceID: parts[1], SpanID: parts[2]}
+	return sc, sc.IsValid()
+}
+
+// validID проверяет длину и то, что идентификатор - ненулевой hex в нижнем регистре.
+func validID(id string, n int) bool {
+	if len(id) != n || strings.Trim(id, "0") == "" {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewTraceID возвращает новый идентификатор трассы.
+func NewTraceID() string { return randomHex(16) }
+
+// NewSpanID возвращает новый идентификатор спана.
+func NewSpanID() string { return randomHex(8) }
+
+type spanKey struct{}
+
+// ContextWithSpan сохраняет в ctx контекст текущего спана.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanFromContext возвращает контекст текущего спана или пустой.
+func SpanFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanKey{}).(SpanContext)
+	return sc
+}
+
+// Виды спанов OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Span - завершённая операция.
+type Span struct {
+	SpanContext
+	Parent string
+	Name   string
+	Kind   int
+	Start  time.Time
+	End    time.Time
+	// Attrs - атрибуты со значениями string, bool, int, int64 или float64.
+	Attrs map[string]any
+	// Err - ошибка операции; непустая помечает спан неуспешным.
+	Err string
+}
+
+// Exporter пишет спаны в w, по одному запросу OTLP/JSON на строку.
+// Nil *Exporter ничего не пишет, так что экспорт необязателен.
+type Exporter struct {
+	service string
+	mu      sync.Mutex
+	w       io.Writer
+	// closer - файл, открытый ExporterFromEnv; закрывается в Close.
+	closer io.Closer
+}
+
+// NewExporter создаёт экспортёр спанов сервиса service.
+func NewExporter(service string, w io.Writer) *Exporter {
+	return &Exporter{service: service, w: w}
+}
+
+// ExporterFromEnv создаёт экспортёр по TRACE_EXPORT: "stdout" или путь к
+// файлу, в который спаны дописываются. Пустое значение - экспорт выключен.
+func ExporterFromEnv(service string) (*Exporter, error) {
+	switch dest := os.Getenv("TRACE_EXPORT"); dest {
+	case "":
+		return nil, nil
+	case "stdout":
+		return NewExporter(service, os.Stdout), nil
+	default:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace export file: %w", err)
+		}
+		e := NewExporter(service, f)
+		e.closer = f
+		return e, nil
+	}
+}
+
+// Close закрывает файл экспорта, открытый ExporterFromEnv. Писатель,
+// переданный в NewExporter, не закрывается. Спаны после Close
+// отбрасываются.
+func (e *Exporter) Close() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.w = io.Discard
+	if e.closer == nil {
+		return nil
+	}
+	err := e.closer.Close()
+	e.closer = nil
+	return err
+}
+
+// Enabled сообщает, пишутся ли спаны.
+func (e *Exporter) Enabled() bool {
+	return e != nil
+}
+
+// Export пишет завершённый спан.
+func (e *Exporter) Export(span Span) error {
+	if e == nil {
+		return nil
+	}
+	b, err := json.Marshal(e.request(span))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+// Структуры OTLP/JSON: ExportTraceServiceRequest с одним спаном.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		// Code: 0 - не задан, 2 - ошибка.
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+func (e *Exporter) request(span Span) otlpRequest {
+	s := otlpSpan{
+		TraceID:      span.TraceID,
+		SpanID:       span.SpanID,
+		ParentSpanID: span.Parent,
+		Name:         span.Name,
+		Kind:         span.Kind,
+		Start:        strconv.FormatInt(span.Start.UnixNano(), 10),
+		End:          strconv.FormatInt(span.End.UnixNano(), 10),
+	}
+	for key, val := range span.Attrs {
+		s.Attributes = append(s.Attributes, attr(key, val))
+	}
+	// Порядок атрибутов map случаен, а вывод удобнее сравнивать стабильным.
+	slices.SortFunc(s.Attributes, func(a, b otlpAttr) int { return strings.Compare(a.Key, b.Key) })
+	if span.Err != "" {
+		s.Status = otlpStatus{Code: 2, Message: span.Err}
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{attr("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/saykoooo/calc_go"},
+			Spans: []otlpSpan{s},
+		}},
+	}}}
+}
+
+func attr(key string, val any) otlpAttr {
+	var v map[string]any
+	switch val := val.(type) {
+	case bool:
+		v = map[string]any{"boolValue": val}
+	case int:
+		v = map[string]any{"intValue": strconv.Itoa(val)}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(val, 10)}
+	case float64:
+		v = map[string]any{"doubleValue": val}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(val)}
+	}
+	return otlpAttr{Key: key, Value: v}
+}
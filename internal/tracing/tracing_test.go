@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || sc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID != "00f067aa0ba902b7" {
+		t.Fatalf("Unexpected context: %+v %v", sc, ok)
+	}
+	if sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Unexpected traceparent: %s", sc.Traceparent())
+	}
+	for _, bad := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+	} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if !(SpanContext{TraceID: NewTraceID(), SpanID: NewSpanID()}).IsValid() {
+		t.Error("Generated ids are not valid")
+	}
+}
+
+func TestSpanFromContext(t *testing.T) {
+	if SpanFromContext(context.Background()).IsValid() {
+		t.Error("Expected empty context")
+	}
+	sc := SpanContext{TraceID: NewTraceID(), SpanID: NewSpanID()}
+	if SpanFromContext(ContextWithSpan(context.Background(), sc)) != sc {
+		t.Error("Context lost")
+	}
+}
+
+func TestExporter_OTLPJSON(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewExporter("calc-test", &buf)
+	start := time.Unix(1_700_000_000, 5)
+	err := e.Export(Span{
+		SpanContext: SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"},
+		Parent:      "1111111111111111",
+		Name:        "task +",
+		Kind:        KindInternal,
+		Start:       start,
+		End:         start.Add(time.Second),
+		Attrs:       map[string]any{"node_id": "n-1", "depth": 2, "ok": true},
+		Err:         "division by zero",
+	})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	want := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"calc-test"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/saykoooo/calc_go"},"spans":[{"traceId":"4bf92f3577b34da6a3ce929d0e0e4736",` +
+		`"spanId":"00f067aa0ba902b7","parentSpanId":"1111111111111111","name":"task +","kind":1,` +
+		`"startTimeUnixNano":"1700000000000000005","endTimeUnixNano":"1700000001000000005",` +
+		`"attributes":[{"key":"depth","value":{"intValue":"2"}},{"key":"node_id","value":{"stringValue":"n-1"}},{"key":"ok","value":{"boolValue":true}}],` +
+		`"status":{"code":2,"message":"division by zero"}}]}]}]}` + "\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if !json.Valid(bytes.TrimSpace(buf.Bytes())) {
+		t.Error("Output is not valid JSON")
+	}
+
+	var disabled *Exporter
+	if disabled.Enabled() || disabled.Export(Span{}) != nil {
+		t.Error("Expected nil exporter to be a no-op")
+	}
+}
+
+func TestExporterFromEnv_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	t.Setenv("TRACE_EXPORT", path)
+	e, err := ExporterFromEnv("calc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := Span{SpanContext: SpanContext{TraceID: NewTraceID(), SpanID: NewSpanID()}, Name: "op"}
+	if err := e.Export(span); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// Спаны, завершившиеся после остановки, отбрасываются без ошибок.
+	if err := e.Export(span); err != nil {
+		t.Errorf("Expected export after Close to be dropped, got %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Expected repeated Close to succeed, got %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if n := bytes.Count(data, []byte("\n")); n != 1 {
+		t.Errorf("Expected 1 exported span, got %d", n)
+	}
+
+	var nilExporter *Exporter
+	if err := nilExporter.Close(); err != nil {
+		t.Errorf("Expected nil exporter to close, got %v", err)
+	}
+}
@@ -137,6 +137,7 @@ type ResultRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Result        float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -185,6 +186,13 @@ func (x *ResultRequest) GetResult() float64 {
 	return 0
 }
 
+func (x *ResultRequest) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 type SubmitResultResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -232,10 +240,11 @@ const file_proto_orchestrator_proto_rawDesc = "" +
 	"\x04arg1\x18\x02 \x01(\x01R\x04arg1\x12\x12\n" +
 	"\x04arg2\x18\x03 \x01(\x01R\x04arg2\x12\x1c\n" +
 	"\toperation\x18\x04 \x01(\tR\toperation\x12%\n" +
-	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\"7\n" +
+	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\"M\n" +
 	"\rResultRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
-	"\x06result\x18\x02 \x01(\x01R\x06result\"\x16\n" +
+	"\x06result\x18\x02 \x01(\x01R\x06result\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\x16\n" +
 	"\x14SubmitResultResponse2\xa4\x01\n" +
 	"\fOrchestrator\x12C\n" +
 	"\aGetTask\x12\x1c.orchestrator.GetTaskRequest\x1a\x1a.orchestrator.TaskResponse\x12O\n" +